@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"ipmsg/pkg/alias"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const aliasUsage = `usage:
+  ipmsg alias [list] [--json]                     show contacts
+  ipmsg alias show <name|address> [--json]        show contact
+  ipmsg alias add <name> [address...] [flags]     add contact, or addresses and details to existing one
+  ipmsg alias rm <name|address> [address...]      remove addresses, or whole contact
+  ipmsg alias rename <name|address> <new name>    rename contact
+  ipmsg alias claims [--json]                     show names sent by peers which wait for approval
+  ipmsg alias approve <id>                        apply claim, address goes to claimed name
+  ipmsg alias reject <id>                         drop claim, the same claim is ignored later
+
+add flags:
+  --notes <text>            notes about contact
+  --groups <a,b>            add contact to groups
+  --fingerprint <value>     fingerprint of contact key
+  --port <port>             preferred port, 0 for default one
+  --current                 make first given address current one, messages are sent to it
+
+names with spaces must be quoted, contacts are kept in ~/ipmsg/contacts.json,
+which claims wait for approval is set by server --claim_policy`
+
+func runAlias(path string, args []string) {
+	al := alias.New(path)
+
+	cmd := "list"
+	if len(args) > 0 {
+		cmd = args[0]
+		args = args[1:]
+	}
+
+	switch cmd {
+	case "list", "ls":
+		fs := flag.NewFlagSet("alias list", flag.ExitOnError)
+		fs.Usage = func() { fmt.Println(aliasUsage) }
+		asJSON := fs.Bool("json", false, "")
+		fs.Parse(args)
+
+		contacts, err := al.Contacts()
+		if err != nil {
+			fmt.Println("failed read contacts, err: " + err.Error())
+			os.Exit(1)
+		}
+		sort.SliceStable(contacts, func(i, j int) bool {
+			return strings.ToLower(contacts[i].Name) < strings.ToLower(contacts[j].Name)
+		})
+
+		if *asJSON {
+			printJSON(contacts)
+			return
+		}
+
+		if len(contacts) == 0 {
+			fmt.Println("No contacts")
+			return
+		}
+
+		fmt.Printf("%-20s %-34s %-6s %s\n", "NAME", "ADDRESSES", "PORT", "GROUPS")
+		for _, c := range contacts {
+			port := ""
+			if c.Port != 0 {
+				port = strconv.Itoa(c.Port)
+			}
+			fmt.Printf("%-20s %-34s %-6s %s\n", c.Name, strings.Join(c.Addresses, ", "), port, strings.Join(c.Groups, ", "))
+		}
+
+	case "show":
+		fs := flag.NewFlagSet("alias show", flag.ExitOnError)
+		fs.Usage = func() { fmt.Println(aliasUsage) }
+		asJSON := fs.Bool("json", false, "")
+		rest := parseMixed(fs, args)
+
+		if len(rest) != 1 {
+			fmt.Println(aliasUsage)
+			os.Exit(1)
+		}
+
+		c, err := al.Get(rest[0])
+		if err != nil {
+			fmt.Println("failed find contact, err: " + err.Error())
+			os.Exit(1)
+		}
+
+		if *asJSON {
+			printJSON(c)
+			return
+		}
+
+		fmt.Printf("Name:        %s\n", c.Name)
+		fmt.Printf("Address:     %s\n", c.Address())
+		if len(c.Addresses) > 1 {
+			fmt.Printf("Also:        %s\n", strings.Join(c.Addresses[1:], ", "))
+		}
+		if c.Port != 0 {
+			fmt.Printf("Port:        %d\n", c.Port)
+		}
+		if len(c.Groups) > 0 {
+			fmt.Printf("Groups:      %s\n", strings.Join(c.Groups, ", "))
+		}
+		if c.Fingerprint != "" {
+			fmt.Printf("Fingerprint: %s\n", c.Fingerprint)
+		}
+		if c.Notes != "" {
+			fmt.Printf("Notes:       %s\n", c.Notes)
+		}
+		for _, m := range c.Moves {
+			fmt.Printf("Moved:       %s -> %s at %s\n", m.From, m.To, m.At.Local().Format(time.DateTime))
+		}
+
+	case "add":
+		fs := flag.NewFlagSet("alias add", flag.ExitOnError)
+		fs.Usage = func() { fmt.Println(aliasUsage) }
+		notes := fs.String("notes", "", "")
+		groupList := fs.String("groups", "", "")
+		fingerprint := fs.String("fingerprint", "", "")
+		port := fs.Int("port", 0, "")
+		current := fs.Bool("current", false, "")
+		rest := parseMixed(fs, args)
+
+		if len(rest) < 1 {
+			fmt.Println(aliasUsage)
+			os.Exit(1)
+		}
+		name, addrs := rest[0], rest[1:]
+
+		set := map[string]bool{}
+		fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+		edit := func(c *alias.Contact) {
+			if *current {
+				c.Addresses = append(addrs, c.Addresses...)
+			} else {
+				c.Addresses = append(c.Addresses, addrs...)
+			}
+			if set["notes"] {
+				c.Notes = *notes
+			}
+			if set["groups"] {
+				c.Groups = append(c.Groups, strings.Split(*groupList, ",")...)
+			}
+			if set["fingerprint"] {
+				c.Fingerprint = *fingerprint
+			}
+			if set["port"] {
+				c.Port = *port
+			}
+		}
+
+		_, err := al.Update(name, edit)
+		if errors.Is(err, alias.ErrNotFound) {
+			c := alias.Contact{Name: name}
+			edit(&c)
+			err = al.Put(c)
+		}
+		if err != nil {
+			fmt.Println("failed save contact, err: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Contact %s saved\n", name)
+
+	case "rm":
+		if len(args) < 1 {
+			fmt.Println(aliasUsage)
+			os.Exit(1)
+		}
+
+		if len(args) == 1 {
+			if err := al.Remove(args[0]); err != nil {
+				fmt.Println("failed remove contact, err: " + err.Error())
+				os.Exit(1)
+			}
+			fmt.Printf("Contact %s removed\n", args[0])
+			return
+		}
+
+		c, err := al.Update(args[0], func(c *alias.Contact) {
+			var kept []string
+			for _, a := range c.Addresses {
+				if !containsFold(args[1:], a) {
+					kept = append(kept, a)
+				}
+			}
+			c.Addresses = kept
+		})
+		if err != nil {
+			fmt.Println("failed remove addresses, err: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Contact %s updated\n", c.Name)
+
+	case "rename":
+		if len(args) != 2 {
+			fmt.Println(aliasUsage)
+			os.Exit(1)
+		}
+
+		if err := al.Rename(args[0], args[1]); err != nil {
+			fmt.Println("failed rename contact, err: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Contact %s renamed to %s\n", args[0], args[1])
+
+	case "claims":
+		fs := flag.NewFlagSet("alias claims", flag.ExitOnError)
+		fs.Usage = func() { fmt.Println(aliasUsage) }
+		asJSON := fs.Bool("json", false, "")
+		fs.Parse(args)
+
+		claims, err := al.Claims()
+		if err != nil {
+			fmt.Println("failed read claims, err: " + err.Error())
+			os.Exit(1)
+		}
+
+		if *asJSON {
+			printJSON(claims)
+			return
+		}
+
+		if len(claims) == 0 {
+			fmt.Println("No claims")
+			return
+		}
+
+		fmt.Printf("%-12s %-20s %-16s %-19s %s\n", "ID", "NAME", "ADDRESS", "LAST", "REASON")
+		for _, p := range claims {
+			fmt.Printf("%-12s %-20s %-16s %-19s %s\n", p.ID, p.Name, p.Address, p.At.Local().Format(time.DateTime), claimReason(p))
+		}
+
+	case "approve":
+		if len(args) != 1 {
+			fmt.Println(aliasUsage)
+			os.Exit(1)
+		}
+
+		res, err := al.Approve(args[0])
+		if err != nil {
+			fmt.Println("failed approve claim, err: " + err.Error())
+			os.Exit(1)
+		}
+
+		switch {
+		case res.Added != nil:
+			fmt.Printf("Contact %s added with address %s\n", res.Added.Name, res.Added.Address())
+		case res.Move != nil:
+			fmt.Printf("Contact moved from %s to %s\n", res.Move.From, res.Move.To)
+		default:
+			fmt.Println("Claim approved")
+		}
+
+	case "reject":
+		if len(args) != 1 {
+			fmt.Println(aliasUsage)
+			os.Exit(1)
+		}
+
+		if err := al.Reject(args[0]); err != nil {
+			fmt.Println("failed reject claim, err: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("Claim rejected")
+
+	default:
+		fmt.Println(aliasUsage)
+		os.Exit(1)
+	}
+}
+
+/* ======== internal ======== */
+
+// parseMixed parses flags placed before, between or after arguments, returns arguments
+func parseMixed(fs *flag.FlagSet, args []string) []string {
+	var rest []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return rest
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// claimReason explains why claim waits for approval
+func claimReason(p alias.Pending) string {
+	res := p.Reason
+	switch p.Reason {
+	case alias.ReasonNew:
+		res = "new name"
+	case alias.ReasonTaken:
+		res = "address is " + p.Owner
+	case alias.ReasonMoved:
+		res = "new address, not signed by contact key"
+		if p.Owner != "" && !strings.EqualFold(p.Owner, p.Name) {
+			res = "new address, it is " + p.Owner
+		}
+	}
+
+	if p.Signer != "" {
+		res += ", signed " + p.Signer
+	}
+	return res
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"ipmsg/pkg/apiclient"
+	"ipmsg/pkg/store"
+	"os"
+	"path/filepath"
+)
+
+const archiveUsage = `usage:
+  ipmsg archive [list]   show archived months
+  ipmsg archive rotate   apply ~/ipmsg/retention.json right now (server must be running)
+
+archive is in ~/ipmsg/archive, one compressed file per month,
+search and history see archived messages as usual`
+
+func runArchive(args []string) {
+	cmd := "list"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "list", "ls":
+		userHome, err := os.UserHomeDir()
+		if err != nil {
+			fmt.Println("failed get home dir, err: " + err.Error())
+			os.Exit(1)
+		}
+
+		files, err := store.NewArchive(filepath.Join(userHome, "ipmsg", "archive")).Files()
+		if err != nil {
+			fmt.Println("failed read archive, err: " + err.Error())
+			os.Exit(1)
+		}
+
+		if len(files) == 0 {
+			fmt.Println("Archive is empty")
+			return
+		}
+
+		for _, f := range files {
+			fmt.Printf("%-8s %10s  %s\n", f.Month, humanSize(f.Size), f.Path)
+		}
+
+	case "rotate":
+		socket, err := apiclient.DefaultSocket()
+		if err != nil {
+			fmt.Println("failed get home dir, err: " + err.Error())
+			os.Exit(1)
+		}
+
+		res, err := apiclient.NewUnix(socket).Rotate()
+		if err != nil {
+			fmt.Println("failed rotate history, is server running? err: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Archived %d messages, deleted %d\n", res.Archived, res.Deleted)
+
+	default:
+		fmt.Println(archiveUsage)
+		os.Exit(1)
+	}
+}
+
+func humanSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"ipmsg/pkg/alias"
+	"ipmsg/pkg/models"
+	"ipmsg/pkg/netscan"
+	"ipmsg/pkg/sender"
+	"ipmsgcli/internal/cache"
+	"os"
+	"strings"
+	"time"
+)
+
+const contactsUsage = `usage:
+  ipmsg contacts export [flags]              write contacts to file
+  ipmsg contacts import <file> [flags]       merge contacts from file
+  ipmsg contacts request <peer> [flags]      ask peer for contacts it publishes and merge them
+
+export flags:
+  --out <file>              file to write, default stdout
+  --group <name>            only contacts of group
+  --no_notes                leave notes out
+
+import and request flags:
+  --overwrite               take their values on conflicts, addresses of other contacts too
+  --dry_run                 only show what would change
+  --out <file>              request only: save received contacts to file instead of merging
+  --port <port>             request only: port of peer, default 6767
+
+peer publishes contacts only if its server runs with --share_contacts`
+
+func runContacts(aliasPath, cachePath string, args []string) {
+	al := alias.New(aliasPath)
+
+	if len(args) == 0 {
+		fmt.Println(contactsUsage)
+		os.Exit(1)
+	}
+	cmd, args := args[0], args[1:]
+
+	switch cmd {
+	case "export":
+		fs := flag.NewFlagSet("contacts export", flag.ExitOnError)
+		fs.Usage = func() { fmt.Println(contactsUsage) }
+		out := fs.String("out", "", "")
+		group := fs.String("group", "", "")
+		noNotes := fs.Bool("no_notes", false, "")
+		fs.Parse(args)
+
+		contacts, err := al.Contacts()
+		if err != nil {
+			fmt.Println("failed read contacts, err: " + err.Error())
+			os.Exit(1)
+		}
+
+		var list []alias.Contact
+		for _, c := range contacts {
+			if *group != "" && !containsFold(c.Groups, *group) {
+				continue
+			}
+			if *noNotes {
+				c.Notes = ""
+			}
+			list = append(list, c)
+		}
+
+		if err := writeExport(*out, alias.NewExport(list)); err != nil {
+			fmt.Println("failed write contacts, err: " + err.Error())
+			os.Exit(1)
+		}
+		if *out != "" {
+			fmt.Printf("Exported %d contacts to %s\n", len(list), *out)
+		}
+
+	case "import":
+		fs := flag.NewFlagSet("contacts import", flag.ExitOnError)
+		fs.Usage = func() { fmt.Println(contactsUsage) }
+		overwrite := fs.Bool("overwrite", false, "")
+		dryRun := fs.Bool("dry_run", false, "")
+		rest := parseMixed(fs, args)
+
+		if len(rest) != 1 {
+			fmt.Println(contactsUsage)
+			os.Exit(1)
+		}
+
+		file, err := os.Open(rest[0])
+		if err != nil {
+			fmt.Println("failed open file, err: " + err.Error())
+			os.Exit(1)
+		}
+		defer file.Close()
+
+		e, err := alias.ReadExport(file)
+		if err != nil {
+			fmt.Println("failed read contacts, err: " + err.Error())
+			os.Exit(1)
+		}
+
+		mergeContacts(al, e.Contacts, *overwrite, *dryRun)
+
+	case "request":
+		fs := flag.NewFlagSet("contacts request", flag.ExitOnError)
+		fs.Usage = func() { fmt.Println(contactsUsage) }
+		overwrite := fs.Bool("overwrite", false, "")
+		dryRun := fs.Bool("dry_run", false, "")
+		out := fs.String("out", "", "")
+		port := fs.Uint("port", 6767, "")
+		rest := parseMixed(fs, args)
+
+		if len(rest) != 1 {
+			fmt.Println(contactsUsage)
+			os.Exit(1)
+		}
+
+		peer := rest[0]
+		if c, err := al.Get(peer); err == nil && c.Address() != "" {
+			peer = c.Address()
+			if c.Port != 0 && !flagSet(fs, "port") {
+				*port = uint(c.Port)
+			}
+		}
+
+		e, err := requestContacts(sender.Addr(peer, *port), cachePath)
+		if err != nil {
+			fmt.Println("failed request contacts, err: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("%s shared %d contacts\n", peer, len(e.Contacts))
+
+		if *out != "" {
+			if err := writeExport(*out, e); err != nil {
+				fmt.Println("failed write contacts, err: " + err.Error())
+				os.Exit(1)
+			}
+			fmt.Println("Saved to " + *out)
+			return
+		}
+
+		mergeContacts(al, e.Contacts, *overwrite, *dryRun)
+
+	default:
+		fmt.Println(contactsUsage)
+		os.Exit(1)
+	}
+}
+
+/* ======== internal ======== */
+
+// requestContacts asks server at addr for contacts it publishes
+func requestContacts(addr, cachePath string) (alias.Export, error) {
+	myIP, err := netscan.LocalIP()
+	if err != nil {
+		return alias.Export{}, err
+	}
+
+	req := models.IPmsgRequest{Kind: models.KindContacts, From: myIP, Date: time.Now().Unix()}
+	if c, err := cache.New(cachePath); err == nil {
+		req.Alias, _ = c.GetName()
+	}
+	sign(&req)
+
+	data, err := sender.Request(addr, &req, 5*time.Second)
+	if err != nil {
+		return alias.Export{}, err
+	}
+
+	return alias.ReadExport(strings.NewReader(data))
+}
+
+func mergeContacts(al *alias.Alias, contacts []alias.Contact, overwrite, dryRun bool) {
+	report, err := al.Merge(contacts, overwrite, dryRun)
+	if err != nil {
+		fmt.Println("failed merge contacts, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	if dryRun {
+		fmt.Println("Dry run, nothing is changed")
+	}
+	fmt.Printf("Added %d, updated %d, unchanged %d, conflicts %d\n", len(report.Added), len(report.Updated), report.Unchanged, len(report.Conflicts))
+	if len(report.Added) > 0 {
+		fmt.Println("added:   " + strings.Join(report.Added, ", "))
+	}
+	if len(report.Updated) > 0 {
+		fmt.Println("updated: " + strings.Join(report.Updated, ", "))
+	}
+
+	for _, c := range report.Conflicts {
+		switch c.Field {
+		case "address":
+			fmt.Printf("conflict: %s: address %s belongs to %s\n", c.Name, c.Theirs, c.Ours)
+		case "contact":
+			fmt.Printf("conflict: %s: skipped, %s\n", c.Name, c.Theirs)
+		default:
+			fmt.Printf("conflict: %s: %s is %q, theirs %q\n", c.Name, c.Field, c.Ours, c.Theirs)
+		}
+	}
+	if len(report.Conflicts) > 0 && !overwrite {
+		fmt.Println("our values are kept, use --overwrite to take theirs")
+	}
+}
+
+func writeExport(path string, e alias.Export) error {
+	var w io.Writer = os.Stdout
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return alias.WriteExport(w, e)
+}
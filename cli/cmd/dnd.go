@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"ipmsg/pkg/dnd"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dndUsage = `usage:
+  ipmsg dnd [status]                       show do not disturb state
+  ipmsg dnd on [--for 1h]                  turn on, forever if duration is not set
+  ipmsg dnd off                            turn off, server notifies with summary of missed messages
+  ipmsg dnd quiet add <days> <HH:MM-HH:MM> add quiet hours (days: mon,tue,... or all)
+  ipmsg dnd quiet rm <n>                   remove quiet hours by number from status
+  ipmsg dnd allow <name|address>           always notify about messages from contact or address
+  ipmsg dnd deny <name|address>            remove sender from allowed
+
+messages are saved as usual during do not disturb, only notifications are suppressed,
+high priority messages always notify`
+
+func runDnd(path string, args []string) {
+	s, err := dnd.Load(path)
+	if err != nil {
+		fmt.Println("failed read do not disturb settings, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	cmd := "status"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "status":
+		printDnd(&s)
+		return
+
+	case "on":
+		fs := flag.NewFlagSet("dnd on", flag.ExitOnError)
+		dur := fs.Duration("for", 0, "how long do not disturb lasts (example: 1h30m)")
+		fs.Parse(args[1:])
+
+		s.Until = dnd.Forever
+		if *dur > 0 {
+			s.Until = time.Now().Add(*dur)
+		}
+
+	case "off":
+		s.Until = time.Time{}
+
+	case "quiet":
+		if len(args) < 3 {
+			fmt.Println(dndUsage)
+			os.Exit(1)
+		}
+
+		switch args[1] {
+		case "add":
+			if len(args) < 4 {
+				fmt.Println(dndUsage)
+				os.Exit(1)
+			}
+
+			from, to, ok := strings.Cut(args[3], "-")
+			if !ok {
+				fmt.Printf("invalid quiet hours %q, use HH:MM-HH:MM\n", args[3])
+				os.Exit(1)
+			}
+			q := dnd.QuietHour{From: from, To: to}
+			if args[2] != "all" {
+				q.Days = strings.Split(args[2], ",")
+			}
+			if err := q.Validate(); err != nil {
+				fmt.Printf("invalid quiet hours: %v\n", err)
+				os.Exit(1)
+			}
+			s.QuietHours = append(s.QuietHours, q)
+
+		case "rm":
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < 1 || n > len(s.QuietHours) {
+				fmt.Println("invalid quiet hours number")
+				os.Exit(1)
+			}
+			s.QuietHours = slices.Delete(s.QuietHours, n-1, n)
+
+		default:
+			fmt.Println(dndUsage)
+			os.Exit(1)
+		}
+
+	case "allow", "deny":
+		if len(args) < 2 {
+			fmt.Println(dndUsage)
+			os.Exit(1)
+		}
+
+		for _, who := range args[1:] {
+			s.AllowFrom = slices.DeleteFunc(s.AllowFrom, func(a string) bool { return a == who })
+			if cmd == "allow" {
+				s.AllowFrom = append(s.AllowFrom, who)
+			}
+		}
+
+	default:
+		fmt.Println(dndUsage)
+		os.Exit(1)
+	}
+
+	if err := dnd.Save(path, s); err != nil {
+		fmt.Println("failed save do not disturb settings, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	printDnd(&s)
+}
+
+func printDnd(s *dnd.Settings) {
+	if active, reason := s.Active(time.Now()); active {
+		fmt.Println("Do not disturb: ON (" + reason + ")")
+	} else {
+		fmt.Println("Do not disturb: off")
+	}
+
+	if len(s.QuietHours) > 0 {
+		fmt.Println("Quiet hours:")
+		for i, q := range s.QuietHours {
+			days := "every day"
+			if len(q.Days) > 0 {
+				days = strings.Join(q.Days, ",")
+			}
+			fmt.Printf("  %d. %s-%s %s\n", i+1, q.From, q.To, days)
+		}
+	}
+
+	if len(s.AllowPriority) > 0 {
+		fmt.Println("Always notify priority: " + strings.Join(s.AllowPriority, ", "))
+	}
+	if len(s.AllowFrom) > 0 {
+		fmt.Println("Always notify from: " + strings.Join(s.AllowFrom, ", "))
+	}
+}
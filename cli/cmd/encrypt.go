@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"ipmsg/pkg/alias"
+	"ipmsg/pkg/crypt"
+	"ipmsg/pkg/importer"
+	"ipmsg/pkg/models"
+	"ipmsg/pkg/store"
+	"os"
+	"path/filepath"
+)
+
+const encryptUsage = `usage:
+  ipmsg encrypt [flags]
+
+flags:
+  --jsonl <file>            encrypt json lines store instead of ~/ipmsg.txt
+  --conversations <dir>     encrypt conversations store instead of ~/ipmsg.txt
+  --out <file>              encrypted store, default ~/ipmsg/messages.enc
+  --key_file <file>         file with passphrase, otherwise IPMSG_PASSPHRASE or prompt
+  --archive                 also encrypt files in ~/ipmsg/archive
+  --remove                  remove plain history once it is encrypted
+
+messages are merged into encrypted store, messages already there are skipped,
+then start server with --store encrypted, stop server before encryption`
+
+const decryptUsage = `usage:
+  ipmsg decrypt [flags]
+
+flags:
+  --in <file>               encrypted store, default ~/ipmsg/messages.enc
+  --jsonl <file>            write json lines store, default ~/ipmsg/messages.jsonl
+  --text <file>             write text table instead, it does not keep ids and flags
+  --key_file <file>         file with passphrase, otherwise IPMSG_PASSPHRASE or prompt
+  --archive                 also decrypt files in ~/ipmsg/archive
+
+messages are merged into plain store, messages already there are skipped,
+stop server before decryption`
+
+func runEncrypt(args []string) {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	fs.Usage = func() { fmt.Println(encryptUsage) }
+	jsonlPath := fs.String("jsonl", "", "")
+	convDir := fs.String("conversations", "", "")
+	out := fs.String("out", "", "")
+	keyFile := fs.String("key_file", "", "")
+	withArchive := fs.Bool("archive", false, "")
+	remove := fs.Bool("remove", false, "")
+	fs.Parse(args)
+
+	if serverRunning() {
+		fmt.Println("server is running, stop it before encryption so it does not write to history at the same time")
+		os.Exit(1)
+	}
+
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		fmt.Println("failed get home dir, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	als := alias.New(filepath.Join(userHome, "ipmsg", alias.FileName))
+
+	sourcePath := filepath.Join(userHome, "ipmsg.txt")
+	var source store.Store = store.NewText(sourcePath, als.GetNames)
+	switch {
+	case *jsonlPath != "":
+		sourcePath = *jsonlPath
+		source = store.NewJSONL(sourcePath)
+	case *convDir != "":
+		sourcePath = *convDir
+		source = store.NewConversations(sourcePath)
+	}
+
+	msgs, err := store.Filter(source, store.Query{})
+	if err != nil {
+		fmt.Println("failed read history, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	if *out == "" {
+		*out = filepath.Join(userHome, "ipmsg", "messages.enc")
+	}
+
+	enc, err := unlockStore(*out, *keyFile)
+	if err != nil {
+		fmt.Println("failed open encrypted store, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	added, err := mergeHistory(enc, msgs)
+	if err != nil {
+		fmt.Println("failed write encrypted store, err: " + err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("Encrypted %d messages into %s, %d already there\n", added, *out, len(msgs)-added)
+
+	if *withArchive {
+		archiveDir := filepath.Join(userHome, "ipmsg", "archive")
+		n, err := store.NewArchive(archiveDir).Convert(store.NewEncryptedArchive(archiveDir, enc.Key()))
+		if err != nil {
+			fmt.Println("failed encrypt archive, err: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Encrypted %d archived messages\n", n)
+	}
+
+	if *remove {
+		if err := os.RemoveAll(sourcePath); err != nil {
+			fmt.Println("failed remove plain history, err: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Println("Removed " + sourcePath)
+	}
+
+	fmt.Println("Start server with --store encrypted to use it")
+}
+
+func runDecrypt(args []string) {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	fs.Usage = func() { fmt.Println(decryptUsage) }
+	in := fs.String("in", "", "")
+	jsonlPath := fs.String("jsonl", "", "")
+	textPath := fs.String("text", "", "")
+	keyFile := fs.String("key_file", "", "")
+	withArchive := fs.Bool("archive", false, "")
+	fs.Parse(args)
+
+	if serverRunning() {
+		fmt.Println("server is running, stop it before decryption so it does not write to history at the same time")
+		os.Exit(1)
+	}
+
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		fmt.Println("failed get home dir, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	if *in == "" {
+		*in = filepath.Join(userHome, "ipmsg", "messages.enc")
+	}
+	if _, err := store.ReadHeader(*in); err != nil {
+		fmt.Println("failed read encrypted store, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	enc, err := unlockStore(*in, *keyFile)
+	if err != nil {
+		fmt.Println("failed open encrypted store, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	msgs, err := store.Filter(enc, store.Query{})
+	if err != nil {
+		fmt.Println("failed read encrypted store, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	var target store.Store
+	targetPath := *jsonlPath
+	switch {
+	case *textPath != "":
+		targetPath = *textPath
+		target = store.NewText(targetPath, alias.New(filepath.Join(userHome, "ipmsg", alias.FileName)).GetNames)
+	default:
+		if targetPath == "" {
+			targetPath = filepath.Join(userHome, "ipmsg", "messages.jsonl")
+		}
+		target = store.NewJSONL(targetPath)
+	}
+
+	added, err := mergeHistory(target, msgs)
+	if err != nil {
+		fmt.Println("failed write history, err: " + err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("Decrypted %d messages into %s, %d already there\n", added, targetPath, len(msgs)-added)
+
+	if *withArchive {
+		archiveDir := filepath.Join(userHome, "ipmsg", "archive")
+		n, err := store.NewEncryptedArchive(archiveDir, enc.Key()).Convert(store.NewArchive(archiveDir))
+		if err != nil {
+			fmt.Println("failed decrypt archive, err: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Decrypted %d archived messages\n", n)
+	}
+}
+
+/* ======== internal ======== */
+
+// unlockStore opens encrypted store, passphrase of new store is asked twice
+func unlockStore(path, keyFile string) (*store.JSONL, error) {
+	_, err := store.ReadHeader(path)
+	isNew := errors.Is(err, os.ErrNotExist)
+	if err != nil && !isNew {
+		return nil, err
+	}
+
+	pass, err := crypt.Passphrase(keyFile, isNew)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.OpenEncryptedJSONL(path, pass)
+}
+
+// mergeHistory adds msgs which are not in primary yet, returns number of added messages
+func mergeHistory(primary store.Store, msgs []models.Message) (int, error) {
+	current, err := store.Filter(primary, store.Query{})
+	if err != nil {
+		return 0, err
+	}
+
+	fresh := importer.Unseen(current, msgs)
+	if len(fresh) == 0 {
+		return 0, nil
+	}
+
+	replacer, ok := primary.(store.Replacer)
+	if !ok {
+		return 0, store.ErrUnsupported
+	}
+
+	return len(fresh), replacer.Replace(importer.Merge(current, fresh))
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"ipmsg/pkg/alias"
+	"ipmsg/pkg/export"
+	"ipmsg/pkg/models"
+	"ipmsg/pkg/store"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const exportUsage = `usage:
+  ipmsg export [flags]
+
+flags:
+  --with <alias|address>  only conversation with peer
+  --group <name>          only messages of group members (see ipmsg group)
+  --since <date>          2024-01-31, 2024-01 or 30d (days ago)
+  --until <date>          same formats, not inclusive
+  --format <format>       html, md, json or csv, by default taken from --out extension
+  --out <file>            file to write, stdout by default
+  --title <text>          title of html and markdown page
+
+messages are read from store of server (~/ipmsg/store.json, ~/ipmsg.txt if missing) and archive, examples:
+  ipmsg export --with alex --since 2024-03 --out alex.html
+  ipmsg export --since 7d --format csv > week.csv`
+
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.Usage = func() { fmt.Println(exportUsage) }
+	with := fs.String("with", "", "")
+	group := fs.String("group", "", "")
+	since := fs.String("since", "", "")
+	until := fs.String("until", "", "")
+	format := fs.String("format", "", "")
+	out := fs.String("out", "", "")
+	title := fs.String("title", "", "")
+	fs.Parse(args)
+
+	if *format == "" {
+		*format = export.FormatOf(*out)
+	}
+	if *format == "" {
+		fmt.Println("unknown format, set --format html, md, json or csv")
+		os.Exit(1)
+	}
+
+	var q store.Query
+	var err error
+	if q.Since, err = parseDate(*since); err != nil {
+		fmt.Println("invalid --since, err: " + err.Error())
+		os.Exit(1)
+	}
+	if q.Until, err = parseDate(*until); err != nil {
+		fmt.Println("invalid --until, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		fmt.Println("failed get home dir, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	als := alias.New(filepath.Join(userHome, "ipmsg", alias.FileName))
+	names, err := als.GetNames()
+	if err != nil {
+		fmt.Println("failed get aliases, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	contacts, err := als.Contacts()
+	if err != nil {
+		fmt.Println("failed get aliases, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	var peers []string
+	if *with != "" {
+		peers = alias.Expand(contacts, *with)
+	}
+	if *group != "" {
+		members := alias.Members(contacts, *group)
+		if len(members) == 0 {
+			fmt.Printf("failed get group, err: %s: %s\n", *group, alias.ErrGroupNotFound)
+			os.Exit(1)
+		}
+		peers = append(peers, alias.Expand(contacts, members...)...)
+	}
+
+	history, err := localHistory(userHome, als)
+	if err != nil {
+		fmt.Println("failed open history, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	all, err := store.Filter(history, q)
+	if err != nil {
+		fmt.Println("failed read messages, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	msgs := all[:0]
+	for _, m := range all {
+		if involvesAny(&m, peers) {
+			msgs = append(msgs, m)
+		}
+	}
+
+	if *title == "" {
+		*title = exportTitle(*with, *group, *since, *until)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			fmt.Println("failed create export file, err: " + err.Error())
+			os.Exit(1)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := export.Write(w, *format, msgs, export.Options{Title: *title, Names: names}); err != nil {
+		fmt.Println("failed export messages, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	if *out != "" {
+		fmt.Printf("Exported %d messages to %s\n", len(msgs), *out)
+	}
+}
+
+/* ======== internal ======== */
+
+// localHistory is store server writes to with archive, used without server. Store config saved
+// by server is followed, history is in ~/ipmsg.txt if server never saved it. Encrypted store
+// is unlocked with its key file, IPMSG_PASSPHRASE or prompt, plain file is never used instead
+func localHistory(userHome string, als *alias.Alias) (store.Store, error) {
+	cfg, err := store.LoadConfig(filepath.Join(userHome, "ipmsg", store.ConfigFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		cfg = &store.Config{
+			Kind:    "text",
+			Path:    filepath.Join(userHome, "ipmsg.txt"),
+			Archive: filepath.Join(userHome, "ipmsg", "archive"),
+		}
+		// store encrypted before server saved config must not be bypassed
+		if enc := filepath.Join(userHome, "ipmsg", "messages.enc"); fileExists(enc) {
+			cfg.Kind, cfg.Path = "encrypted", enc
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	var primary store.Store
+	archive := store.NewArchive(cfg.Archive)
+	switch cfg.Kind {
+	case "text":
+		primary = store.NewText(cfg.Path, als.GetNames)
+	case "jsonl":
+		primary = store.NewJSONL(cfg.Path)
+	case "conversations":
+		primary = store.NewConversations(cfg.Path)
+	case "encrypted":
+		if _, err := store.ReadHeader(cfg.Path); err != nil {
+			return nil, fmt.Errorf("encrypted store %s: %w", cfg.Path, err)
+		}
+		enc, err := unlockStore(cfg.Path, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		primary = enc
+		archive = store.NewEncryptedArchive(cfg.Archive, enc.Key())
+	default:
+		return nil, fmt.Errorf("unknown store %q in %s", cfg.Kind, store.ConfigFileName)
+	}
+
+	if cfg.TextView != "" {
+		view := store.WithViews(primary, store.NewText(cfg.TextView, als.GetNames))
+		view.OnError = func(err error) {
+			fmt.Println("failed render message to text view, err: " + err.Error())
+		}
+		primary = view
+	}
+
+	return store.WithArchive(primary, archive), nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// involvesAny reports if msg involves one of peers, empty peers match everything
+func involvesAny(msg *models.Message, peers []string) bool {
+	if len(peers) == 0 {
+		return true
+	}
+
+	for _, p := range peers {
+		if msg.Involves(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func exportTitle(with, group, since, until string) string {
+	title := "IPmsg history"
+	switch {
+	case with != "":
+		title += " with " + with
+	case group != "":
+		title += " of group " + group
+	}
+
+	var dates []string
+	if since != "" {
+		dates = append(dates, "since "+since)
+	}
+	if until != "" {
+		dates = append(dates, "until "+until)
+	}
+	if len(dates) > 0 {
+		title += ", " + strings.Join(dates, " ")
+	}
+
+	return title
+}
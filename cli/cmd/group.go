@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"ipmsg/pkg/alias"
+	"os"
+	"sort"
+	"strings"
+)
+
+const groupUsage = `usage:
+  ipmsg group [list]                     show groups
+  ipmsg group add <group> <member...>    add contacts (names or addresses) to group
+  ipmsg group rm <group> [member...]     remove members, or whole group
+
+groups are kept in contacts, see 'ipmsg alias show <name>', unknown ip address becomes contact named by it`
+
+func runGroup(path string, args []string) {
+	al := alias.New(path)
+
+	cmd := "list"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "list", "ls":
+		list, err := al.Groups()
+		if err != nil {
+			fmt.Println("failed read groups, err: " + err.Error())
+			os.Exit(1)
+		}
+
+		if len(list) == 0 {
+			fmt.Println("No groups")
+			return
+		}
+
+		names := make([]string, 0, len(list))
+		for name := range list {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			fmt.Printf("%-20s %s\n", name, strings.Join(list[name], ", "))
+		}
+
+	case "add":
+		if len(args) < 3 {
+			fmt.Println(groupUsage)
+			os.Exit(1)
+		}
+
+		if err := al.AddToGroup(args[1], args[2:]...); err != nil {
+			fmt.Println("failed save group, err: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Group %s updated\n", args[1])
+
+	case "rm":
+		if len(args) < 2 {
+			fmt.Println(groupUsage)
+			os.Exit(1)
+		}
+
+		if err := al.RemoveFromGroup(args[1], args[2:]...); err != nil {
+			fmt.Println("failed remove from group, err: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Group %s updated\n", args[1])
+
+	default:
+		fmt.Println(groupUsage)
+		os.Exit(1)
+	}
+}
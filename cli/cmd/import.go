@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"ipmsg/pkg/alias"
+	"ipmsg/pkg/apiclient"
+	"ipmsg/pkg/importer"
+	"ipmsg/pkg/models"
+	"ipmsg/pkg/store"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const importUsage = `usage:
+  ipmsg import [flags] <file>...
+
+flags:
+  --format <format>         ipmsglog (classic IP Messenger ipmsg.log), mbox or csv, detected by default
+  --encoding <name>         encoding of files like shift_jis or cp1251, detected by default
+  --me <addr,...>           own mail addresses, mbox messages from them are imported as sent
+  --jsonl <file>            import into json lines store instead of ~/ipmsg.txt
+  --conversations <dir>     import into conversations store instead of ~/ipmsg.txt
+  --dry-run                 only show what would be imported
+
+messages are merged into history by date, messages already in history or archive are skipped,
+senders known by alias file get their aliases, stop server before import`
+
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.Usage = func() { fmt.Println(importUsage) }
+	format := fs.String("format", "", "")
+	encoding := fs.String("encoding", "", "")
+	me := fs.String("me", "", "")
+	jsonlPath := fs.String("jsonl", "", "")
+	convDir := fs.String("conversations", "", "")
+	dryRun := fs.Bool("dry-run", false, "")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Println(importUsage)
+		os.Exit(1)
+	}
+
+	if serverRunning() && !*dryRun {
+		fmt.Println("server is running, stop it before import so it does not write to history at the same time")
+		os.Exit(1)
+	}
+
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		fmt.Println("failed get home dir, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	als := alias.New(filepath.Join(userHome, "ipmsg", alias.FileName))
+	names, err := als.GetNames()
+	if err != nil {
+		fmt.Println("failed get aliases, err: " + err.Error())
+		os.Exit(1)
+	}
+	addresses, err := als.GetAddresses()
+	if err != nil {
+		fmt.Println("failed get aliases, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	opt := importer.Options{Encoding: *encoding}
+	if *me != "" {
+		opt.Me = strings.Split(*me, ",")
+	}
+
+	var imported []models.Message
+	for _, filename := range fs.Args() {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Println("failed read file, err: " + err.Error())
+			os.Exit(1)
+		}
+
+		msgs, err := importer.Parse(filename, data, *format, opt)
+		if err != nil {
+			fmt.Println("failed parse file, err: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("%s: %d messages\n", filename, len(msgs))
+
+		imported = append(imported, msgs...)
+	}
+	importer.MapAliases(imported, names, addresses)
+
+	var primary store.Store = store.NewText(filepath.Join(userHome, "ipmsg.txt"), als.GetNames)
+	switch {
+	case *jsonlPath != "":
+		primary = store.NewJSONL(*jsonlPath)
+	case *convDir != "":
+		primary = store.NewConversations(*convDir)
+	}
+
+	history, err := store.Filter(store.WithArchive(primary, store.NewArchive(filepath.Join(userHome, "ipmsg", "archive"))), store.Query{})
+	if err != nil {
+		fmt.Println("failed read history, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	fresh := importer.Unseen(history, imported)
+	if *dryRun || len(fresh) == 0 {
+		fmt.Printf("%d new messages, %d already in history\n", len(fresh), len(imported)-len(fresh))
+		return
+	}
+
+	current, err := store.Filter(primary, store.Query{})
+	if err != nil {
+		fmt.Println("failed read history, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	if err := primary.(store.Replacer).Replace(importer.Merge(current, fresh)); err != nil {
+		fmt.Println("failed write history, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("Imported %d messages, %d already in history\n", len(fresh), len(imported)-len(fresh))
+}
+
+/* ======== internal ======== */
+
+func serverRunning() bool {
+	socket, err := apiclient.DefaultSocket()
+	if err != nil {
+		return false
+	}
+
+	_, err = apiclient.NewUnix(socket).Peers()
+	return err == nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"ipmsg/pkg/alias"
+	"ipmsg/pkg/apiclient"
+	"ipmsg/pkg/models"
+	"ipmsg/pkg/store"
+	"ipmsg/pkg/unread"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const inboxUsage = `usage:
+  ipmsg inbox [flags]
+
+flags:
+  --from <alias|address>  only messages from sender
+  --count                 only print number of unread messages by sender
+  --peek                  do not mark printed messages read
+
+prints unread messages grouped by sender and marks them read, goes through running server
+or through store of server and ~/ipmsg/unread.json if server is not running`
+
+func runInbox(args []string) {
+	fs := flag.NewFlagSet("inbox", flag.ExitOnError)
+	fs.Usage = func() { fmt.Println(inboxUsage) }
+	from := fs.String("from", "", "")
+	count := fs.Bool("count", false, "")
+	peek := fs.Bool("peek", false, "")
+	fs.Parse(args)
+
+	box, err := daemonInbox()
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		// server is not running
+		box, err = localInbox()
+	}
+	if err != nil {
+		fmt.Println("failed read unread messages, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	msgs := box.messages
+	if *from != "" {
+		who := []string{*from}
+		if addr, ok := box.addresses[*from]; ok {
+			who = append(who, addr)
+		}
+
+		msgs = nil
+		for _, m := range box.messages {
+			if involvesAny(&m, who) {
+				msgs = append(msgs, m)
+			}
+		}
+	}
+
+	if len(msgs) == 0 {
+		fmt.Println("No unread messages")
+		return
+	}
+
+	senders := unread.BySender(msgs)
+	if *count {
+		for _, s := range senders {
+			fmt.Printf("%4d  %s\n", s.Count, senderName(&models.Message{From: s.From, Alias: s.Alias}))
+		}
+		return
+	}
+
+	for _, s := range senders {
+		fmt.Printf("%s - %d unread\n", senderName(&models.Message{From: s.From, Alias: s.Alias}), s.Count)
+		for _, m := range msgs {
+			if !strings.EqualFold(m.From, s.From) {
+				continue
+			}
+
+			lines := strings.Split(strings.TrimRight(m.Msg, "\n"), "\n")
+			fmt.Printf("  %s  %s\n", time.Unix(m.Date, 0).Format("2006-01-02 15:04"), lines[0])
+			for _, line := range lines[1:] {
+				fmt.Println(strings.TrimRight(fmt.Sprintf("  %16s  %s", "", line), " "))
+			}
+		}
+		fmt.Println()
+	}
+
+	if *peek {
+		return
+	}
+
+	if err := box.markRead(msgs); err != nil {
+		fmt.Println("failed mark messages read, err: " + err.Error())
+		os.Exit(1)
+	}
+}
+
+/* ======== internal ======== */
+
+type inbox struct {
+	messages  []models.Message
+	addresses map[string]string // contact name - current address
+	markRead  func(msgs []models.Message) error
+}
+
+func daemonInbox() (*inbox, error) {
+	socket, err := apiclient.DefaultSocket()
+	if err != nil {
+		return nil, err
+	}
+	client := apiclient.NewUnix(socket)
+
+	res, err := client.Unread(true)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := client.Aliases()
+	if err != nil {
+		return nil, err
+	}
+	addresses := map[string]string{}
+	for _, e := range entries {
+		addresses[e.Name] = e.Address
+	}
+
+	return &inbox{
+		messages:  res.Messages,
+		addresses: addresses,
+		markRead: func(msgs []models.Message) error {
+			mr := models.MarkRead{}
+			for _, m := range msgs {
+				mr.IDs = append(mr.IDs, m.ID)
+			}
+			_, err := client.MarkRead(mr)
+			return err
+		},
+	}, nil
+}
+
+// localInbox reads messages file and read state itself, used when server is not running
+func localInbox() (*inbox, error) {
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	als := alias.New(filepath.Join(userHome, "ipmsg", alias.FileName))
+	addresses, err := als.GetAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	tracker := unread.New(filepath.Join(userHome, "ipmsg", "unread.json"))
+	st, err := tracker.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := []models.Message{}
+	if since, ok := st.Since(); ok {
+		history, err := localHistory(userHome, als)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs, err = history.Query(store.Query{Direction: models.DirectionIn, Since: since})
+		if err != nil {
+			return nil, err
+		}
+
+		msgs, err = tracker.Unread(msgs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &inbox{
+		messages:  msgs,
+		addresses: addresses,
+		markRead:  func(msgs []models.Message) error { return tracker.MarkRead(msgs...) },
+	}, nil
+}
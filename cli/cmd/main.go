@@ -6,25 +6,45 @@ import (
 	"fmt"
 	"io"
 	"ipmsg/pkg/alias"
+	"ipmsg/pkg/apiclient"
+	"ipmsg/pkg/identity"
+	"ipmsg/pkg/models"
+	"ipmsg/pkg/netscan"
+	"ipmsg/pkg/outbox"
+	"ipmsg/pkg/sender"
 	"ipmsgcli/internal/cache"
-	"net"
 	"os"
 	"os/user"
 	"path/filepath"
 	"runtime"
-	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
 var noCache bool
 var port uint
 var stopKey string 
+var useOutbox bool
+var priority string
 
 func main() {
 	var destinationIP string
 
+	if len(os.Args) > 1 && os.Args[1] == "sound" {
+		runSound(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "tail" {
+		runTail(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
 	if err := createDirInHome("ipmsg"); err != nil {
 		fmt.Println("failed create ipmsg dir in home dir")
 		os.Exit(1)
@@ -42,14 +62,87 @@ func main() {
 		os.Exit(1)
 	}
 
-	defaultAliasPath, err := createFile("ipmsg/alias.txt", "")
+	defaultAliasPath, err := createFile("ipmsg/"+alias.FileName, "")
 	if err != nil {
 		fmt.Println("failed create alias file")
 		os.Exit(1)
 	}
 
+	defaultOutboxPath, err := createFile("ipmsg/outbox.json", "")
+	if err != nil {
+		fmt.Println("failed create outbox file")
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "outbox" {
+		runOutbox(defaultOutboxPath, os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "search" {
+		runSearch(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		runExport(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		runImport(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "encrypt" {
+		runEncrypt(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "decrypt" {
+		runDecrypt(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "inbox" {
+		runInbox(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "web" {
+		runWeb()
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "archive" {
+		runArchive(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "alias" {
+		runAlias(defaultAliasPath, os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "contacts" {
+		runContacts(defaultAliasPath, defaultCachePath, os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "group" {
+		runGroup(defaultAliasPath, os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "dnd" {
+		userHome, _ := os.UserHomeDir()
+		runDnd(filepath.Join(userHome, "ipmsg", "dnd.json"), os.Args[2:])
+		return
+	}
+
 	cachePath := ""
 	aliasPath     := ""
+	outboxPath    := ""
 
 	var newAlias string
 	var addrAlias string
@@ -59,9 +152,23 @@ func main() {
 	flag.BoolVar(&noCache, "scan", false, "if set ipmsg ignores cache file and scan network")
 	flag.StringVar(&newAlias, "alias", "", "add new alias")
 	flag.StringVar(&addrAlias, "ip", "", "add new alias(address)")
-	flag.StringVar(&aliasPath, "alias_path", defaultAliasPath, "path to file where aliases saved")
+	flag.StringVar(&aliasPath, "alias_path", defaultAliasPath, "path to contacts file, legacy alias.txt beside it is migrated")
+	flag.StringVar(&outboxPath, "outbox_path", defaultOutboxPath, "path to file with undelivered messages")
+	flag.StringVar(&priority, "priority", "normal", "message priority: low, normal or high")
+	flag.BoolVar(&useOutbox, "outbox", true, "queue undelivered messages, server resends them when recipient is back")
 	flag.Parse()
 
+	switch priority {
+	case "normal":
+		priority = models.PriorityNormal
+	case models.PriorityLow, models.PriorityHigh:
+	default:
+		fmt.Println("priority must be low, normal or high")
+		os.Exit(1)
+	}
+
+	box := outbox.New(outboxPath)
+
 	al := alias.New(aliasPath)
 	if newAlias != "" && addrAlias != "" {
 		if err := al.AddName(newAlias, addrAlias); err != nil {
@@ -84,17 +191,13 @@ func main() {
 		fmt.Println("failed get aliases, err: " + err.Error())
 		os.Exit(1)
 	}
-
-	fmt.Println("Aliases:")
-	fmt.Println("-----------------------")
-	for k, v := range aliases {
-		fmt.Println(k + " " + v)
+	if claims, err := al.Claims(); err == nil && len(claims) > 0 {
+		fmt.Printf("%d name claims wait for approval, see 'ipmsg alias claims'\n", len(claims))
 	}
-	fmt.Println("-----------------------")
 
 	if destinationIP == "" {
 
-		myIP, err := getLocalIP()
+		myIP, err := netscan.LocalIP()
 		if err != nil {
 			fmt.Printf("failed get local ip, err: %s\n", err.Error())
 			os.Exit(1)
@@ -121,58 +224,72 @@ func main() {
 			os.Exit(1)
 		}
 
-		length := len(msgText)
-		suc := 0
+		req := models.IPmsgRequest{
+			From:  myIP,
+			Len:   len(msgText),
+			Date:  time.Now().Unix(),
+			Alias: myName,
+			Msg:   string(msgText),
 
+			Priority: priority,
+		}
+		sign(&req)
+		suc := 0
+		queued := 0
+		var deliveries []models.Delivery
+		id := models.SentID(req.Date, req.Msg, localIPs)
 
 		fmt.Print("Sending: [")
-		for i, ip := range localIPs {
+		for _, ip := range localIPs {
+			addr := sender.Addr(ip, port)
 
-			conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", ip, port), time.Millisecond*200)
+			err := sender.Send(addr, &req, time.Millisecond*200)
 			if err != nil {
+				d := models.Delivery{To: ip, Status: models.StatusFailed, Error: err.Error()}
+				if useOutbox && !errors.Is(err, sender.ErrRejected) {
+					if item, err := box.Add(addr, req, id, err); err == nil {
+						d.Status, d.QueuedID = models.StatusQueued, item.ID
+						queued++
+						fmt.Print("~")
+					}
+				}
+				deliveries = append(deliveries, d)
 				continue
 			}
 
-			_, err = conn.Write([]byte(fmt.Sprintf(
-				"ipmsg\nfrom:%s\nlen:%d\ndate:%d\nalias:%s\nmsg:%s\x00",
-				myIP,
-				length,
-				time.Now().Unix(),
-				myName,
-				msgText,
-			)))
-			if err == nil {
-				suc++
-				fmt.Print("=")
-			}
-			conn.Close()
+			deliveries = append(deliveries, models.Delivery{To: ip, Delivered: true, Status: models.StatusSent})
+			suc++
+			fmt.Print("=")
 
 			time.Sleep(time.Millisecond * 10)
-
-			if i == len(localIPs)-1 {
-				fmt.Println("]") 
-			}
 		}
 
 		fmt.Printf("] Success sent to %d machines in local net\n", suc)
+		recordSent(&req, deliveries, al)
+		if queued > 0 {
+			fmt.Printf("%d undelivered messages saved to outbox, see 'ipmsg outbox'\n", queued)
+		}
 		return
 	}
 
-	if ipAlias, exists := aliases[destinationIP]; exists  {
-		i := net.ParseIP(ipAlias)
-		if i != nil {
-			fmt.Printf("Sending to %s(%s)\n", destinationIP, ipAlias)
-			destinationIP = ipAlias
+	if contact, err := al.Get(destinationIP); err == nil {
+		addr := contact.Address()
+		switch {
+		case strings.EqualFold(contact.Name, destinationIP) && addr != "":
+			fmt.Printf("Sending to %s(%s)\n", destinationIP, addr)
+			destinationIP = addr
+		case strings.EqualFold(contact.Name, destinationIP):
+			fmt.Printf("%s has no known address, add one with 'ipmsg alias add'\n", contact.Name)
+			os.Exit(1)
+		case !strings.EqualFold(addr, destinationIP):
+			warnStale(contact, destinationIP)
+		}
+		if contact.Port != 0 && !flagSet(flag.CommandLine, "port") {
+			port = uint(contact.Port)
 		}
 	}
 
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", destinationIP, port))
-	if err != nil {
-		fmt.Printf("failed connect to %s err: %s\n", destinationIP, err.Error())
-		os.Exit(1)
-	}
-
-	myIP, err := getLocalIP()
+	myIP, err := netscan.LocalIP()
 	if err != nil {
 		fmt.Printf("failed get local ip, err: %s\n", err.Error())
 		os.Exit(1)
@@ -187,24 +304,118 @@ func main() {
 		os.Exit(1)
 	}
 
-	length := len(msgText)
+	req := models.IPmsgRequest{
+		From:  myIP,
+		Len:   len(msgText),
+		Date:  time.Now().Unix(),
+		Alias: myName,
+		Msg:   string(msgText),
 
+		Priority: priority,
+	}
+	sign(&req)
+	addr := sender.Addr(destinationIP, port)
+	id := models.SentID(req.Date, req.Msg, []string{destinationIP})
 
-	_, err = conn.Write([]byte(fmt.Sprintf(
-		"ipmsg\nfrom:%s\nlen:%d\ndate:%d\nalias:%s\nmsg:%s\x00",
-		myIP,
-		length,
-		time.Now().Unix(),
-		myName,
-		msgText,
-	)))
+	err = sender.Send(addr, &req, 5*time.Second)
 	if err != nil {
-		fmt.Printf("failed send msg, err: %s\n", err.Error())
-		os.Exit(1)
+		d := models.Delivery{To: destinationIP, Status: models.StatusFailed, Error: err.Error()}
+		if !useOutbox || errors.Is(err, sender.ErrRejected) {
+			recordSent(&req, []models.Delivery{d}, al)
+			fmt.Printf("failed send msg to %s err: %s\n", destinationIP, err.Error())
+			os.Exit(1)
+		}
+
+		item, qErr := box.Add(addr, req, id, err)
+		if qErr != nil {
+			recordSent(&req, []models.Delivery{d}, al)
+			fmt.Printf("failed connect to %s err: %s\n", destinationIP, err.Error())
+			fmt.Printf("failed save message to outbox, err: %s\n", qErr.Error())
+			os.Exit(1)
+		}
+
+		d.Status, d.QueuedID = models.StatusQueued, item.ID
+		recordSent(&req, []models.Delivery{d}, al)
+		fmt.Printf("%s is unreachable, message saved to outbox (id %s), it will be sent when recipient is back\n", destinationIP, item.ID)
+		return
 	}
+	recordSent(&req, []models.Delivery{{To: destinationIP, Delivered: true, Status: models.StatusSent}}, al)
 	fmt.Println("Sent to 1 machine")
 }
 
+// recordSent saves sent message to history through daemon, without daemon it is appended to
+// store server uses (see localHistory)
+func recordSent(req *models.IPmsgRequest, deliveries []models.Delivery, al *alias.Alias) {
+	if len(deliveries) == 0 {
+		return
+	}
+	msg := models.NewOutgoing(req, deliveries)
+
+	if socket, err := apiclient.DefaultSocket(); err == nil {
+		if err := apiclient.NewUnix(socket).Sent(msg); err == nil {
+			return
+		}
+	}
+
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		fmt.Println("failed save sent message, err: " + err.Error())
+		return
+	}
+
+	history, err := localHistory(userHome, al)
+	if err != nil {
+		fmt.Println("failed open history, sent message is not saved, err: " + err.Error())
+		return
+	}
+	if err := history.Append(msg); err != nil {
+		fmt.Println("failed save sent message, err: " + err.Error())
+	}
+}
+
+// sign signs request with identity key, message is sent unsigned if key can not be loaded
+func sign(req *models.IPmsgRequest) {
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		return
+	}
+
+	id, err := identity.Load(filepath.Join(userHome, "ipmsg", identity.FileName))
+	if err != nil {
+		fmt.Println("failed load identity key, message is not signed, err: " + err.Error())
+		return
+	}
+	id.Sign(req)
+}
+
+// warnStale tells that addr is not current address of contact any more
+func warnStale(c alias.Contact, addr string) {
+	current := c.Address()
+	if current == "" {
+		current = "unknown"
+	}
+
+	moved := ""
+	for _, m := range c.Moves {
+		if strings.EqualFold(m.From, addr) {
+			moved = " since " + m.At.Local().Format(time.DateTime)
+		}
+	}
+
+	fmt.Printf("warning: %s is old address of %s, current one is %s%s, use --to %q to reach it\n", addr, c.Name, current, moved, c.Name)
+}
+
+// flagSet reports whether flag was given on command line
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func getName(mgr *cache.Cache) string {
 	nameCache, _ := mgr.GetName()
 	if nameCache != "" {
@@ -241,59 +452,14 @@ func getIPRange(localIP string, cache *cache.Cache) []string {
 		fmt.Println("Ignoring cache file")
 	}
 
-	// ===== IP VALIDATION =====
-	ip := net.ParseIP(localIP)
-	ipv4 := ip.To4()
-	if ipv4 == nil {
-		fmt.Println("Invalid IPv4 address:", localIP)
-		return nil
-	}
-
-	base := fmt.Sprintf("%d.%d.%d.", ipv4[0], ipv4[1], ipv4[2])
-
-	// ===== WORKER POOL =====
-	const (
-		maxHosts   = 255
-		workers    = 50
-		timeout    = 1 * time.Second
-	)
-
-	jobs := make(chan int, maxHosts)
-	results := make(chan string, maxHosts)
-
-	var wg sync.WaitGroup
-
-	worker := func() {
-		defer wg.Done()
-		for i := range jobs {
-			ip := base + strconv.Itoa(i)
-			if tcpPing(ip, timeout) {
-				results <- ip
-			}
-		}
-	}
-
-	wg.Add(workers)
-	for i := 0; i < workers; i++ {
-		go worker()
-	}
-
-	for i := 1; i <= maxHosts; i++ {
-		jobs <- i
-	}
-	close(jobs)
-
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-
-	// ===== COLLECT =====
+	// ===== SCAN =====
 	fmt.Print("\n[")
-	res := make([]string, 0)
-	for ip := range results {
+	res, err := netscan.Scan(localIP, port, 1*time.Second, func(string) {
 		fmt.Print("=")
-		res = append(res, ip)
+	})
+	if err != nil {
+		fmt.Println(err)
+		return nil
 	}
 	fmt.Println("]")
 
@@ -305,69 +471,6 @@ func getIPRange(localIP string, cache *cache.Cache) []string {
 	return res
 }
 
-func tcpPing(ip string, timeout time.Duration) bool {
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", ip, port), timeout)
-	if err == nil {
-		conn.Close()
-		return true
-	}
-	return false
-}
-
-
-func getLocalIP() (string, error) {
-
-	interfaces, err := net.Interfaces()
-	if err != nil {
-		return "", err
-	}
-
-	for _, face := range interfaces {
-		if face.Flags&net.FlagUp == 0 ||
-			face.Flags&net.FlagLoopback != 0 {
-			continue
-		}
-
-		if isVPNInterface(face.Name) {
-			continue
-		}
-
-		addrs, err := face.Addrs()
-		if err != nil {
-			continue
-		}
-
-		for _, addr := range addrs {
-			var ip net.IP
-
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-
-			if ip == nil ||
-				ip.IsLoopback() ||
-				ip.To4() == nil {
-				continue
-			}
-
-			return ip.String(), nil
-		}
-	}
-
-	return "", errors.New("no suitable local IP found")
-}
-
-func isVPNInterface(name string) bool {
-	return strings.HasPrefix(name, "tun") ||
-		strings.HasPrefix(name, "tap") ||
-		strings.HasPrefix(name, "wg") ||
-		strings.HasPrefix(name, "ppp")
-}
-
-
 func createFile(filename, path string) (string, error) {
 	if path == "" {
 		currentUser, err := user.Current()
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"ipmsg/pkg/filelock"
+	"ipmsg/pkg/fileparser"
+	"ipmsg/pkg/models"
+	"ipmsg/pkg/store"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const migrateUsage = `usage:
+  ipmsg migrate [file]                        convert messages file (~/ipmsg.txt by default) to current text format
+  ipmsg migrate --jsonl <out> [file]          copy messages from text file to json lines store
+  ipmsg migrate --conversations <dir> [file]  copy messages to log per peer (server --store conversations)`
+
+func runMigrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Usage = func() { fmt.Println(migrateUsage) }
+	jsonlPath := fs.String("jsonl", "", "json lines store to copy messages to")
+	convDir := fs.String("conversations", "", "conversations directory to copy messages to")
+	fs.Parse(args)
+
+	filename := fs.Arg(0)
+	if filename == "" {
+		userHome, err := os.UserHomeDir()
+		if err != nil {
+			fmt.Println("failed get home dir, err: " + err.Error())
+			os.Exit(1)
+		}
+		filename = filepath.Join(userHome, "ipmsg.txt")
+	}
+
+	version, err := fileparser.FileVersion(filename)
+	if err != nil {
+		fmt.Println("failed read messages file, err: " + err.Error())
+		os.Exit(1)
+	}
+	if version == 0 {
+		fmt.Println("Messages file is empty, nothing to migrate")
+		return
+	}
+
+	if *jsonlPath != "" || *convDir != "" {
+		messages, err := fileparser.ParseFile(filename)
+		if err != nil {
+			fmt.Println("failed parse messages file, err: " + err.Error())
+			os.Exit(1)
+		}
+
+		var st store.Store
+		var target string
+		if *jsonlPath != "" {
+			st, target = store.NewJSONL(*jsonlPath), *jsonlPath
+		} else {
+			st, target = store.NewConversations(*convDir), *convDir
+		}
+
+		if err := migrateToStore(st, target, messages); err != nil {
+			fmt.Println("failed write message store, err: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Copied %d messages to %s\n", len(messages), target)
+		return
+	}
+
+	if version == fileparser.Version {
+		fmt.Printf("%s already uses text format v%d\n", filename, version)
+		return
+	}
+
+	backup, n, err := migrateText(filename)
+	if err != nil {
+		fmt.Println("failed migrate messages file, err: " + err.Error())
+		os.Exit(1)
+	}
+	if backup == "" {
+		fmt.Printf("%s already uses text format v%d\n", filename, fileparser.Version)
+		return
+	}
+
+	fmt.Printf("Migrated %d messages to text format v%d, old file saved to %s\n", n, fileparser.Version, backup)
+}
+
+// migrateText rewrites file in current format, old file is kept as backup. File is parsed and
+// swapped under one exclusive lock, so messages server appends meanwhile are not lost.
+// Empty backup means file already uses current format
+func migrateText(filename string) (string, int, error) {
+	unlock, err := filelock.For(filename).Lock()
+	if err != nil {
+		return "", 0, err
+	}
+	defer unlock()
+
+	version, err := fileparser.FileVersion(filename)
+	if err != nil || version == fileparser.Version {
+		return "", 0, err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", 0, err
+	}
+	messages, err := fileparser.Parse(file)
+	file.Close()
+	if err != nil {
+		return "", 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), ".ipmsg-migrate-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := fileparser.WriteHeader(tmp, fileparser.Version); err != nil {
+		tmp.Close()
+		return "", 0, err
+	}
+
+	for _, m := range messages {
+		from := fileparser.FormatSender(m.Alias, m.From)
+		if len(m.Deliveries) > 0 {
+			from = fileparser.FormatRecipients(m.Deliveries, nil)
+		}
+
+		if err := fileparser.WriteEntry(tmp, fileparser.Version, from, &m); err != nil {
+			tmp.Close()
+			return "", 0, err
+		}
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+
+	backup := fmt.Sprintf("%s.v%d.bak", filename, version)
+	if err := os.Rename(filename, backup); err != nil {
+		return "", 0, err
+	}
+
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return "", 0, err
+	}
+
+	return backup, len(messages), nil
+}
+
+func migrateToStore(st store.Store, target string, messages []models.IPmsgRequest) error {
+	empty := true
+	err := st.Iterate(func(*models.Message) bool {
+		empty = false
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return fmt.Errorf("%s is not empty, refusing to add messages twice", target)
+	}
+
+	for _, m := range messages {
+		msg := &models.Message{
+			ID:        models.NewID(),
+			Direction: models.DirectionIn,
+			From:      m.From,
+			Alias:     m.Alias,
+			Date:      m.Date,
+			Received:  time.Unix(m.Date, 0),
+			Len:       m.Len,
+			Msg:       m.Msg,
+		}
+		// record with recipients is sent message, like text store reads it
+		if len(m.Deliveries) > 0 {
+			msg = models.NewOutgoing(&m, m.Deliveries)
+			msg.Received = time.Unix(m.Date, 0)
+		}
+
+		if err := st.Append(msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"ipmsg/pkg/alias"
+	"ipmsg/pkg/apiclient"
+	"ipmsg/pkg/models"
+	"ipmsg/pkg/outbox"
+	"ipmsg/pkg/sender"
+	"ipmsg/pkg/store"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const outboxUsage = `usage:
+  ipmsg outbox [list]         show pending messages
+  ipmsg outbox cancel <id>    remove message from outbox
+  ipmsg outbox retry <id|all> try to deliver message right now`
+
+func runOutbox(path string, args []string) {
+	box := outbox.New(path)
+
+	cmd := "list"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "list", "ls":
+		items, err := box.List()
+		if err != nil {
+			fmt.Println("failed read outbox, err: " + err.Error())
+			os.Exit(1)
+		}
+
+		if len(items) == 0 {
+			fmt.Println("Outbox is empty")
+			return
+		}
+
+		fmt.Printf("%-12s | %-21s | %-19s | %-8s | %-19s | %s\n", "ID", "TO", "CREATED", "ATTEMPTS", "NEXT TRY", "MESSAGE")
+		for _, it := range items {
+			fmt.Printf(
+				"%-12s | %-21s | %-19s | %8d | %-19s | %s\n",
+				it.ID,
+				it.To,
+				it.CreatedAt.Format(time.DateTime),
+				it.Attempts,
+				it.NextTry.Format(time.DateTime),
+				preview(it.Request.Msg, 30),
+			)
+			if it.LastError != "" {
+				fmt.Printf("%12s   last error: %s\n", "", it.LastError)
+			}
+		}
+
+	case "cancel", "rm":
+		if len(args) < 2 {
+			fmt.Println(outboxUsage)
+			os.Exit(1)
+		}
+
+		for _, id := range args[1:] {
+			it, err := box.Get(id)
+			if err == nil {
+				err = box.Remove(id)
+			}
+			if errors.Is(err, outbox.ErrSending) {
+				fmt.Printf("%s is being sent right now, it may be delivered already, try again later\n", id)
+				continue
+			}
+			if err != nil {
+				fmt.Printf("failed cancel %s, err: %s\n", id, err.Error())
+				os.Exit(1)
+			}
+			fmt.Printf("Canceled %s\n", id)
+			reportDelivery(it, models.Delivery{To: it.Host(), Status: models.StatusFailed, Error: "canceled"})
+		}
+
+	case "retry":
+		if len(args) < 2 {
+			fmt.Println(outboxUsage)
+			os.Exit(1)
+		}
+
+		var items []outbox.Item
+		if args[1] == "all" {
+			all, err := box.List()
+			if err != nil {
+				fmt.Println("failed read outbox, err: " + err.Error())
+				os.Exit(1)
+			}
+			items = all
+		} else {
+			for _, id := range args[1:] {
+				it, err := box.Get(id)
+				if err != nil {
+					fmt.Printf("failed get %s, err: %s\n", id, err.Error())
+					os.Exit(1)
+				}
+				items = append(items, *it)
+			}
+		}
+
+		for _, it := range items {
+			var sendErr error
+			sent := false
+			_, err := box.Deliver(it.ID, func(it *outbox.Item) error {
+				sendErr = sender.Send(it.To, &it.Request, 2*time.Second)
+				sent = sendErr == nil
+				return sendErr
+			})
+			switch {
+			case !sent && errors.Is(err, outbox.ErrNotFound):
+				fmt.Printf("%s: already delivered or canceled\n", it.ID)
+				continue
+			case !sent && errors.Is(err, outbox.ErrSending):
+				fmt.Printf("%s: server is sending it right now\n", it.ID)
+				continue
+			case sent && err != nil:
+				fmt.Printf("%s: delivered but failed remove from outbox, err: %s\n", it.ID, err.Error())
+			case sent:
+				fmt.Printf("%s: delivered to %s\n", it.ID, it.To)
+			case sendErr != nil:
+				fmt.Printf("%s: still undelivered to %s, err: %s\n", it.ID, it.To, sendErr.Error())
+				continue
+			default:
+				fmt.Printf("failed update outbox, err: %s\n", err.Error())
+				os.Exit(1)
+			}
+			reportDelivery(&it, models.Delivery{To: it.Host(), Delivered: true, Status: models.StatusSent})
+		}
+
+	default:
+		fmt.Println(outboxUsage)
+		os.Exit(1)
+	}
+}
+
+// reportDelivery saves new result of item to sent message in history
+func reportDelivery(it *outbox.Item, d models.Delivery) {
+	if it.MessageID == "" {
+		return
+	}
+
+	err := updateDelivery(it.MessageID, d)
+	if err != nil && !errors.Is(err, store.ErrUnsupported) && !errors.Is(err, store.ErrNotFound) {
+		fmt.Printf("%s: failed save result to history, err: %s\n", it.ID, err.Error())
+	}
+}
+
+// updateDelivery updates history through server, without server history file is updated
+func updateDelivery(id string, d models.Delivery) error {
+	if serverRunning() {
+		socket, err := apiclient.DefaultSocket()
+		if err != nil {
+			return err
+		}
+		return apiclient.NewUnix(socket).UpdateDelivery(id, d)
+	}
+
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+
+	als := alias.New(filepath.Join(userHome, "ipmsg", alias.FileName))
+	history, err := localHistory(userHome, als)
+	if err != nil {
+		return err
+	}
+	return history.UpdateDelivery(id, d)
+}
+
+func preview(msg string, n int) string {
+	msg = strings.Join(strings.Fields(msg), " ")
+	if len([]rune(msg)) > n {
+		return string([]rune(msg)[:n-3]) + "..."
+	}
+	return msg
+}
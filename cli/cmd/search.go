@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"ipmsg/pkg/alias"
+	"ipmsg/pkg/apiclient"
+	"ipmsg/pkg/fileparser"
+	"ipmsg/pkg/models"
+	"ipmsg/pkg/search"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const searchUsage = `usage:
+  ipmsg search [flags] <words or "exact phrase">
+
+flags:
+  --from <alias|address>  only messages from sender
+  --group <name>          only messages from group members (see ipmsg group)
+  --since <date>          2024-01-31, 2024-01 or 30d (days ago)
+  --until <date>          same formats, not inclusive
+  --limit <n>             max results (20)
+  --context <n>           show n messages of same sender around result
+  --json                  print results as json
+
+all words must be in message, words like 192.168.1.5 must go in a row,
+search goes through index of running server, if server is not running whole history
+of its store and archive is read and indexed for this search only`
+
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	fs.Usage = func() { fmt.Println(searchUsage) }
+	from := fs.String("from", "", "")
+	group := fs.String("group", "", "")
+	since := fs.String("since", "", "")
+	until := fs.String("until", "", "")
+	limit := fs.Int("limit", 20, "")
+	context := fs.Int("context", 0, "")
+	asJSON := fs.Bool("json", false, "")
+	fs.Parse(args)
+
+	sq := apiclient.SearchQuery{
+		Text:    strings.Join(fs.Args(), " "),
+		From:    *from,
+		Group:   *group,
+		Limit:   *limit,
+		Context: *context,
+	}
+	if sq.Text == "" && sq.From == "" && sq.Group == "" {
+		fmt.Println(searchUsage)
+		os.Exit(1)
+	}
+
+	var err error
+	if sq.Since, err = parseDate(*since); err != nil {
+		fmt.Println("invalid --since, err: " + err.Error())
+		os.Exit(1)
+	}
+	if sq.Until, err = parseDate(*until); err != nil {
+		fmt.Println("invalid --until, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	results, err := searchDaemon(sq)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		// server is not running
+		results, err = searchLocal(sq)
+	}
+	if err != nil {
+		fmt.Println("failed search, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return
+	}
+
+	if len(results) == 0 {
+		fmt.Println("Nothing found")
+		return
+	}
+
+	bold := isTerminal(os.Stdout)
+	for _, res := range results {
+		for _, m := range res.Before {
+			printContext(&m)
+		}
+
+		fmt.Printf("%s  %s\n", time.Unix(res.Message.Date, 0).Format("2006-01-02 15:04"), senderName(&res.Message))
+		fmt.Println("  " + highlight(res.Snippet, res.Highlights, bold))
+
+		for _, m := range res.After {
+			printContext(&m)
+		}
+		fmt.Println()
+	}
+}
+
+/* ======== internal ======== */
+
+func searchDaemon(sq apiclient.SearchQuery) ([]models.SearchResult, error) {
+	socket, err := apiclient.DefaultSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	return apiclient.NewUnix(socket).Search(sq)
+}
+
+// searchLocal builds index from whole history for one search, used when server is not running
+func searchLocal(sq apiclient.SearchQuery) ([]models.SearchResult, error) {
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	als := alias.New(filepath.Join(userHome, "ipmsg", alias.FileName))
+	contacts, err := als.Contacts()
+	if err != nil {
+		return nil, err
+	}
+
+	q := search.Query{
+		Text:    sq.Text,
+		Since:   sq.Since,
+		Until:   sq.Until,
+		Limit:   sq.Limit,
+		Context: sq.Context,
+	}
+	if sq.From != "" {
+		q.From = alias.Expand(contacts, sq.From)
+	}
+	if sq.Group != "" {
+		members := alias.Members(contacts, sq.Group)
+		if len(members) == 0 {
+			return nil, fmt.Errorf("%s: %w", sq.Group, alias.ErrGroupNotFound)
+		}
+		q.Group = alias.Expand(contacts, members...)
+	}
+
+	history, err := localHistory(userHome, als)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := search.Build(history)
+	if err != nil {
+		return nil, err
+	}
+
+	return index.Search(q), nil
+}
+
+// parseDate accepts 2024-01-31, 2024-01 or number of days ago like 30d
+func parseDate(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Now().AddDate(0, 0, -n).Unix(), nil
+	}
+
+	for _, layout := range []string{time.DateOnly, "2006-01"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t.Unix(), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown date %q", s)
+}
+
+// senderName renders sender of received message or recipients of sent one
+func senderName(m *models.Message) string {
+	if m.Direction == models.DirectionOut && len(m.Deliveries) > 0 {
+		return fileparser.FormatRecipients(m.Deliveries, nil)
+	}
+	if m.Alias != "" {
+		return fmt.Sprintf("%s(%s)", m.Alias, m.From)
+	}
+	return m.From
+}
+
+func printContext(m *models.Message) {
+	text := strings.ReplaceAll(m.Msg, "\n", " ")
+	if r := []rune(text); len(r) > 70 {
+		text = string(r[:67]) + "..."
+	}
+	fmt.Printf("  %s  | %s\n", time.Unix(m.Date, 0).Format("15:04"), text)
+}
+
+// highlight marks matches in bold on terminal
+func highlight(text string, ranges [][2]int, bold bool) string {
+	if !bold {
+		return text
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, r := range ranges {
+		if r[0] < last || r[1] > len(text) {
+			continue
+		}
+		b.WriteString(text[last:r[0]])
+		b.WriteString("\x1b[1m" + text[r[0]:r[1]] + "\x1b[0m")
+		last = r[1]
+	}
+	b.WriteString(text[last:])
+
+	return b.String()
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
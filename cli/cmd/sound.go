@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"ipmsg/pkg/melody"
+	"os"
+	"time"
+)
+
+const soundUsage = `usage:
+  ipmsg sound render <melody|file.wav> <out.wav>  render sound to wav file for preview
+  ipmsg sound check <melody|file.wav>             validate sound and print its length
+
+melody example: "E6:100 F6:50 G6:50 F6:50 E6:100" (note:ms, R:ms for rest, v0.5 for volume)`
+
+func runSound(args []string) {
+	if len(args) < 2 {
+		fmt.Println(soundUsage)
+		os.Exit(1)
+	}
+
+	sound, err := melody.Load(args[1])
+	if err != nil {
+		fmt.Println("invalid sound, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	length := time.Duration(len(sound.PCM)/2) * time.Second / time.Duration(sound.SampleRate)
+
+	switch args[0] {
+	case "check":
+		fmt.Printf("Sound is valid, length %s\n", length)
+
+	case "render":
+		if len(args) < 3 {
+			fmt.Println(soundUsage)
+			os.Exit(1)
+		}
+
+		file, err := os.Create(args[2])
+		if err != nil {
+			fmt.Println("failed create file, err: " + err.Error())
+			os.Exit(1)
+		}
+		defer file.Close()
+
+		if err := melody.WriteWAV(file, sound); err != nil {
+			fmt.Println("failed write wav, err: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Rendered %s of sound to %s\n", length, args[2])
+
+	default:
+		fmt.Println(soundUsage)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"ipmsg/pkg/fileparser"
+	"ipmsg/pkg/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const tailUsage = `usage:
+  ipmsg tail [-f] [-n <count>] [file]
+
+flags:
+  -f                 keep printing new messages as they are saved
+  -n <count>         number of last messages to print (10)
+  --interval <dur>   how often file is checked with -f (1s)
+
+file is ~/ipmsg.txt by default, only new part of file is read on every check`
+
+func runTail(args []string) {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	fs.Usage = func() { fmt.Println(tailUsage) }
+	follow := fs.Bool("f", false, "")
+	count := fs.Int("n", 10, "")
+	interval := fs.Duration("interval", time.Second, "")
+	fs.Parse(args)
+
+	filename := fs.Arg(0)
+	if filename == "" {
+		userHome, err := os.UserHomeDir()
+		if err != nil {
+			fmt.Println("failed get home dir, err: " + err.Error())
+			os.Exit(1)
+		}
+		filename = filepath.Join(userHome, "ipmsg.txt")
+	}
+
+	tail := fileparser.NewTail(filename)
+
+	records, err := tail.Next()
+	if err != nil {
+		fmt.Println("failed read messages file, err: " + err.Error())
+		os.Exit(1)
+	}
+	if *count >= 0 && len(records) > *count {
+		records = records[len(records)-*count:]
+	}
+	printRecords(records)
+
+	if !*follow {
+		return
+	}
+
+	tail.Follow(context.Background(), *interval, printRecords, func(err error) {
+		fmt.Fprintln(os.Stderr, "failed read messages file, err: "+err.Error())
+	})
+}
+
+/* ======== internal ======== */
+
+func printRecords(records []models.IPmsgRequest) {
+	for _, r := range records {
+		from := r.From
+		if r.Alias != "" {
+			from = fmt.Sprintf("%s(%s)", r.Alias, r.From)
+		}
+		if len(r.Deliveries) > 0 {
+			from = fileparser.FormatRecipients(r.Deliveries, nil)
+		}
+
+		fmt.Printf("%s  %s\n", time.Unix(r.Date, 0).Format("2006-01-02 15:04:05"), from)
+		for _, line := range strings.Split(r.Msg, "\n") {
+			fmt.Println("  " + line)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"ipmsg/pkg/apiclient"
+	"os"
+)
+
+// runWeb prints new one-time login url of browser ui served by server with --web
+func runWeb() {
+	socket, err := apiclient.DefaultSocket()
+	if err != nil {
+		fmt.Println("failed get home dir, err: " + err.Error())
+		os.Exit(1)
+	}
+
+	url, err := apiclient.NewUnix(socket).WebLogin()
+	if err != nil {
+		fmt.Println("failed get login url, is server running with --web? err: " + err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Println("Open once in browser: " + url)
+}
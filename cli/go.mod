@@ -6,11 +6,14 @@ require github.com/prometheus-community/pro-bing v0.7.0
 
 require (
 	github.com/google/uuid v1.6.0 // indirect
+	golang.org/x/crypto v0.36.0 // indirect
 	golang.org/x/net v0.38.0 // indirect
 	golang.org/x/sync v0.13.0 // indirect
 	golang.org/x/sys v0.31.0 // indirect
+	golang.org/x/term v0.30.0 // indirect
+	golang.org/x/text v0.23.0 // indirect
 )
 
 require ipmsg v0.0.0
 
-replace ipmsg => ../
\ No newline at end of file
+replace ipmsg => ../
@@ -2,11 +2,21 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"ipmsg/internal/api"
 	"ipmsg/internal/beep"
+	"ipmsg/internal/events"
 	"ipmsg/internal/filesaver"
+	"ipmsg/internal/hooks"
+	"ipmsg/internal/notify"
+	"ipmsg/internal/peers"
+	"ipmsg/internal/retention"
+	"ipmsg/internal/retrier"
 	"ipmsg/internal/server"
+	"ipmsg/internal/webui"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -14,17 +24,30 @@ import (
 	"path/filepath"
 	"runtime"
 	"syscall"
+	"time"
 
 	"ipmsg/pkg/alias"
+	"ipmsg/pkg/crypt"
+	"ipmsg/pkg/fileparser"
+	"ipmsg/pkg/identity"
+	"ipmsg/pkg/models"
+	"ipmsg/pkg/outbox"
+	"ipmsg/pkg/search"
+	"ipmsg/pkg/store"
+	"ipmsg/pkg/unread"
 )
 
 func main()  {
 	ctx, cancel := context.WithCancel(context.Background())
 
+	log := slog.Default()
+
 	go func() {
 		runtime.LockOSThread()
 
-		beep.Init()
+		if err := beep.Init(); err != nil {
+			log.Warn("audio is unavailable, beep notifications are disabled", "err", err)
+		}
 		<-ctx.Done()
 		beep.Close()
 	}()
@@ -38,7 +61,6 @@ func main()  {
 		defaultHost     = "0.0.0.0"
 		defaultPort     =  6767
 	)
-	log := slog.Default()
 
 	defaultSavePath, err := createFile("ipmsg.txt", "")
 
@@ -47,34 +69,243 @@ func main()  {
 		os.Exit(1)
 	}
 
-	defaultAliasPath, err := createFile("ipmsg/alias.txt", "")
+	defaultAliasPath, err := createFile("ipmsg/"+alias.FileName, "")
 	if err != nil {
 		log.Error("failed create alias file", "err", err)
 		os.Exit(1)
 	}
 
+	defaultOutboxPath, err := createFile("ipmsg/outbox.json", "")
+	if err != nil {
+		log.Error("failed create outbox file", "err", err)
+		os.Exit(1)
+	}
+
 	var savePath, host string
 	var port uint
 	var aliasPath string
+	var outboxPath string
+	var retryInterval time.Duration
+	var apiSocket, apiAddr, apiTokenPath string
+	var web bool
+	var hooksPath string
+	var notifyPath string
+	var dndPath string
+	var name string
+	var storeKind, storePath, conversationsDir string
+	var encryptedPath, keyFile string
+	var textView bool
+	var retentionPath, archiveDir string
+	var fsync string
+	var unreadPath string
+	var identityPath string
+	var claimPolicy string
+	var shareContacts string
+	var fsyncInterval time.Duration
 	flag.StringVar(&savePath, "save_path", defaultSavePath, "path to file with messages")
+	flag.StringVar(&storeKind, "store", "text", "message storage: text (save_path table), jsonl (store_path), conversations (conversations_dir) or encrypted (encrypted_path)")
+	flag.StringVar(&storePath, "store_path", homePath("ipmsg/messages.jsonl"), "path to json lines message store")
+	flag.StringVar(&encryptedPath, "encrypted_path", homePath("ipmsg/messages.enc"), "path to encrypted message store")
+	flag.StringVar(&keyFile, "key_file", "", "file with passphrase of encrypted store, without it "+crypt.EnvPassphrase+" or terminal prompt is used")
+	flag.StringVar(&conversationsDir, "conversations_dir", homePath("ipmsg/conversations"), "directory with log per peer and group")
+	flag.BoolVar(&textView, "text_view", true, "with jsonl or conversations store also render all messages to save_path")
+	flag.StringVar(&fsync, "fsync", store.SyncAlways, "when written messages are flushed to disk: always (after every batch), interval or never")
+	flag.DurationVar(&fsyncInterval, "fsync_interval", time.Second, "how often messages are flushed with --fsync interval")
 	flag.StringVar(&host, "host", defaultHost, "host")
 	flag.UintVar(&port, "port", defaultPort, "port")
-	flag.StringVar(&aliasPath, "alias_path", defaultAliasPath, "path to file with aliases")
+	flag.StringVar(&aliasPath, "alias_path", defaultAliasPath, "path to contacts file, legacy alias.txt beside it is migrated")
+	flag.StringVar(&outboxPath, "outbox_path", defaultOutboxPath, "path to file with undelivered messages")
+	flag.DurationVar(&retryInterval, "retry_interval", 15*time.Second, "how often outbox is checked for due messages")
+	flag.StringVar(&apiSocket, "api_socket", homePath("ipmsg/ipmsg.sock"), "unix socket of local control api, empty to disable")
+	flag.StringVar(&apiAddr, "api_addr", "", "loopback address of local control api (example: 127.0.0.1:6768), empty to disable")
+	flag.StringVar(&apiTokenPath, "api_token_path", homePath("ipmsg/"+api.TokenFileName), "file with bearer token required on api_addr, generated if missing")
+	flag.StringVar(&name, "name", cachedName(), "your name sent with messages from local api")
+	flag.StringVar(&hooksPath, "hooks_path", homePath("ipmsg/hooks.json"), "path to json file with commands run for every received message")
+	flag.StringVar(&notifyPath, "notify_path", homePath("ipmsg/notify.json"), "path to json file with notification rules")
+	flag.StringVar(&dndPath, "dnd_path", homePath("ipmsg/dnd.json"), "path to json file with do not disturb settings")
+	flag.StringVar(&retentionPath, "retention_path", homePath("ipmsg/retention.json"), "path to json file with history rotation and retention rules")
+	flag.StringVar(&archiveDir, "archive_dir", homePath("ipmsg/archive"), "directory with archived messages")
+	flag.StringVar(&unreadPath, "unread_path", homePath("ipmsg/unread.json"), "path to json file with read state of messages")
+	flag.StringVar(&identityPath, "identity_path", homePath("ipmsg/"+identity.FileName), "path to key which signs sent messages, generated if missing")
+	flag.StringVar(&claimPolicy, "claim_policy", alias.PolicyFirst, "names sent by peers: first (accept unknown names, hold the rest), prompt (hold all) or signed (accept only signed)")
+	flag.StringVar(&shareContacts, "share_contacts", "", "answer contact requests of peers: empty to refuse, all or name of contact group to publish")
+	flag.BoolVar(&web, "web", false, "serve browser ui on api address (127.0.0.1:6768 if --api_addr is not set)")
 	flag.Parse()
 
+	if web && apiAddr == "" {
+		apiAddr = "127.0.0.1:6768"
+	}
+
 	if port > 65535 {
 		log.Error("invalid port")
 		os.Exit(1)
 	}
 
+	switch fsync {
+	case store.SyncAlways, store.SyncInterval, store.SyncNever:
+	default:
+		log.Error("fsync must be always, interval or never")
+		os.Exit(1)
+	}
+
 	alsManager := alias.New(aliasPath)
-	als, err := alsManager.GetNames()
-	if err != nil {
+	if err := alsManager.SetPolicy(claimPolicy); err != nil {
+		log.Error("invalid --claim_policy", "err", err)
+		os.Exit(1)
+	}
+	if _, err := alsManager.GetNames(); err != nil {
 		log.Error("failed get alias files")
 		os.Exit(1)
 	}
-	fileWriter := filesaver.New(als)
-	server := server.New(log, fileWriter, host, uint16(port), savePath, alsManager)
+
+	storePaths := map[string]string{"text": savePath, "jsonl": storePath, "conversations": conversationsDir, "encrypted": encryptedPath}
+	primary, key, err := openStore(log, storeKind, storePaths, textView, alsManager, keyFile)
+	if err != nil {
+		log.Error("failed open message store", "err", err)
+		os.Exit(1)
+	}
+
+	// cli opens the same store when server is not running
+	storeCfg := store.Config{Kind: storeKind, Path: storePaths[storeKind], Archive: archiveDir, KeyFile: keyFile}
+	if textView && storeKind != "text" && storeKind != "encrypted" {
+		storeCfg.TextView = savePath
+	}
+	if err := store.SaveConfig(homePath("ipmsg/"+store.ConfigFileName), storeCfg); err != nil {
+		log.Warn("failed save store config, cli will not find history without server", "err", err)
+	}
+
+	archive := store.NewArchive(archiveDir)
+	if key != nil {
+		archive = store.NewEncryptedArchive(archiveDir, key)
+	}
+
+	// all goroutines write through one writer, messages coming together are written in one batch
+	writer := store.NewWriter(primary, store.WriterOptions{Sync: fsync, SyncInterval: fsyncInterval})
+	archived := store.WithArchive(writer, archive)
+
+	// index lives in memory, it is built from whole history on every start and then kept
+	// up to date as store view, cli searches through it while server runs
+	index, err := search.Build(archived)
+	if err != nil {
+		log.Error("failed build search index", "err", err)
+		os.Exit(1)
+	}
+	log.Info("indexed messages for search", "count", index.Len())
+	messages := store.WithViews(archived, index)
+
+	rotator := retention.New(log, retentionPath, archived, index, alsManager)
+	go rotator.Run(ctx, time.Hour)
+
+	tracker := unread.New(unreadPath)
+	if _, err := tracker.Load(); err != nil {
+		log.Error("failed load read state", "err", err)
+		os.Exit(1)
+	}
+
+	self, err := identity.Load(identityPath)
+	if err != nil {
+		log.Error("failed load identity key", "err", err)
+		os.Exit(1)
+	}
+
+	log.Info("messages are signed", "fingerprint", self.Fingerprint())
+
+	fileWriter := filesaver.New(log, messages, alsManager)
+	fileWriter.Unread = tracker
+	server := server.New(log, fileWriter, host, uint16(port))
+
+	box := outbox.New(outboxPath)
+	bus := events.New()
+	registry := peers.New()
+
+	redeliver := retrier.New(log, box, messages, retryInterval, bus)
+	go redeliver.Run(ctx)
+	// senders, recipients of successful sends and scan results all wake their queued messages
+	registry.OnSeen = redeliver.PeerSeen
+
+	if hooksPath != "" {
+		runner := hooks.New(log, hooksPath)
+		runner.Names = alsManager.GetNames
+		go runner.Run(ctx, bus)
+	}
+
+	notifyCfg, err := notify.Load(notifyPath)
+	if err != nil {
+		log.Error("failed load notification rules", "err", err)
+		os.Exit(1)
+	}
+	notifier, err := notify.Build(notifyCfg)
+	if err != nil {
+		log.Error("failed setup notifications", "err", err)
+		os.Exit(1)
+	}
+	notifier.Contacts = alsManager.Contacts
+
+	server.Events = bus
+	quiet := notify.NewQuiet(log, notifier, dndPath)
+	quiet.Names = alsManager.GetNames
+	go quiet.Run(ctx, 30*time.Second)
+	server.Notifier = quiet
+	if shareContacts != "" {
+		group := shareContacts
+		if group == "all" {
+			group = ""
+		}
+		server.Contacts = func(req *models.IPmsgRequest, observed string) (string, error) {
+			contacts, err := alsManager.Contacts()
+			if err != nil {
+				return "", err
+			}
+
+			data, err := json.Marshal(alias.NewExport(alias.Published(contacts, group)))
+			if err != nil {
+				return "", err
+			}
+
+			log.Info("contacts shared", "to", observed, "alias", req.Alias)
+			return string(data), nil
+		}
+	}
+
+	server.PeerSeen = func(host string, req *models.IPmsgRequest) {
+		registry.Seen(host)
+		if req.Alias != "" {
+			registry.SetAlias(host, req.Alias)
+		}
+	}
+
+	var apiToken string
+	if apiAddr != "" {
+		apiToken, err = api.LoadToken(apiTokenPath)
+		if err != nil {
+			log.Error("failed load api token", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	control := api.New(log, api.Config{
+		Name:      name,
+		Port:      port,
+		Store:     messages,
+		Search:    index,
+		Retention: rotator,
+		Alias:     alsManager,
+		Outbox:    box,
+		Peers:     registry,
+		Events:    bus,
+		Unread:    tracker,
+		Identity:  self,
+		Token:     apiToken,
+	})
+	if web {
+		url, err := control.ServeWeb(apiAddr, webui.Handler())
+		if err != nil {
+			log.Error("failed serve browser ui", "err", err)
+			os.Exit(1)
+		}
+		log.Info("serving browser ui, open login url once, new one is printed by ipmsg web", "url", url)
+	}
+	startAPI(ctx, log, control, apiSocket, apiAddr)
 
 	go func() {
 		if err := server.Init(ctx); err != nil {
@@ -85,6 +316,104 @@ func main()  {
 	log.Info("starting TCP server", "addr", fmt.Sprintf("%s:%d", host, uint16(port)))
 
 	gracefulStop(log, cancel)
+
+	if err := writer.Close(); err != nil {
+		log.Error("failed flush message store", "err", err)
+	}
+}
+
+// startAPI serves local control api on unix socket and loopback address if they are set
+func startAPI(ctx context.Context, log *slog.Logger, control *api.Server, socket, addr string) {
+	if socket != "" {
+		l, err := api.ListenUnix(socket)
+		if err != nil {
+			log.Error("failed listen api socket", "err", err)
+		} else {
+			log.Info("starting local api", "socket", socket)
+			go func() {
+				if err := control.Serve(ctx, l); err != nil {
+					log.Error("local api stopped", "err", err)
+				}
+			}()
+		}
+	}
+
+	if addr != "" {
+		l, err := api.ListenLoopback(addr)
+		if err != nil {
+			log.Error("failed listen api address", "err", err)
+			os.Exit(1)
+		}
+		log.Info("starting local api", "addr", addr)
+		go func() {
+			if err := control.Serve(ctx, l); err != nil {
+				log.Error("local api stopped", "err", err)
+			}
+		}()
+	}
+}
+
+// openStore returns message store selected by kind, jsonl and conversations stores can also render text view.
+// Key is returned for encrypted store, archive is sealed with it too
+func openStore(log *slog.Logger, kind string, paths map[string]string, textView bool, als *alias.Alias, keyFile string) (store.Store, *crypt.Key, error) {
+	savePath := paths["text"]
+	text := store.NewText(savePath, als.GetNames)
+	if version, _ := fileparser.FileVersion(savePath); version == fileparser.V1 && kind != "encrypted" {
+		log.Warn("messages file uses old format, multi-paragraph messages may be misread, run ipmsg migrate", "path", savePath)
+	}
+
+	var primary store.Store
+	switch kind {
+	case "text":
+		return text, nil, nil
+	case "jsonl":
+		primary = store.NewJSONL(paths[kind])
+	case "conversations":
+		primary = store.NewConversations(paths[kind])
+	case "encrypted":
+		enc, err := openEncrypted(paths[kind], keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		log.Info("using message store", "store", kind, "path", paths[kind])
+		if info, err := os.Stat(savePath); err == nil && info.Size() > 0 {
+			log.Warn("plain messages file is still on disk, move it into encrypted store with ipmsg encrypt --remove", "path", savePath)
+		}
+		if textView {
+			log.Info("text view is not written for encrypted store")
+		}
+		return enc, enc.Key(), nil
+	default:
+		return nil, nil, fmt.Errorf("unknown store %q, expected text, jsonl, conversations or encrypted", kind)
+	}
+
+	log.Info("using message store", "store", kind, "path", paths[kind])
+	if !textView {
+		return primary, nil, nil
+	}
+
+	view := store.WithViews(primary, text)
+	view.OnError = func(err error) {
+		log.Error("failed render message to text view", "err", err)
+	}
+	return view, nil, nil
+}
+
+// openEncrypted unlocks encrypted store, passphrase of new store is asked twice
+func openEncrypted(path, keyFile string) (*store.JSONL, error) {
+	_, err := store.ReadHeader(path)
+	isNew := errors.Is(err, os.ErrNotExist)
+	if err != nil && !isNew {
+		return nil, err
+	}
+
+	pass, err := crypt.Passphrase(keyFile, isNew)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.OpenEncryptedJSONL(path, pass)
 }
 
 func gracefulStop(log *slog.Logger, cancel func()) {
@@ -136,4 +465,31 @@ func createDirInHome(path string) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// homePath returns path inside user home dir, empty if home is unknown
+func homePath(path string) string {
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(userHome, path)
+}
+
+// cachedName returns name saved by cli in ~/ipmsg/cache.json
+func cachedName() string {
+	data, err := os.ReadFile(homePath("ipmsg/cache.json"))
+	if err != nil {
+		return ""
+	}
+
+	var cache struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return ""
+	}
+
+	return cache.Name
+}
@@ -2,7 +2,14 @@ module ipmsg
 
 go 1.25.6
 
-require github.com/hajimehoshi/oto/v2 v2.4.3
+require (
+	github.com/godbus/dbus/v5 v5.1.0
+	github.com/hajimehoshi/oto/v2 v2.4.3
+	golang.org/x/crypto v0.36.0
+	golang.org/x/sys v0.31.0
+	golang.org/x/term v0.30.0
+	golang.org/x/text v0.23.0
+)
 
 require (
 	github.com/ebitengine/purego v0.4.1 // indirect
@@ -10,5 +17,4 @@ require (
 	github.com/prometheus-community/pro-bing v0.7.0 // indirect
 	golang.org/x/net v0.38.0 // indirect
 	golang.org/x/sync v0.13.0 // indirect
-	golang.org/x/sys v0.31.0 // indirect
 )
@@ -1,14 +1,19 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"ipmsg-gui/pkg/apperror"
-	"ipmsg/pkg/models"
+	"ipmsg/pkg/alias"
+	"ipmsg/pkg/apiclient"
 	"ipmsg/pkg/fileparser"
+	"ipmsg/pkg/models"
 	"log/slog"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -17,20 +22,28 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
-var messagesShowed = map[models.IPmsgRequest]struct{}{}
+// shownKey identifies message both in history and in events
+type shownKey struct {
+	from string
+	date int64
+	msg  string
+}
+
+var messagesShowed = map[shownKey]struct{}{}
 
-func showMessages(container *fyne.Container, filepath string) error {
-	messages, err := fileparser.ParseFile(filepath)
+func showMessages(container *fyne.Container, client *apiclient.Client) error {
+	messages, err := client.History(0, 0)
 	if err != nil {
 		return err
 	}
 
 	for _, ms := range messages {
-		if _, showed := messagesShowed[models.IPmsgRequest(ms)]; showed{
+		key := shownKey{ms.From, ms.Date, ms.Msg}
+		if _, showed := messagesShowed[key]; showed {
 			continue
 		}
-		addMessage(container, ms.From, ms.Date, ms.Msg)
-		messagesShowed[models.IPmsgRequest(ms)] = struct{}{}
+		addMessage(container, senderLabel(ms.From, ms.Deliveries), ms.Date, ms.Msg)
+		messagesShowed[key] = struct{}{}
 	}
 
 	return nil
@@ -45,13 +58,13 @@ func main() {
 
 	appError := apperror.New(log, w)
 
-	homeUs, err := os.UserHomeDir()
+	socket, err := apiclient.DefaultSocket()
 	if err != nil {
 		appError.QError("failed get home dir", err)
 		return
 	}
 
-	msgPath := filepath.Join(homeUs, "ipmsg.txt")
+	client := apiclient.NewUnix(socket)
 
 	/* -------- Message Area -------- */
 
@@ -65,29 +78,21 @@ func main() {
 	input := widget.NewEntry()
 	input.SetPlaceHolder("Type message...")
 
-	refreshButton := widget.NewButton("Refresh", func() {
-		if err := showMessages(messageContainer, msgPath); err != nil {
-			appError.QError("failed show messages", err)
-		}
-	})
-
-	refreshButton.OnTapped()
-
 	sendBtn := widget.NewButton("Send", func() {
 		text := input.Text
 		if text == "" {
 			return
 		}
 
-		out, err := runIPMsgWithInput(text)
+		res, err := client.Broadcast(text)
 		if err != nil {
 			appError.QError("failed send message", err)
+			return
 		}
 
-		log.Info("ipmsg output", "out", out)
-
-		if err = showMessages(messageContainer, msgPath); err != nil {
-			appError.QError("failed show messages", err)
+		log.Info("message sent", "sent", res.Sent, "queued", res.Queued)
+		if res.Sent == 0 {
+			appError.QError("failed send message", fmt.Errorf("nobody received message, %d saved to outbox", res.Queued))
 		}
 
 		input.SetText("")
@@ -98,12 +103,79 @@ func main() {
 		sendBtn.OnTapped()
 	}
 
-	bottom := container.NewBorder(nil, nil, refreshButton, container.NewBorder(nil, nil, nil, sendBtn, input))
+	bottom := container.NewBorder(nil, nil, nil, sendBtn, input)
+
+	/* -------- Search Area -------- */
+
+	searchInput := widget.NewEntry()
+	searchInput.SetPlaceHolder(`Search messages (words or "exact phrase")...`)
+	searchInput.OnSubmitted = func(text string) {
+		if text == "" {
+			return
+		}
+
+		results, err := client.Search(apiclient.SearchQuery{Text: text, Limit: 100})
+		if err != nil {
+			appError.QError("failed search messages", err)
+			return
+		}
+
+		showSearchResults(a, text, results)
+	}
+
+	searchBar := container.NewBorder(nil, nil, nil, widget.NewButton("Search", func() {
+		searchInput.OnSubmitted(searchInput.Text)
+	}), searchInput)
+
+	/* -------- Unread Area -------- */
+
+	unreadLabel := widget.NewLabel("")
+	refreshUnread := func() {
+		res, err := client.Unread(false)
+		if err != nil {
+			log.Warn("failed get unread messages", "err", err)
+			return
+		}
+		fyne.Do(func() { unreadLabel.SetText(unreadText(res)) })
+	}
+
+	markReadBtn := widget.NewButton("Mark read", func() {
+		res, err := client.MarkRead(models.MarkRead{All: true})
+		if err != nil {
+			appError.QError("failed mark messages read", err)
+			return
+		}
+		unreadLabel.SetText(unreadText(res))
+	})
+
+	/* -------- Claims Area -------- */
+
+	claimsBtn := widget.NewButton("Name claims", nil)
+	claimsBtn.Hide()
+	var refreshClaims func()
+	refreshClaims = func() {
+		claims, err := client.Claims()
+		if err != nil {
+			log.Warn("failed get name claims", "err", err)
+			return
+		}
+		fyne.Do(func() {
+			if len(claims) == 0 {
+				claimsBtn.Hide()
+				return
+			}
+			claimsBtn.SetText(fmt.Sprintf("Name claims (%d)", len(claims)))
+			claimsBtn.OnTapped = func() { showClaims(a, client, appError, claims, refreshClaims) }
+			claimsBtn.Show()
+		})
+	}
+
+	top := container.NewVBox(searchBar, container.NewBorder(nil, nil, nil, container.NewHBox(claimsBtn, markReadBtn), unreadLabel))
 
 	/* -------- Layout -------- */
 
 	content := container.NewBorder(
-		nil,
+		top,
 		bottom,
 		nil,
 		nil,
@@ -114,16 +186,190 @@ func main() {
 
 	/* -------- Load messages -------- */
 
-	if err := showMessages(messageContainer, msgPath); err != nil {
-		appError.QError("failed show messages", err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := showMessages(messageContainer, client); err != nil {
+		var urlErr *url.Error
+		if !errors.As(err, &urlErr) {
+			appError.QError("failed show messages", err)
+		} else {
+			// server is not running, messages file is followed instead
+			log.Warn("daemon is not running, reading messages file", "err", err)
+			go followFile(ctx, log, messageContainer)
+		}
 	}
 
+	/* -------- Live updates -------- */
+
+	go refreshUnread()
+	go refreshClaims()
+
+	go client.Follow(ctx, func(ev models.Event) {
+		if ev.Kind == models.EventReceived || ev.Kind == models.EventRead {
+			go refreshUnread()
+		}
+		if ev.Kind == models.EventReceived && ev.Message.Alias != "" {
+			go refreshClaims()
+		}
+		if ev.Kind != models.EventReceived && ev.Kind != models.EventOutgoing {
+			return
+		}
+
+		fyne.Do(func() {
+			key := shownKey{ev.Message.From, ev.Message.Date, ev.Message.Msg}
+			if _, showed := messagesShowed[key]; showed {
+				return
+			}
+			addMessage(messageContainer, senderLabel(ev.Message.From, ev.Message.Deliveries), ev.Message.Date, ev.Message.Msg)
+			messagesShowed[key] = struct{}{}
+		})
+	}, func(err error) {
+		log.Warn("lost connection to daemon, reconnecting", "err", err)
+	})
+
 	w.ShowAndRun()
 	log.Info("running")
 }
 
+/* ---------- Messages File ---------- */
+
+// followFile shows messages of ~/ipmsg.txt, only new part of file is read on every check
+func followFile(ctx context.Context, log *slog.Logger, messageContainer *fyne.Container) {
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		log.Error("failed get home dir", "err", err)
+		return
+	}
+
+	fileparser.NewTail(filepath.Join(userHome, "ipmsg.txt")).Follow(ctx, time.Second, func(records []models.IPmsgRequest) {
+		fyne.Do(func() {
+			for _, r := range records {
+				key := shownKey{r.From, r.Date, r.Msg}
+				if _, showed := messagesShowed[key]; showed {
+					continue
+				}
+				addMessage(messageContainer, senderLabel(r.From, r.Deliveries), r.Date, r.Msg)
+				messagesShowed[key] = struct{}{}
+			}
+		})
+	}, func(err error) {
+		log.Warn("failed read messages file", "err", err)
+	})
+}
+
+/* ---------- Unread Counters ---------- */
+
+// unreadText renders counters like "Unread: 3 (alex 2, 192.168.1.7 1)"
+func unreadText(res *models.Unread) string {
+	if res.Total == 0 {
+		return "No unread messages"
+	}
+
+	var parts []string
+	for _, s := range res.Senders {
+		name := s.From
+		if s.Alias != "" {
+			name = s.Alias
+		}
+		parts = append(parts, fmt.Sprintf("%s %d", name, s.Count))
+	}
+
+	return fmt.Sprintf("Unread: %d (%s)", res.Total, strings.Join(parts, ", "))
+}
+
+/* ---------- Name Claims ---------- */
+
+// showClaims lists names sent by peers which wait for approval, done is called after every decision
+func showClaims(a fyne.App, client *apiclient.Client, appError *apperror.Handler, claims []alias.Pending, done func()) {
+	w := a.NewWindow("Name claims")
+	w.Resize(fyne.NewSize(600, 300))
+
+	list := container.NewVBox()
+	for _, p := range claims {
+		text := fmt.Sprintf("%s wants to be %s (%s)", p.Address, p.Name, claimReason(p))
+		label := widget.NewLabel(text)
+		label.Wrapping = fyne.TextWrapWord
+
+		var row *fyne.Container
+		decide := func(approve bool) {
+			var err error
+			if approve {
+				_, err = client.ApproveClaim(p.ID)
+			} else {
+				err = client.RejectClaim(p.ID)
+			}
+			if err != nil {
+				appError.QError("failed save decision", err)
+				return
+			}
+			list.Remove(row)
+			go done()
+		}
+
+		row = container.NewBorder(nil, nil, nil, container.NewHBox(
+			widget.NewButton("Approve", func() { decide(true) }),
+			widget.NewButton("Reject", func() { decide(false) }),
+		), label)
+		list.Add(row)
+	}
+
+	w.SetContent(container.NewVScroll(list))
+	w.Show()
+}
+
+// claimReason explains why claim waits for approval
+func claimReason(p alias.Pending) string {
+	switch p.Reason {
+	case alias.ReasonNew:
+		return "new name"
+	case alias.ReasonTaken:
+		return "address belongs to " + p.Owner
+	case alias.ReasonMoved:
+		if p.Owner != "" && !strings.EqualFold(p.Owner, p.Name) {
+			return "address belongs to " + p.Owner
+		}
+		return "new address, not signed by contact key"
+	}
+	return p.Reason
+}
+
+/* ---------- Search Results ---------- */
+
+func showSearchResults(a fyne.App, text string, results []models.SearchResult) {
+	w := a.NewWindow("Search: " + text)
+	w.Resize(fyne.NewSize(600, 400))
+
+	list := container.NewVBox()
+	if len(results) == 0 {
+		list.Add(widget.NewLabel("Nothing found"))
+	}
+
+	for _, res := range results {
+		from := res.Message.From
+		if res.Message.Alias != "" {
+			from = fmt.Sprintf("%s(%s)", res.Message.Alias, res.Message.From)
+		}
+		if res.Message.Direction == models.DirectionOut {
+			from = senderLabel(from, res.Message.Deliveries)
+		}
+		addMessage(list, from, res.Message.Date, res.Snippet)
+	}
+
+	w.SetContent(container.NewVScroll(list))
+	w.Show()
+}
+
 /* ---------- Message Block ---------- */
 
+// senderLabel returns sender of received message or recipients of sent one (-> alex, 192.168.1.6 [queued])
+func senderLabel(from string, deliveries []models.Delivery) string {
+	if len(deliveries) > 0 {
+		return fileparser.FormatRecipients(deliveries, nil)
+	}
+	return from
+}
+
 func addMessage(messageContainer *fyne.Container, from string, date int64, msg string) {
 	// Create labels
 	timeLabel := widget.NewLabel(
@@ -138,33 +384,3 @@ func addMessage(messageContainer *fyne.Container, from string, date int64, msg s
 	// Add to the message container
 	messageContainer.Add(block)
 }
-
-func runIPMsgWithInput(input string) (string, error) {
-	cmd := exec.Command("ipmsg")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &out
-
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return "", err
-	}
-
-	err = cmd.Start()
-	if err != nil {
-		return "", err
-	}
-
-	// Send input to ipmsg
-	if _, err := stdin.Write([]byte(input + "\n")); err != nil {
-		return "", err
-	}
-	stdin.Close() // finish input
-
-	err = cmd.Wait()
-	if err != nil {
-		return out.String(), err
-	}
-
-	return out.String(), nil
-}
\ No newline at end of file
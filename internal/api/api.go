@@ -0,0 +1,245 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ipmsg/internal/events"
+	"ipmsg/internal/peers"
+	"ipmsg/internal/retention"
+	"ipmsg/pkg/alias"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ipmsg/pkg/identity"
+	"ipmsg/pkg/outbox"
+	"ipmsg/pkg/search"
+	"ipmsg/pkg/store"
+	"ipmsg/pkg/unread"
+)
+
+// Config holds everything local api needs from daemon
+type Config struct {
+	Name      string // alias sent with every message
+	Port      uint   // port of remote ipmsg servers
+	Store     store.Store
+	Search    *search.Index
+	Retention *retention.Runner
+	Alias     *alias.Alias
+	Outbox    *outbox.Outbox
+	Peers     *peers.Registry
+	Events    *events.Bus
+	Unread    *unread.Tracker
+	Identity  *identity.Identity // signs sent messages, may be nil
+	Token     string             // bearer token required from clients of loopback address
+}
+
+// Server is local control api of daemon, served over unix socket and loopback tcp
+type Server struct {
+	cfg    Config
+	log    *slog.Logger
+	router *http.ServeMux
+
+	mu      sync.Mutex
+	webAddr string
+	logins  map[string]struct{} // unused codes of web login urls
+}
+
+func New(log *slog.Logger, cfg Config) *Server {
+	s := &Server{
+		cfg:    cfg,
+		log:    log,
+		router: http.NewServeMux(),
+	}
+
+	s.router.HandleFunc("POST /send", s.handleSend)
+	s.router.HandleFunc("POST /broadcast", s.handleBroadcast)
+	s.router.HandleFunc("GET /history", s.handleHistory)
+	s.router.HandleFunc("POST /sent", s.handleSent)
+	s.router.HandleFunc("POST /sent/{id}/delivery", s.handleDelivery)
+	s.router.HandleFunc("GET /search", s.handleSearch)
+	s.router.HandleFunc("GET /archive", s.handleArchive)
+	s.router.HandleFunc("POST /archive/rotate", s.handleRotate)
+	s.router.HandleFunc("GET /unread", s.handleUnread)
+	s.router.HandleFunc("POST /unread/read", s.handleMarkRead)
+	s.router.HandleFunc("GET /peers", s.handlePeers)
+	s.router.HandleFunc("GET /aliases", s.handleAliases)
+	s.router.HandleFunc("POST /aliases", s.handleAddAlias)
+	s.router.HandleFunc("DELETE /aliases/{name}", s.handleRemoveAlias)
+	s.router.HandleFunc("GET /claims", s.handleClaims)
+	s.router.HandleFunc("POST /claims/{id}/approve", s.handleApproveClaim)
+	s.router.HandleFunc("POST /claims/{id}/reject", s.handleRejectClaim)
+	s.router.HandleFunc("GET /events", s.handleEvents)
+
+	return s
+}
+
+// Handle registers additional handler on api router
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.router.Handle(pattern, h)
+}
+
+// Serve serves api on l until ctx is done, clients of tcp listener must send token from config,
+// unix socket is protected by its file mode
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	const op = "api.Server.Serve"
+
+	token := ""
+	if l.Addr().Network() != "unix" {
+		if s.cfg.Token == "" {
+			l.Close()
+			return fmt.Errorf("%s: token is required for %s", op, l.Addr())
+		}
+		token = s.cfg.Token
+	}
+
+	srv := &http.Server{
+		Handler:           guard(s.router, token),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	err := srv.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// ListenUnix creates unix socket accessible only by current user
+func ListenUnix(path string) (net.Listener, error) {
+	const op = "api.ListenUnix"
+
+	// removing socket left by previous run
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: %s is used by another daemon", op, path)
+		}
+		os.Remove(path)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return l, nil
+}
+
+// ListenLoopback listens tcp addr, only loopback addresses are allowed
+func ListenLoopback(addr string) (net.Listener, error) {
+	const op = "api.ListenLoopback"
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("%s: %s is not loopback address", op, host)
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return l, nil
+}
+
+/* ======== helpers ======== */
+
+// guard rejects requests which could come from web pages opened in browser:
+// foreign Host header (dns rebinding) and POST without json content type (cross site forms),
+// with token set requests also need "Authorization: Bearer <token>" header or cookie set by
+// web login, except login itself
+func guard(next http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		ip := net.ParseIP(host)
+		if host != "ipmsg" && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			writeJSON(w, http.StatusForbidden, errorResponse{Error: "forbidden host"})
+			return
+		}
+
+		if token != "" && r.URL.Path != "/login" && !authorized(r, token) {
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if ct != "application/json" {
+				writeJSON(w, http.StatusUnsupportedMediaType, errorResponse{Error: "content type must be application/json"})
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorized reports whether request has bearer token or cookie of web ui with it
+func authorized(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		c, err := r.Cookie(tokenCookie)
+		if err != nil {
+			return false
+		}
+		got = c.Value
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, msg string, err error) {
+	if err != nil {
+		msg = msg + ": " + err.Error()
+	}
+	if status >= 500 {
+		s.log.Error("api: " + msg)
+	}
+
+	writeJSON(w, status, errorResponse{Error: msg})
+}
+
+func readJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
@@ -0,0 +1,604 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ipmsg/pkg/models"
+	"ipmsg/pkg/netscan"
+	"ipmsg/pkg/search"
+	"ipmsg/pkg/sender"
+	"ipmsg/pkg/store"
+	"ipmsg/pkg/unread"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"ipmsg/pkg/alias"
+)
+
+type sendRequest struct {
+	To       string `json:"to"`
+	Msg      string `json:"msg"`
+	Priority string `json:"priority,omitempty"`
+	Queue    *bool  `json:"queue,omitempty"` // save to outbox if recipient is offline, true by default
+}
+
+type broadcastRequest struct {
+	Msg      string `json:"msg"`
+	Priority string `json:"priority,omitempty"`
+	Scan     bool   `json:"scan,omitempty"` // scan local net instead of using known peers
+	Queue    *bool  `json:"queue,omitempty"`
+}
+
+func (s *Server) handleSend(w http.ResponseWriter, r *http.Request) {
+	var in sendRequest
+	if err := readJSON(r, &in); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if in.To == "" || in.Msg == "" {
+		s.writeError(w, http.StatusBadRequest, "to and msg are required", nil)
+		return
+	}
+
+	if !validPriority(in.Priority) {
+		s.writeError(w, http.StatusBadRequest, "priority must be low, normal or high", nil)
+		return
+	}
+
+	req, err := s.newRequest(in.Msg, in.Priority)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed get local ip", err)
+		return
+	}
+
+	host := s.resolve(in.To)
+	id := models.SentID(req.Date, req.Msg, []string{host})
+	res := s.deliver(host, req, id, 5*time.Second, in.Queue == nil || *in.Queue)
+	s.record(models.NewOutgoing(req, []models.Delivery{res}))
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handleBroadcast(w http.ResponseWriter, r *http.Request) {
+	var in broadcastRequest
+	if err := readJSON(r, &in); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if in.Msg == "" {
+		s.writeError(w, http.StatusBadRequest, "msg is required", nil)
+		return
+	}
+
+	if !validPriority(in.Priority) {
+		s.writeError(w, http.StatusBadRequest, "priority must be low, normal or high", nil)
+		return
+	}
+
+	req, err := s.newRequest(in.Msg, in.Priority)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed get local ip", err)
+		return
+	}
+
+	hosts := s.cfg.Peers.Hosts()
+	if in.Scan || len(hosts) == 0 {
+		hosts, err = netscan.Scan(req.From, s.cfg.Port, 1*time.Second, s.cfg.Peers.Seen)
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, "failed scan network", err)
+			return
+		}
+	}
+
+	var targets []string
+	for _, host := range hosts {
+		if host != req.From {
+			targets = append(targets, host)
+		}
+	}
+	id := models.SentID(req.Date, req.Msg, targets)
+
+	res := models.BroadcastResult{Deliveries: make([]models.Delivery, 0, len(targets))}
+	for _, host := range targets {
+		d := s.deliver(host, req, id, 300*time.Millisecond, in.Queue == nil || *in.Queue)
+		if d.Delivered {
+			res.Sent++
+		}
+		if d.QueuedID != "" {
+			res.Queued++
+		}
+		res.Deliveries = append(res.Deliveries, d)
+	}
+	if len(res.Deliveries) > 0 {
+		s.record(models.NewOutgoing(req, res.Deliveries))
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleSent saves message sent by other client (cli sends without daemon), so history has both sides
+func (s *Server) handleSent(w http.ResponseWriter, r *http.Request) {
+	var msg models.Message
+	if err := readJSON(r, &msg); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if msg.Direction != models.DirectionOut || len(msg.Deliveries) == 0 {
+		s.writeError(w, http.StatusBadRequest, "sent message with deliveries is required", nil)
+		return
+	}
+
+	out := models.NewOutgoing(&models.IPmsgRequest{
+		From:     msg.From,
+		Len:      msg.Len,
+		Date:     msg.Date,
+		Msg:      msg.Msg,
+		Alias:    msg.Alias,
+		Priority: msg.Priority,
+	}, msg.Deliveries)
+	if err := s.record(out); err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed save message", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleDelivery saves new result of one recipient of sent message, cli reports outbox
+// items it delivered or canceled itself
+func (s *Server) handleDelivery(w http.ResponseWriter, r *http.Request) {
+	var d models.Delivery
+	if err := readJSON(r, &d); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if d.To == "" || d.Status == "" {
+		s.writeError(w, http.StatusBadRequest, "to and status are required", nil)
+		return
+	}
+
+	err := s.cfg.Store.UpdateDelivery(r.PathValue("id"), d)
+	if errors.Is(err, store.ErrNotFound) {
+		s.writeError(w, http.StatusNotFound, "message not found", nil)
+		return
+	}
+	if errors.Is(err, store.ErrUnsupported) {
+		s.writeError(w, http.StatusNotImplemented, "store does not keep deliveries", nil)
+		return
+	}
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed save delivery", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	query := store.Query{Direction: q.Get("direction")}
+	if from := q.Get("from"); from != "" {
+		query.From = s.resolve(from)
+	}
+	if since, err := strconv.ParseInt(q.Get("since"), 10, 64); err == nil {
+		query.Since = since
+	}
+	if limit, err := strconv.Atoi(q.Get("limit")); err == nil && limit >= 0 {
+		query.Limit = limit
+	}
+
+	messages, err := s.cfg.Store.Query(query)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed read history", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, messages)
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	contacts, err := s.cfg.Alias.Contacts()
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed read aliases", err)
+		return
+	}
+
+	query := search.Query{Text: q.Get("q"), Limit: 50}
+	if from := q.Get("from"); from != "" {
+		query.From = alias.Expand(contacts, from)
+	}
+	if group := q.Get("group"); group != "" {
+		members := alias.Members(contacts, group)
+		if len(members) == 0 {
+			s.writeError(w, http.StatusNotFound, "unknown group", alias.ErrGroupNotFound)
+			return
+		}
+		query.Group = alias.Expand(contacts, members...)
+	}
+	if since, err := strconv.ParseInt(q.Get("since"), 10, 64); err == nil {
+		query.Since = since
+	}
+	if until, err := strconv.ParseInt(q.Get("until"), 10, 64); err == nil {
+		query.Until = until
+	}
+	if limit, err := strconv.Atoi(q.Get("limit")); err == nil && limit >= 0 {
+		query.Limit = limit
+	}
+	if n, err := strconv.Atoi(q.Get("context")); err == nil && n >= 0 {
+		query.Context = n
+	}
+
+	writeJSON(w, http.StatusOK, s.cfg.Search.Search(query))
+}
+
+func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request) {
+	files, err := s.cfg.Retention.Files()
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed list archive", err)
+		return
+	}
+	if files == nil {
+		files = []store.ArchiveFile{}
+	}
+
+	writeJSON(w, http.StatusOK, files)
+}
+
+func (s *Server) handleRotate(w http.ResponseWriter, r *http.Request) {
+	res, err := s.cfg.Retention.Rotate(time.Now())
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed rotate history", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.RotateResult{Archived: res.Archived, Deleted: res.Deleted})
+}
+
+// handleUnread returns unread counters by sender, messages themselves with messages=true
+func (s *Server) handleUnread(w http.ResponseWriter, r *http.Request) {
+	msgs, err := s.unreadMessages()
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed read unread messages", err)
+		return
+	}
+
+	res := models.Unread{Total: len(msgs), Senders: unread.BySender(msgs)}
+	if r.URL.Query().Get("messages") == "true" {
+		res.Messages = msgs
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handleMarkRead(w http.ResponseWriter, r *http.Request) {
+	var in models.MarkRead
+	if err := readJSON(r, &in); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	msgs, err := s.unreadMessages()
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed read unread messages", err)
+		return
+	}
+
+	ids := map[string]bool{}
+	for _, id := range in.IDs {
+		ids[id] = true
+	}
+	from := ""
+	if in.From != "" {
+		from = s.resolve(in.From)
+	}
+
+	var marked, left []models.Message
+	for _, m := range msgs {
+		if in.All || ids[m.ID] || (from != "" && m.Involves(from)) {
+			marked = append(marked, m)
+		} else {
+			left = append(left, m)
+		}
+	}
+
+	if len(marked) > 0 {
+		if err := s.cfg.Unread.MarkRead(marked...); err != nil {
+			s.writeError(w, http.StatusInternalServerError, "failed mark messages read", err)
+			return
+		}
+		s.cfg.Events.Publish(models.Event{Kind: models.EventRead})
+	}
+
+	writeJSON(w, http.StatusOK, models.Unread{Total: len(left), Senders: unread.BySender(left)})
+}
+
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	names, err := s.cfg.Alias.GetNames()
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed read aliases", err)
+		return
+	}
+
+	list := s.cfg.Peers.List()
+	for i := range list {
+		if name, ok := names[list[i].Host]; ok {
+			list[i].Alias = name
+		}
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleAliases(w http.ResponseWriter, r *http.Request) {
+	contacts, err := s.cfg.Alias.Contacts()
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed read aliases", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, aliasEntries(contacts))
+}
+
+func (s *Server) handleAddAlias(w http.ResponseWriter, r *http.Request) {
+	var in models.AliasEntry
+	if err := readJSON(r, &in); err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid request", err)
+		return
+	}
+
+	if in.Name == "" || in.Address == "" {
+		s.writeError(w, http.StatusBadRequest, "name and address are required", nil)
+		return
+	}
+
+	if err := s.cfg.Alias.AddName(in.Name, in.Address); err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed add alias", err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, in)
+}
+
+func (s *Server) handleRemoveAlias(w http.ResponseWriter, r *http.Request) {
+	err := s.cfg.Alias.Remove(r.PathValue("name"))
+	if errors.Is(err, alias.ErrNotFound) {
+		s.writeError(w, http.StatusNotFound, "alias not found", nil)
+		return
+	}
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed remove alias", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleClaims(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.cfg.Alias.Claims()
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed read claims", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, claims)
+}
+
+func (s *Server) handleApproveClaim(w http.ResponseWriter, r *http.Request) {
+	res, err := s.cfg.Alias.Approve(r.PathValue("id"))
+	if errors.Is(err, alias.ErrNotFound) {
+		s.writeError(w, http.StatusNotFound, "claim not found", nil)
+		return
+	}
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed approve claim", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handleRejectClaim(w http.ResponseWriter, r *http.Request) {
+	err := s.cfg.Alias.Reject(r.PathValue("id"))
+	if errors.Is(err, alias.ErrNotFound) {
+		s.writeError(w, http.StatusNotFound, "claim not found", nil)
+		return
+	}
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed reject claim", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleEvents streams events as server-sent events until client disconnects,
+// client resumes from Last-Event-ID header or since query param
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, http.StatusInternalServerError, "streaming is not supported", nil)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("since")
+	}
+	after, _ := strconv.ParseUint(lastID, 10, 64)
+
+	ch, cancel := s.cfg.Events.Subscribe(after)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case ev, ok := <-ch:
+			if !ok {
+				return // too slow, client reconnects with Last-Event-ID
+			}
+
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Kind, data)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+/* ======== internal ======== */
+
+func (s *Server) newRequest(msg, priority string) (*models.IPmsgRequest, error) {
+	if priority == "normal" {
+		priority = models.PriorityNormal
+	}
+
+	myIP, err := netscan.LocalIP()
+	if err != nil {
+		return nil, err
+	}
+
+	req := &models.IPmsgRequest{
+		From:     myIP,
+		Len:      len(msg),
+		Date:     time.Now().Unix(),
+		Alias:    s.cfg.Name,
+		Msg:      msg,
+		Priority: priority,
+	}
+	if s.cfg.Identity != nil {
+		s.cfg.Identity.Sign(req)
+	}
+
+	return req, nil
+}
+
+func validPriority(p string) bool {
+	return p == models.PriorityLow || p == models.PriorityNormal || p == "normal" || p == models.PriorityHigh
+}
+
+// resolve turns contact name into its current address, unknown names are returned as is
+func (s *Server) resolve(to string) string {
+	c, err := s.cfg.Alias.Get(to)
+	if err != nil || c.Address() == "" {
+		return to
+	}
+
+	return c.Address()
+}
+
+// portOf returns preferred port of contact with host, default port otherwise
+func (s *Server) portOf(host string) uint {
+	c, err := s.cfg.Alias.Get(host)
+	if err != nil || c.Port == 0 {
+		return s.cfg.Port
+	}
+
+	return uint(c.Port)
+}
+
+// unreadMessages returns unread received messages, oldest first
+func (s *Server) unreadMessages() ([]models.Message, error) {
+	st, err := s.cfg.Unread.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	since, ok := st.Since()
+	if !ok {
+		return []models.Message{}, nil
+	}
+
+	msgs, err := s.cfg.Store.Query(store.Query{Direction: models.DirectionIn, Since: since})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.cfg.Unread.Unread(msgs)
+}
+
+// deliver sends req to host, undelivered message is saved to outbox with id of sent message
+func (s *Server) deliver(host string, req *models.IPmsgRequest, id string, timeout time.Duration, queue bool) models.Delivery {
+	addr := sender.Addr(host, s.portOf(host))
+	res := models.Delivery{To: host}
+
+	s.cfg.Events.Publish(models.Event{Kind: models.EventSent, To: addr, Message: *req})
+
+	err := sender.Send(addr, req, timeout)
+	s.cfg.Events.Delivery(addr, req, err)
+	if err == nil {
+		res.Delivered = true
+		res.Status = models.StatusSent
+		s.cfg.Peers.Seen(host)
+		return res
+	}
+
+	res.Status = models.StatusFailed
+	res.Error = err.Error()
+
+	if queue && !errors.Is(err, sender.ErrRejected) {
+		item, qErr := s.cfg.Outbox.Add(addr, *req, id, err)
+		if qErr != nil {
+			s.log.Error("failed save message to outbox", "to", addr, "err", qErr)
+			return res
+		}
+		res.QueuedID = item.ID
+		res.Status = models.StatusQueued
+	}
+
+	return res
+}
+
+// record saves sent message to history, message is already sent, so handlers only log failure
+func (s *Server) record(msg *models.Message) error {
+	if err := s.cfg.Store.Append(msg); err != nil {
+		s.log.Error("failed save sent message", "err", err)
+		return err
+	}
+
+	s.cfg.Events.Publish(models.Event{Kind: models.EventOutgoing, Message: msg.Request()})
+	return nil
+}
+
+// aliasEntries turns contacts into name - current address pairs
+func aliasEntries(contacts []alias.Contact) []models.AliasEntry {
+	res := make([]models.AliasEntry, 0, len(contacts))
+	for _, c := range contacts {
+		res = append(res, models.AliasEntry{Name: c.Name, Address: c.Address()})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+
+	return res
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TokenFileName is file in ~/ipmsg with bearer token of api served on loopback address
+const TokenFileName = "api.token"
+
+// LoadToken reads api token, token is generated and written readable only by current user if file is missing
+func LoadToken(path string) (string, error) {
+	const op = "api.LoadToken"
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		token, err := createToken(path)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+		return token, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("%s: empty token file %s", op, path)
+	}
+
+	return token, nil
+}
+
+/* ======== internal ======== */
+
+// createToken generates token and writes it, token written by other process first wins
+func createToken(path string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+
+	// temp file is created with 0600 mode
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".api-token-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(token + "\n"); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	// link does not replace existing file, unlike rename
+	err = os.Link(tmp.Name(), path)
+	if errors.Is(err, os.ErrExist) {
+		return LoadToken(path)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
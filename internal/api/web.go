@@ -0,0 +1,92 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"ipmsg/pkg/models"
+	"net/http"
+)
+
+// browser can not send bearer token, so one-time login url puts token into cookie
+const tokenCookie = "ipmsg_token"
+
+// ServeWeb registers browser ui on api router and returns one-time login url of it,
+// addr is loopback address api is served on
+func (s *Server) ServeWeb(addr string, ui http.Handler) (string, error) {
+	const op = "api.Server.ServeWeb"
+
+	if s.cfg.Token == "" {
+		return "", fmt.Errorf("%s: token is required for web ui", op)
+	}
+
+	s.mu.Lock()
+	s.webAddr = addr
+	s.mu.Unlock()
+
+	s.router.Handle("GET /", ui)
+	s.router.HandleFunc("GET /login", s.handleLogin)
+	s.router.HandleFunc("POST /web/login", s.handleWebLogin)
+
+	url, err := s.loginURL()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	return url, nil
+}
+
+// handleLogin exchanges one-time code for cookie with api token and opens ui
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+
+	s.mu.Lock()
+	_, ok := s.logins[code]
+	delete(s.logins, code)
+	s.mu.Unlock()
+
+	if code == "" || !ok {
+		writeJSON(w, http.StatusForbidden, errorResponse{Error: "login url is already used, get new one with: ipmsg web"})
+		return
+	}
+
+	// lax cookie is not sent with posts from other sites, api accepts only json posts anyway
+	http.SetCookie(w, &http.Cookie{
+		Name:     tokenCookie,
+		Value:    s.cfg.Token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleWebLogin returns new one-time login url of web ui
+func (s *Server) handleWebLogin(w http.ResponseWriter, r *http.Request) {
+	url, err := s.loginURL()
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, "failed create login url", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, models.WebLogin{URL: url})
+}
+
+/* ======== internal ======== */
+
+func (s *Server) loginURL() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := hex.EncodeToString(buf)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.logins == nil {
+		s.logins = make(map[string]struct{})
+	}
+	s.logins[code] = struct{}{}
+
+	return "http://" + s.webAddr + "/login?code=" + code, nil
+}
@@ -0,0 +1,109 @@
+package events
+
+import (
+	"ipmsg/pkg/models"
+	"sync"
+	"time"
+)
+
+const (
+	historySize = 1024
+	subBuffer   = 64
+)
+
+// Bus fans out events to all subscribers and keeps last events for resuming,
+// slow subscribers are dropped and have to resubscribe from last seen id
+type Bus struct {
+	subs    map[chan models.Event]struct{}
+	history []models.Event
+	lastID  uint64
+
+	mu sync.Mutex
+}
+
+func New() *Bus {
+	return &Bus{
+		subs: map[chan models.Event]struct{}{},
+		// ids start from start time, so they keep growing after daemon restart
+		lastID: uint64(time.Now().UnixMicro()),
+	}
+}
+
+// Publish assigns id to event and sends it to subscribers
+func (b *Bus) Publish(ev models.Event) models.Event {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	ev.ID = b.lastID
+
+	b.history = append(b.history, ev)
+	if len(b.history) > historySize {
+		b.history = b.history[len(b.history)-historySize:]
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default: // subscriber is too slow, dropping it
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+
+	return ev
+}
+
+// Subscribe returns events published after id afterID (zero for only new events)
+// and func to stop subscription. Channel is closed when subscriber is dropped
+func (b *Bus) Subscribe(afterID uint64) (<-chan models.Event, func()) {
+	b.mu.Lock()
+
+	var backlog []models.Event
+	if afterID != 0 {
+		for _, ev := range b.history {
+			if ev.ID > afterID {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+
+	ch := make(chan models.Event, subBuffer+len(backlog))
+	for _, ev := range backlog {
+		ch <- ev
+	}
+	b.subs[ch] = struct{}{}
+
+	b.mu.Unlock()
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+
+	return ch, cancel
+}
+
+// Delivery publishes result of sending req to addr
+func (b *Bus) Delivery(addr string, req *models.IPmsgRequest, err error) {
+	if b == nil {
+		return
+	}
+
+	ev := models.Event{Kind: models.EventAcked, To: addr, Message: *req}
+	if err != nil {
+		ev.Kind = models.EventFailed
+		ev.Error = err.Error()
+	}
+
+	b.Publish(ev)
+}
@@ -1,82 +1,84 @@
 package filesaver
 
 import (
-	"bufio"
 	"fmt"
-	"ipmsg/pkg/models"
 	"ipmsg/pkg/alias"
-	"os"
+	"ipmsg/pkg/models"
+	"ipmsg/pkg/store"
+	"ipmsg/pkg/unread"
+	"log/slog"
 	"time"
 )
 
+// maxClaimAge is how old signed message can be to move contact, older one may be replayed
+const maxClaimAge = time.Hour
 
 type FileSaver struct {
+	store store.Store
+	alias *alias.Alias
+	log   *slog.Logger
+
+	// Unread records saved messages as unread, may be nil
+	Unread *unread.Tracker
 }
 
-func New(al map[string]string) *FileSaver {
+func New(log *slog.Logger, st store.Store, al *alias.Alias) *FileSaver {
 	return &FileSaver{
+		store: st,
+		alias: al,
+		log:   log,
 	}
 }
 
-func (fs *FileSaver) SaveToFile(filename string, req *models.IPmsgRequest, alSaver *alias.Alias) error {
-	const op = "filesaver.SaveToFile"
+// Save remembers alias claimed by sender and appends message to store
+func (fs *FileSaver) Save(msg *models.Message) error {
+	const op = "filesaver.Save"
+
+	if msg.Alias != "" {
+		res, err := fs.alias.Claim(msg.Alias, msg.From, trusted(msg))
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
 
-	if req.Alias != "" {
-		if err := alSaver.AddName(req.Alias, req.From); err != nil {
-			return err
+		switch {
+		case res.Added != nil:
+			fs.log.Info("new contact", "alias", msg.Alias, "address", msg.From, "signer", msg.Signer)
+		case res.Move != nil:
+			fs.log.Info("contact address changed", "alias", msg.Alias, "old", res.Move.From, "new", res.Move.To, "signer", res.Move.Signer)
+		case res.Pinned != nil:
+			fs.log.Info("contact pinned to key of sender", "alias", res.Pinned.Name, "address", msg.From, "signer", res.Pinned.Fingerprint)
+		case res.Pending != nil:
+			fs.log.Warn("name claim waits for approval, see 'ipmsg alias claims'",
+				"alias", msg.Alias, "address", msg.From, "reason", res.Pending.Reason, "owner", res.Pending.Owner, "id", res.Pending.ID)
 		}
 	}
 
-	file, err := os.OpenFile(filename, os.O_CREATE| os.O_RDWR, 0644)
-	if err != nil {
+	if err := fs.store.Append(msg); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
-	defer file.Close()
 
-	buff := bufio.NewScanner(file)
-
-	buff.Scan()
-	if len(buff.Text()) == 0 {
-		if err := writeTableHeaders(file); err != nil {
-			return fmt.Errorf("%s: %w", op, err)
+	// message is already saved, so sender gets success even if read state is not updated
+	if fs.Unread != nil {
+		if err := fs.Unread.Arrived(*msg); err != nil {
+			fs.log.Error("failed record unread message", "from", msg.From, "err", err)
 		}
 	}
 
-	from := req.From
+	return nil
+}
 
-	aliases, err := alSaver.GetNames()
-	if err != nil {
-		return err
-	}
+/* ======== internal ======== */
 
-	if name, exists := aliases[req.From]; exists {
-		from = fmt.Sprintf("%s(%s)", name, req.From)
+// trusted returns signer of message if it really came from claimed address and is fresh
+func trusted(msg *models.Message) string {
+	if msg.Signer == "" || msg.HasFlag(models.FlagFromMismatch) {
+		return ""
 	}
 
-	_, err = fmt.Fprintf(
-		file,
-		"%-20s | %-30s | %6d\n%s\n\n",
-		time.Unix(req.Date, 0).Format(time.DateTime),
-		from,
-		req.Len,
-		req.Msg,
-	)
-	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+	age := msg.Received.Sub(time.Unix(msg.Date, 0))
+	if age > maxClaimAge || age < -maxClaimAge {
+		return ""
 	}
 
-	return nil
-}
-
-func writeTableHeaders(w *os.File) error {
-	headers := fmt.Sprintf(
-		"%-20s | %-30s | %6s\n%s\n",
-		"TIME",
-		"FROM",
-		"LEN",
-		"---------------------------------------------------------------",
-	)
-
-	_, err := w.WriteString(headers)
-	return err
+	return msg.Signer
 }
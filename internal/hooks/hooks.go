@@ -0,0 +1,322 @@
+// package for running user commands on every received message
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ipmsg/internal/events"
+	"ipmsg/pkg/models"
+	"log/slog"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	maxParallel    = 4
+	// queueSize is number of hook runs waiting for worker, runs over it are dropped
+	queueSize = 64
+)
+
+const (
+	StdinJSON = "json" // message as json object
+	StdinBody = "body" // only message text
+	StdinNone = "none"
+)
+
+// Hook is one command from hooks file, example:
+//
+//	{"name": "log", "command": "logger", "args": ["-t", "ipmsg"], "from": ["alex"], "match": "(?i)urgent", "timeout": "5s"}
+type Hook struct {
+	Name    string   `json:"name"`
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	From    []string `json:"from,omitempty"`  // contact names or addresses, empty for everyone
+	Match   string   `json:"match,omitempty"` // regexp for message text
+	Timeout string   `json:"timeout,omitempty"`
+	Stdin   string   `json:"stdin,omitempty"` // json (default), body or none
+
+	match   *regexp.Regexp
+	timeout time.Duration
+}
+
+// Runner runs hooks from file for received events, file is reloaded when it changes
+type Runner struct {
+	filePath string
+	log      *slog.Logger
+	// Names returns address - contact name map, hooks from names match senders by address
+	// through it, alias claimed in message is never trusted. May be nil
+	Names func() (map[string]string, error)
+
+	hooks   []Hook
+	modTime time.Time
+	queue   chan job
+
+	mu sync.Mutex
+}
+
+func New(log *slog.Logger, path string) *Runner {
+	return &Runner{
+		filePath: path,
+		log:      log,
+		queue:    make(chan job, queueSize),
+	}
+}
+
+// job is hook run for received event
+type job struct {
+	hook Hook
+	ev   models.Event
+}
+
+// Load reads hooks file, missing file means no hooks
+func Load(path string) ([]Hook, error) {
+	const op = "hooks.Load"
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+
+	var hooks []Hook
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for i := range hooks {
+		h := &hooks[i]
+
+		if h.Command == "" {
+			return nil, fmt.Errorf("%s: hook %d has no command", op, i)
+		}
+		if h.Name == "" {
+			h.Name = h.Command
+		}
+
+		if h.Match != "" {
+			h.match, err = regexp.Compile(h.Match)
+			if err != nil {
+				return nil, fmt.Errorf("%s: hook %s: %w", op, h.Name, err)
+			}
+		}
+
+		h.timeout = defaultTimeout
+		if h.Timeout != "" {
+			h.timeout, err = time.ParseDuration(h.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("%s: hook %s: %w", op, h.Name, err)
+			}
+		}
+
+		switch h.Stdin {
+		case "":
+			h.Stdin = StdinJSON
+		case StdinJSON, StdinBody, StdinNone:
+		default:
+			return nil, fmt.Errorf("%s: hook %s: unknown stdin mode %q", op, h.Name, h.Stdin)
+		}
+	}
+
+	return hooks, nil
+}
+
+// Run executes hooks for every received message until ctx is done, after bus drops
+// subscription it subscribes again from last handled event, so no message is skipped.
+// At most maxParallel hooks run at once, runs which do not fit in queue are dropped
+func (r *Runner) Run(ctx context.Context, bus *events.Bus) {
+	var wg sync.WaitGroup
+	for range maxParallel {
+		wg.Go(func() { r.work(ctx) })
+	}
+
+	var lastID uint64
+	for ctx.Err() == nil {
+		lastID = r.listen(ctx, bus, lastID)
+	}
+
+	wg.Wait()
+}
+
+// Matches reports whether hook filters accept message from sender address, names is
+// address - contact name map
+func (h *Hook) Matches(msg *models.IPmsgRequest, sender string, names map[string]string) bool {
+	if len(h.From) > 0 {
+		name := names[sender]
+		found := false
+		for _, f := range h.From {
+			if f == sender || (name != "" && f == name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if h.match != nil && !h.match.MatchString(msg.Msg) {
+		return false
+	}
+
+	return true
+}
+
+/* ======== internal ======== */
+
+// listen queues hooks for events published after afterID until ctx is done or bus drops
+// subscription, returns id of last handled event. Full queue drops hook runs, so slow hooks
+// never hold up reading of events
+func (r *Runner) listen(ctx context.Context, bus *events.Bus, afterID uint64) uint64 {
+	ch, cancel := bus.Subscribe(afterID)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return afterID
+		case ev, ok := <-ch:
+			if !ok {
+				return afterID
+			}
+			afterID = ev.ID
+			if ev.Kind != models.EventReceived {
+				continue
+			}
+
+			hooks := r.current()
+			if len(hooks) == 0 {
+				continue
+			}
+			names := r.names()
+
+			for _, h := range hooks {
+				if !h.Matches(&ev.Message, ev.Sender(), names) {
+					continue
+				}
+
+				select {
+				case r.queue <- job{hook: h, ev: ev}:
+				default:
+					r.log.Warn("hooks are busy, hook skipped", "hook", h.Name, "from", ev.Sender())
+				}
+			}
+		}
+	}
+}
+
+// work runs queued hooks until ctx is done
+func (r *Runner) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-r.queue:
+			r.exec(ctx, j.hook, &j.ev)
+		}
+	}
+}
+
+// names returns contact names by address, hooks then match only addresses if book is unreadable
+func (r *Runner) names() map[string]string {
+	if r.Names == nil {
+		return nil
+	}
+
+	names, err := r.Names()
+	if err != nil {
+		r.log.Warn("failed read contacts for hooks", "err", err)
+		return nil
+	}
+	return names
+}
+
+// current returns hooks, rereading file if it was changed
+func (r *Runner) current() []Hook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.filePath)
+	if err != nil {
+		r.hooks = nil
+		r.modTime = time.Time{}
+		return nil
+	}
+
+	if info.ModTime().Equal(r.modTime) {
+		return r.hooks
+	}
+
+	hooks, err := Load(r.filePath)
+	if err != nil {
+		r.log.Error("failed load hooks, keeping previous", "err", err)
+		return r.hooks
+	}
+
+	r.hooks = hooks
+	r.modTime = info.ModTime()
+	r.log.Info("loaded hooks", "count", len(hooks))
+
+	return r.hooks
+}
+
+func (r *Runner) exec(ctx context.Context, h Hook, ev *models.Event) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	msg := &ev.Message
+	cmd := exec.CommandContext(ctx, h.Command, h.Args...)
+	// children of killed hook may keep output open, Run must not wait for them
+	cmd.WaitDelay = time.Second
+	cmd.Env = append(os.Environ(),
+		"IPMSG_FROM="+msg.From,
+		"IPMSG_OBSERVED="+ev.Sender(),
+		"IPMSG_SIGNER="+ev.Signer,
+		"IPMSG_ALIAS="+msg.Alias,
+		"IPMSG_DATE="+strconv.FormatInt(msg.Date, 10),
+		"IPMSG_TIME="+time.Unix(msg.Date, 0).Format(time.RFC3339),
+		"IPMSG_LEN="+strconv.Itoa(msg.Len),
+	)
+
+	switch h.Stdin {
+	case StdinJSON:
+		data, err := json.Marshal(msg)
+		if err != nil {
+			r.log.Error("failed encode message for hook", "hook", h.Name, "err", err)
+			return
+		}
+		cmd.Stdin = bytes.NewReader(data)
+	case StdinBody:
+		cmd.Stdin = bytes.NewReader([]byte(msg.Msg))
+	}
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	start := time.Now()
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		r.log.Warn("hook timed out", "hook", h.Name, "timeout", h.timeout)
+		return
+	}
+	if err != nil {
+		r.log.Warn("hook failed", "hook", h.Name, "err", err, "output", out.String())
+		return
+	}
+
+	r.log.Debug("hook finished", "hook", h.Name, "took", time.Since(start))
+}
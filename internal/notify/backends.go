@@ -0,0 +1,116 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"ipmsg/internal/beep"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Beep plays notification sound through audio device
+type Beep struct {
+	PCM []byte // mono 16 bit PCM, default melody if nil
+}
+
+func (b Beep) Notify(context.Context, Notification) error {
+	if !beep.Available() {
+		return fmt.Errorf("beep: %w", ErrUnavailable)
+	}
+
+	beep.Play(b.PCM)
+	return nil
+}
+
+// Bell writes terminal bell and short line to W (stdout if nil)
+type Bell struct {
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+func (b *Bell) Notify(_ context.Context, n Notification) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	w := b.W
+	if w == nil {
+		w = os.Stdout
+	}
+
+	_, err := fmt.Fprintf(w, "\a%s\n", n.Title)
+	return err
+}
+
+// Exec runs command for notification, message is passed in environment like for hooks
+// and its text on stdin
+type Exec struct {
+	Command string
+	Args    []string
+	Timeout time.Duration
+}
+
+func (e *Exec) Notify(ctx context.Context, n Notification) error {
+	timeout := e.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	msg := n.Message
+	cmd := exec.CommandContext(ctx, e.Command, e.Args...)
+	cmd.Env = append(os.Environ(),
+		"IPMSG_TITLE="+n.Title,
+		"IPMSG_BODY="+n.Body,
+		"IPMSG_PRIORITY="+n.Priority,
+		"IPMSG_FROM="+msg.From,
+		"IPMSG_OBSERVED="+n.Sender(),
+		"IPMSG_SIGNER="+n.Signer,
+		"IPMSG_ALIAS="+msg.Alias,
+		"IPMSG_DATE="+strconv.FormatInt(msg.Date, 10),
+		"IPMSG_LEN="+strconv.Itoa(msg.Len),
+	)
+	cmd.Stdin = strings.NewReader(msg.Msg)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("exec %s: %w: %s", e.Command, err, bytes.TrimSpace(out.Bytes()))
+	}
+
+	return nil
+}
+
+// Recorder keeps notifications in memory, fake backend for tests
+type Recorder struct {
+	Err error // returned from Notify if set
+
+	list []Notification
+	mu   sync.Mutex
+}
+
+func (r *Recorder) Notify(_ context.Context, n Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.list = append(r.list, n)
+	return r.Err
+}
+
+// Notifications returns copy of recorded notifications
+func (r *Recorder) Notifications() []Notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Notification(nil), r.list...)
+}
@@ -0,0 +1,221 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ipmsg/pkg/alias"
+	"ipmsg/pkg/melody"
+	"ipmsg/pkg/models"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	BackendAuto = "auto" // beep, dbus or bell, first which works
+	BackendBeep = "beep"
+	BackendDBus = "dbus"
+	BackendBell = "bell"
+	BackendExec = "exec"
+	BackendNone = "none"
+)
+
+// Config is content of notify file, example:
+//
+//	{
+//	  "rules": [
+//	    {"from": ["boss"], "notifiers": ["beep", "dbus"], "sound": "C6:80 v0.6 G6:200"},
+//	    {"from": ["alex", "bob"], "notifiers": ["beep"], "sound": "/home/me/team.wav"},
+//	    {"group": "office", "notifiers": ["beep"], "sound": "E5:120 C5:120"},
+//	    {"priority": ["high"], "notifiers": ["dbus", "exec"]},
+//	    {"notifiers": ["auto"]}
+//	  ],
+//	  "exec": {"command": "notify.sh"}
+//	}
+type Config struct {
+	Rules []Rule      `json:"rules"`
+	Exec  *ExecConfig `json:"exec,omitempty"`
+	Bell  *BellConfig `json:"bell,omitempty"`
+}
+
+// Rule selects notifiers for messages, first matching rule is used
+type Rule struct {
+	From      []string `json:"from,omitempty"`     // contact names or addresses, empty for everyone
+	Group     string   `json:"group,omitempty"`    // contact group, empty for everyone
+	Priority  []string `json:"priority,omitempty"` // low, normal or high, empty for any
+	Notifiers []string `json:"notifiers"`
+	Sound     string   `json:"sound,omitempty"` // melody or path to wav file for beep
+}
+
+type ExecConfig struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	Timeout string   `json:"timeout,omitempty"`
+}
+
+type BellConfig struct {
+	Device string `json:"device,omitempty"` // file bell is written to (example: /dev/tty), stdout if empty
+}
+
+// DefaultConfig keeps old behaviour: sound for every message, with fallbacks for headless machines
+func DefaultConfig() Config {
+	return Config{
+		Rules: []Rule{{Notifiers: []string{BackendAuto}}},
+	}
+}
+
+// Load reads config from path, missing file means default config
+func Load(path string) (Config, error) {
+	const op = "notify.Load"
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultConfig(), nil
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return DefaultConfig(), nil
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(cfg.Rules) == 0 {
+		cfg.Rules = DefaultConfig().Rules
+	}
+
+	return cfg, nil
+}
+
+// Router picks notifiers by sender and priority of message
+type Router struct {
+	rules []route
+
+	// Contacts returns address book, rules match names and groups by observed sender address
+	// through it, alias and address claimed in message are never trusted. May be nil
+	Contacts func() ([]alias.Contact, error)
+}
+
+type route struct {
+	rule     Rule
+	notifier Notifier
+}
+
+// Build creates router from config
+func Build(cfg Config) (*Router, error) {
+	const op = "notify.Build"
+
+	backends := map[string]Notifier{
+		BackendBeep: Beep{},
+		BackendDBus: &DBus{},
+		BackendNone: Nop{},
+	}
+
+	bell := &Bell{}
+	if cfg.Bell != nil && cfg.Bell.Device != "" {
+		f, err := os.OpenFile(cfg.Bell.Device, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			return nil, fmt.Errorf("%s: bell: %w", op, err)
+		}
+		bell.W = f
+	}
+	backends[BackendBell] = bell
+	backends[BackendAuto] = Fallback{backends[BackendBeep], backends[BackendDBus], bell}
+
+	if cfg.Exec != nil {
+		e := &Exec{Command: cfg.Exec.Command, Args: cfg.Exec.Args}
+		if cfg.Exec.Timeout != "" {
+			d, err := time.ParseDuration(cfg.Exec.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("%s: exec timeout: %w", op, err)
+			}
+			e.Timeout = d
+		}
+		backends[BackendExec] = e
+	}
+
+	r := &Router{}
+	for _, rule := range cfg.Rules {
+		ruleBackends := backends
+
+		if rule.Sound != "" {
+			sound, err := melody.Load(rule.Sound)
+			if err != nil {
+				return nil, fmt.Errorf("%s: sound %q: %w", op, rule.Sound, err)
+			}
+
+			ruleBackends = maps.Clone(backends)
+			ruleBackends[BackendBeep] = Beep{PCM: sound.PCM}
+			ruleBackends[BackendAuto] = Fallback{ruleBackends[BackendBeep], backends[BackendDBus], bell}
+		}
+
+		var list Multi
+		for _, name := range rule.Notifiers {
+			n, ok := ruleBackends[name]
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown or not configured notifier %q", op, name)
+			}
+			list = append(list, n)
+		}
+
+		r.rules = append(r.rules, route{rule: rule, notifier: list})
+	}
+
+	return r, nil
+}
+
+func (r *Router) Notify(ctx context.Context, n Notification) error {
+	sender := n.Sender()
+	var contact alias.Contact
+	if r.Contacts != nil {
+		// rules with names and groups do not match if contacts can not be read
+		if contacts, err := r.Contacts(); err == nil {
+			contact, _ = alias.Owner(contacts, sender)
+		}
+	}
+
+	for _, rt := range r.rules {
+		if rt.rule.matches(&n.Message, sender, &contact) {
+			return rt.notifier.Notify(ctx, n)
+		}
+	}
+
+	return nil
+}
+
+// matches reports whether rule accepts message from sender address, c is contact with it,
+// empty for unknown sender
+func (rule *Rule) matches(msg *models.IPmsgRequest, sender string, c *alias.Contact) bool {
+	if len(rule.From) > 0 && !slices.Contains(rule.From, sender) &&
+		(c.Name == "" || !slices.Contains(rule.From, c.Name)) {
+		return false
+	}
+
+	if rule.Group != "" && !slices.ContainsFunc(c.Groups, func(g string) bool {
+		return strings.EqualFold(g, rule.Group)
+	}) {
+		return false
+	}
+
+	if len(rule.Priority) > 0 {
+		prio := msg.Priority
+		if prio == models.PriorityNormal {
+			prio = "normal"
+		}
+		if !slices.Contains(rule.Priority, prio) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"ipmsg/pkg/alias"
+	"ipmsg/pkg/models"
+	"testing"
+)
+
+func TestRouterRules(t *testing.T) {
+	rules := []Rule{
+		{From: []string{"boss"}},
+		{From: []string{"192.168.1.7"}},
+		{Priority: []string{"high"}},
+		{From: []string{"alex"}, Priority: []string{"normal"}},
+		{Group: "office"},
+	}
+
+	tests := []struct {
+		name     string
+		msg      models.IPmsgRequest
+		observed string
+		want     int // index of rule which gets notification, -1 for none
+	}{
+		{
+			name: "contact name of sender address",
+			msg:  models.IPmsgRequest{From: "192.168.1.5", Priority: models.PriorityHigh},
+			want: 0,
+		},
+		{
+			name: "address",
+			msg:  models.IPmsgRequest{From: "192.168.1.7"},
+			want: 1,
+		},
+		{
+			name: "claimed alias is not trusted",
+			msg:  models.IPmsgRequest{From: "192.168.1.9", Alias: "boss", Priority: models.PriorityHigh},
+			want: 2,
+		},
+		{
+			name: "normal priority is empty in message",
+			msg:  models.IPmsgRequest{From: "192.168.1.6"},
+			want: 3,
+		},
+		{
+			name: "priority of rule does not match",
+			msg:  models.IPmsgRequest{From: "192.168.1.6", Priority: models.PriorityLow},
+			want: -1,
+		},
+		{
+			name:     "claimed address is not trusted",
+			msg:      models.IPmsgRequest{From: "192.168.1.7"},
+			observed: "192.168.1.9",
+			want:     -1,
+		},
+		{
+			name:     "contact name of observed address",
+			msg:      models.IPmsgRequest{From: "192.168.1.9"},
+			observed: "192.168.1.6",
+			want:     3,
+		},
+		{
+			name: "group of sender contact",
+			msg:  models.IPmsgRequest{From: "192.168.1.8"},
+			want: 4,
+		},
+		{
+			name:     "group of claimed address is not trusted",
+			msg:      models.IPmsgRequest{From: "192.168.1.8"},
+			observed: "192.168.1.9",
+			want:     -1,
+		},
+		{
+			name: "no rule matches",
+			msg:  models.IPmsgRequest{From: "192.168.1.9"},
+			want: -1,
+		},
+	}
+
+	contacts := []alias.Contact{
+		{Name: "boss", Addresses: []string{"192.168.1.5"}},
+		{Name: "alex", Addresses: []string{"192.168.1.6"}},
+		{Name: "bob", Addresses: []string{"192.168.1.8"}, Groups: []string{"Office"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, recs := testRouter(rules)
+			r.Contacts = func() ([]alias.Contact, error) { return contacts, nil }
+
+			if err := r.Notify(context.Background(), FromRequest(&tt.msg, tt.observed, "")); err != nil {
+				t.Fatalf("Notify() error = %v", err)
+			}
+
+			for i, rec := range recs {
+				want := 0
+				if i == tt.want {
+					want = 1
+				}
+				if got := len(rec.Notifications()); got != want {
+					t.Errorf("rule %d got %d notifications, want %d", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRouterContactsError(t *testing.T) {
+	r, recs := testRouter([]Rule{{From: []string{"boss"}}, {Group: "office"}, {}})
+	r.Contacts = func() ([]alias.Contact, error) { return nil, errors.New("broken contacts") }
+
+	msg := models.IPmsgRequest{From: "192.168.1.5", Alias: "boss"}
+	if err := r.Notify(context.Background(), FromRequest(&msg, "", "")); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+
+	if len(recs[0].Notifications()) != 0 || len(recs[1].Notifications()) != 0 || len(recs[2].Notifications()) != 1 {
+		t.Errorf("rule with names or group matched without contacts")
+	}
+}
+
+func TestRouterError(t *testing.T) {
+	want := errors.New("no display")
+	r, recs := testRouter([]Rule{{}})
+	recs[0].Err = want
+
+	msg := models.IPmsgRequest{From: "192.168.1.5", Msg: "hi"}
+	if err := r.Notify(context.Background(), FromRequest(&msg, "", "")); !errors.Is(err, want) {
+		t.Errorf("Notify() error = %v, want %v", err, want)
+	}
+}
+
+// testRouter returns router with recorder for every rule
+func testRouter(rules []Rule) (*Router, []*Recorder) {
+	r := &Router{}
+	recs := make([]*Recorder, len(rules))
+	for i, rule := range rules {
+		recs[i] = &Recorder{}
+		r.rules = append(r.rules, route{rule: rule, notifier: recs[i]})
+	}
+	return r, recs
+}
@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"ipmsg/pkg/models"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// DBus shows desktop notification through org.freedesktop.Notifications
+type DBus struct {
+	conn *dbus.Conn
+
+	mu sync.Mutex
+}
+
+func (d *DBus) Notify(ctx context.Context, n Notification) error {
+	conn, err := d.session()
+	if err != nil {
+		return fmt.Errorf("dbus: %w: %w", ErrUnavailable, err)
+	}
+
+	urgency := byte(1)
+	timeout := int32(-1)
+	switch n.Priority {
+	case models.PriorityLow:
+		urgency = 0
+	case models.PriorityHigh:
+		urgency = 2
+		timeout = 0 // never expires
+	}
+
+	obj := conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+	call := obj.CallWithContext(ctx, "org.freedesktop.Notifications.Notify", 0,
+		"ipmsg",       // app name
+		uint32(0),     // replaces id
+		"mail-unread", // icon
+		n.Title,       // summary
+		n.Body,        // body
+		[]string{},    // actions
+		map[string]dbus.Variant{"urgency": dbus.MakeVariant(urgency)},
+		timeout,
+	)
+	if call.Err != nil {
+		d.reset()
+		return fmt.Errorf("dbus: %w", call.Err)
+	}
+
+	return nil
+}
+
+func (d *DBus) session() (*dbus.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.conn != nil && d.conn.Connected() {
+		return d.conn, nil
+	}
+
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, err
+	}
+
+	d.conn = conn
+	return conn, nil
+}
+
+func (d *DBus) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.conn != nil {
+		d.conn.Close()
+		d.conn = nil
+	}
+}
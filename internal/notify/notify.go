@@ -0,0 +1,108 @@
+// package for notifying user about received messages through pluggable backends
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ipmsg/pkg/models"
+	"strings"
+)
+
+var (
+	ErrUnavailable error = errors.New("notifier is unavailable")
+)
+
+type Notification struct {
+	Title    string
+	Body     string
+	Priority string
+	Observed string // address message came from, rules match sender by it
+	Signer   string // fingerprint of key which signed message, empty if unsigned
+	Message  models.IPmsgRequest
+}
+
+// Sender returns address of message, observed one if it is known, from of message is only claimed
+func (n *Notification) Sender() string {
+	if n.Observed != "" {
+		return n.Observed
+	}
+	return n.Message.From
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// Func allows using plain function as Notifier
+type Func func(ctx context.Context, n Notification) error
+
+func (f Func) Notify(ctx context.Context, n Notification) error {
+	return f(ctx, n)
+}
+
+// FromRequest builds notification for message received from observed address,
+// signer is fingerprint of key which signed it
+func FromRequest(req *models.IPmsgRequest, observed, signer string) Notification {
+	from := observed
+	if from == "" {
+		from = req.From
+	}
+	if req.Alias != "" {
+		from = fmt.Sprintf("%s(%s)", req.Alias, from)
+	}
+
+	body := strings.TrimSpace(req.Msg)
+	if r := []rune(body); len(r) > 200 {
+		body = string(r[:197]) + "..."
+	}
+
+	return Notification{
+		Title:    "ipmsg: " + from,
+		Body:     body,
+		Priority: req.Priority,
+		Observed: observed,
+		Signer:   signer,
+		Message:  *req,
+	}
+}
+
+// Multi notifies through every notifier, errors are joined
+type Multi []Notifier
+
+func (m Multi) Notify(ctx context.Context, n Notification) error {
+	var errs []error
+	for _, nt := range m {
+		if err := nt.Notify(ctx, n); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Fallback notifies through first notifier which succeeds
+type Fallback []Notifier
+
+func (f Fallback) Notify(ctx context.Context, n Notification) error {
+	var errs []error
+	for _, nt := range f {
+		err := nt.Notify(ctx, n)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+
+	if len(errs) == 0 {
+		return ErrUnavailable
+	}
+	return errors.Join(errs...)
+}
+
+// Nop drops notifications
+type Nop struct{}
+
+func (Nop) Notify(context.Context, Notification) error {
+	return nil
+}
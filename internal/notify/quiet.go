@@ -0,0 +1,209 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"ipmsg/pkg/dnd"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPreviews is number of suppressed messages shown in summary, others are only counted
+	maxPreviews = 3
+	// maxSenders is number of senders counted apart, messages of others are counted together
+	maxSenders = 50
+	// summarySenders is number of senders listed in summary, most active first
+	summarySenders = 5
+	othersLabel    = "others"
+)
+
+// Quiet suppresses notifications during do not disturb and quiet hours from dnd file,
+// when dnd ends it sends summary of suppressed messages through next notifier
+type Quiet struct {
+	next     Notifier
+	filePath string
+	log      *slog.Logger
+
+	// Names returns address - contact name map, allowed senders are matched through it. May be nil
+	Names func() (map[string]string, error)
+
+	settings   dnd.Settings
+	modTime    time.Time
+	suppressed Suppressed
+
+	mu sync.Mutex
+}
+
+func NewQuiet(log *slog.Logger, next Notifier, path string) *Quiet {
+	return &Quiet{
+		next:     next,
+		filePath: path,
+		log:      log,
+		settings: dnd.Default(),
+	}
+}
+
+func (q *Quiet) Notify(ctx context.Context, n Notification) error {
+	q.mu.Lock()
+	s := q.current()
+	active, reason := s.Active(time.Now())
+	if !active {
+		q.mu.Unlock()
+		return q.next.Notify(ctx, n)
+	}
+
+	name := q.name(n.Sender())
+	if !s.Allowed(&n.Message, n.Sender(), name) {
+		q.suppressed.Add(&n, name)
+		q.mu.Unlock()
+
+		q.log.Debug("notification suppressed", "reason", reason, "from", n.Sender())
+		return nil
+	}
+	q.mu.Unlock()
+
+	return q.next.Notify(ctx, n)
+}
+
+// Run checks every interval whether dnd ended and sends summary
+func (q *Quiet) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		q.mu.Lock()
+		s := q.current()
+		active, _ := s.Active(time.Now())
+		if active || q.suppressed.Total == 0 {
+			q.mu.Unlock()
+			continue
+		}
+
+		summary := q.suppressed.Summary()
+		q.suppressed = Suppressed{}
+		q.mu.Unlock()
+
+		if err := q.next.Notify(ctx, summary); err != nil {
+			q.log.Warn("failed send do not disturb summary", "err", err)
+		}
+	}
+}
+
+// Suppressed counts notifications which came during dnd by sender, only first few of them
+// are kept for preview, so long dnd does not pile up messages in memory
+type Suppressed struct {
+	Total    int
+	Counts   map[string]int // by contact name or address message came from
+	Previews []string       // sender and text of first messages
+}
+
+// Add counts notification, name is contact name of sender, empty if it is unknown
+func (s *Suppressed) Add(n *Notification, name string) {
+	from := name
+	if from == "" {
+		from = n.Sender()
+	}
+
+	if s.Counts == nil {
+		s.Counts = map[string]int{}
+	}
+	if _, ok := s.Counts[from]; !ok && len(s.Counts) >= maxSenders {
+		from = othersLabel
+	}
+
+	s.Total++
+	s.Counts[from]++
+	if len(s.Previews) < maxPreviews {
+		s.Previews = append(s.Previews, from+": "+n.Body)
+	}
+}
+
+// Summary builds notification about messages which came during dnd
+func (s *Suppressed) Summary() Notification {
+	senders := make([]string, 0, len(s.Counts))
+	for from := range s.Counts {
+		if from != othersLabel {
+			senders = append(senders, from)
+		}
+	}
+	sort.Slice(senders, func(i, j int) bool {
+		if s.Counts[senders[i]] != s.Counts[senders[j]] {
+			return s.Counts[senders[i]] > s.Counts[senders[j]]
+		}
+		return senders[i] < senders[j]
+	})
+
+	others := s.Counts[othersLabel]
+	if len(senders) > summarySenders {
+		for _, from := range senders[summarySenders:] {
+			others += s.Counts[from]
+		}
+		senders = senders[:summarySenders]
+	}
+
+	lines := make([]string, 0, len(senders)+len(s.Previews)+2)
+	for _, from := range senders {
+		lines = append(lines, fmt.Sprintf("%s: %d", from, s.Counts[from]))
+	}
+	if others > 0 {
+		lines = append(lines, fmt.Sprintf("%s: %d", othersLabel, others))
+	}
+	if len(s.Previews) > 0 {
+		lines = append(lines, "")
+		lines = append(lines, s.Previews...)
+	}
+
+	return Notification{
+		Title: fmt.Sprintf("ipmsg: %d messages while you were in do not disturb", s.Total),
+		Body:  strings.Join(lines, "\n"),
+	}
+}
+
+// name returns contact name of address, empty if it is unknown or contacts can not be read
+func (q *Quiet) name(addr string) string {
+	if q.Names == nil {
+		return ""
+	}
+
+	names, err := q.Names()
+	if err != nil {
+		q.log.Warn("failed read contacts for do not disturb", "err", err)
+		return ""
+	}
+	return names[addr]
+}
+
+// current returns settings, rereading file if it changed, q.mu must be held
+func (q *Quiet) current() *dnd.Settings {
+	info, err := os.Stat(q.filePath)
+	if err != nil {
+		q.settings = dnd.Default()
+		q.modTime = time.Time{}
+		return &q.settings
+	}
+
+	if info.ModTime().Equal(q.modTime) {
+		return &q.settings
+	}
+
+	s, err := dnd.Load(q.filePath)
+	if err != nil {
+		q.log.Error("failed load do not disturb settings, keeping previous", "err", err)
+		return &q.settings
+	}
+
+	q.settings = s
+	q.modTime = info.ModTime()
+	return &q.settings
+}
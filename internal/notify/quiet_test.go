@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"ipmsg/pkg/dnd"
+	"ipmsg/pkg/models"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSuppressed(t *testing.T) {
+	var s Suppressed
+
+	alex := FromRequest(&models.IPmsgRequest{From: "192.168.1.6", Alias: "boss", Msg: "hi"}, "", "")
+	for range 10 {
+		s.Add(&alex, "alex")
+	}
+	for i := range 2 * maxSenders {
+		n := FromRequest(&models.IPmsgRequest{From: fmt.Sprintf("10.0.%d.%d", i/250, i%250), Msg: "spam"}, "", "")
+		s.Add(&n, "")
+	}
+
+	if s.Total != 10+2*maxSenders {
+		t.Errorf("Total = %d, want %d", s.Total, 10+2*maxSenders)
+	}
+	if len(s.Counts) != maxSenders+1 {
+		t.Errorf("counted %d senders, want %d", len(s.Counts), maxSenders+1)
+	}
+	if s.Counts["alex"] != 10 || s.Counts["boss"] != 0 {
+		t.Errorf("messages of contact counted as %v", s.Counts)
+	}
+	if s.Counts[othersLabel] != maxSenders+1 {
+		t.Errorf("others = %d, want %d", s.Counts[othersLabel], maxSenders+1)
+	}
+	if len(s.Previews) != maxPreviews || s.Previews[0] != "alex: hi" {
+		t.Errorf("Previews = %q", s.Previews)
+	}
+
+	summary := s.Summary()
+	if !strings.Contains(summary.Title, fmt.Sprint(s.Total)) || summary.Body != "alex: 10\n10.0.0.0: 1\n10.0.0.1: 1\n10.0.0.10: 1\n10.0.0.11: 1\nothers: 96\n\nalex: hi\nalex: hi\nalex: hi" {
+		t.Errorf("Summary() = %q, %q", summary.Title, summary.Body)
+	}
+}
+
+func TestQuietSuppresses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnd.json")
+	if err := dnd.Save(path, dnd.Settings{Until: dnd.Forever, AllowFrom: []string{"alex"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &Recorder{}
+	q := NewQuiet(slog.New(slog.NewTextHandler(io.Discard, nil)), rec, path)
+	q.Names = func() (map[string]string, error) {
+		return map[string]string{"192.168.1.6": "alex"}, nil
+	}
+
+	for _, from := range []string{"192.168.1.5", "192.168.1.6", "192.168.1.5"} {
+		n := FromRequest(&models.IPmsgRequest{From: from, Msg: "hi"}, "", "")
+		if err := q.Notify(context.Background(), n); err != nil {
+			t.Fatalf("Notify() error = %v", err)
+		}
+	}
+
+	if got := len(rec.Notifications()); got != 1 {
+		t.Errorf("got %d notifications, want 1 from allowed sender", got)
+	}
+	if q.suppressed.Total != 2 || q.suppressed.Counts["192.168.1.5"] != 2 {
+		t.Errorf("suppressed = %+v", q.suppressed)
+	}
+}
@@ -0,0 +1,80 @@
+package peers
+
+import (
+	"ipmsg/pkg/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Registry keeps hosts seen in local net (senders, recipients and scan results)
+type Registry struct {
+	peers map[string]models.Peer
+
+	// OnSeen is called for every host marked alive, outside of registry lock. May be nil
+	OnSeen func(host string)
+
+	mu sync.RWMutex
+}
+
+func New() *Registry {
+	return &Registry{
+		peers: map[string]models.Peer{},
+	}
+}
+
+// Seen marks host as alive right now
+func (r *Registry) Seen(host string) {
+	r.mu.Lock()
+	p := r.peers[host]
+	p.Host = host
+	p.LastSeen = time.Now()
+	r.peers[host] = p
+	r.mu.Unlock()
+
+	if r.OnSeen != nil {
+		r.OnSeen(host)
+	}
+}
+
+// SetAlias remembers alias claimed by host
+func (r *Registry) SetAlias(host, alias string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p := r.peers[host]
+	p.Host = host
+	p.Alias = alias
+	r.peers[host] = p
+}
+
+// List returns peers sorted by last seen time, newest first
+func (r *Registry) List() []models.Peer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	res := make([]models.Peer, 0, len(r.peers))
+	for _, p := range r.peers {
+		res = append(res, p)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].LastSeen.After(res[j].LastSeen)
+	})
+
+	return res
+}
+
+// Hosts returns addresses of all known peers
+func (r *Registry) Hosts() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	res := make([]string, 0, len(r.peers))
+	for host := range r.peers {
+		res = append(res, host)
+	}
+
+	sort.Strings(res)
+	return res
+}
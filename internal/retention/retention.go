@@ -0,0 +1,246 @@
+// package for rotating message history into archive and applying retention rules
+package retention
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ipmsg/pkg/alias"
+	"ipmsg/pkg/models"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RotateNone  = "none"
+	RotateMonth = "month" // messages of previous months go to archive
+	RotateSize  = "size"  // oldest messages go to archive when store is bigger than max_size
+
+	ActionArchive = "archive"
+	ActionDelete  = "delete"
+)
+
+// Config is read from retention file, example:
+//
+//	{
+//	  "rotate": "size",
+//	  "max_size": "10MB",
+//	  "rules": [
+//	    {"group": "bots", "days": 7, "action": "delete"},
+//	    {"from": ["alex"], "days": 365, "action": "archive"},
+//	    {"days": 90, "action": "archive"}
+//	  ]
+//	}
+//
+// first rule matching sender decides, rule without from and group matches everyone
+type Config struct {
+	Rotate  string `json:"rotate,omitempty"`
+	MaxSize string `json:"max_size,omitempty"`
+	Rules   []Rule `json:"rules,omitempty"`
+
+	maxSize int64
+}
+
+type Rule struct {
+	From   []string `json:"from,omitempty"` // aliases or addresses
+	Group  string   `json:"group,omitempty"`
+	Days   int      `json:"days"`
+	Action string   `json:"action"` // archive or delete
+
+	peers []string
+}
+
+func DefaultConfig() Config {
+	return Config{Rotate: RotateNone}
+}
+
+// Load reads retention file, missing file means no rotation and no rules
+func Load(path string) (Config, error) {
+	const op = "retention.Load"
+
+	cfg := DefaultConfig()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return cfg, nil
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", op, err)
+	}
+
+	switch cfg.Rotate {
+	case "":
+		cfg.Rotate = RotateNone
+	case RotateNone, RotateMonth:
+	case RotateSize:
+		cfg.maxSize, err = ParseSize(cfg.MaxSize)
+		if err != nil || cfg.maxSize <= 0 {
+			return cfg, fmt.Errorf("%s: invalid max_size %q", op, cfg.MaxSize)
+		}
+	default:
+		return cfg, fmt.Errorf("%s: unknown rotate %q, expected none, month or size", op, cfg.Rotate)
+	}
+
+	for i, r := range cfg.Rules {
+		if r.Days <= 0 {
+			return cfg, fmt.Errorf("%s: rule %d: days must be positive", op, i)
+		}
+		if r.Action != ActionArchive && r.Action != ActionDelete {
+			return cfg, fmt.Errorf("%s: rule %d: unknown action %q", op, i, r.Action)
+		}
+	}
+
+	return cfg, nil
+}
+
+// Resolve expands aliases and contact groups of rules to every address of contacts
+func (c *Config) Resolve(contacts []alias.Contact) error {
+	for i := range c.Rules {
+		r := &c.Rules[i]
+
+		who := append([]string(nil), r.From...)
+		if r.Group != "" {
+			members := alias.Members(contacts, r.Group)
+			if len(members) == 0 {
+				return fmt.Errorf("retention.Resolve: rule %d: unknown group %q", i, r.Group)
+			}
+			who = append(who, members...)
+		}
+
+		r.peers = alias.Expand(contacts, who...)
+	}
+
+	return nil
+}
+
+// Action returns what rules do with message at now, empty if message stays
+func (c *Config) Action(msg *models.Message, now time.Time) string {
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if !r.matches(msg) {
+			continue
+		}
+
+		if time.Unix(msg.Date, 0).Before(now.AddDate(0, 0, -r.Days)) {
+			return r.Action
+		}
+		return ""
+	}
+
+	return ""
+}
+
+// Expired returns func for store rotation which selects messages to delete
+func (c *Config) Expired(now time.Time) func(msg *models.Message) bool {
+	return func(msg *models.Message) bool {
+		return c.Action(msg, now) == ActionDelete
+	}
+}
+
+// Split returns func for store rotation which selects messages to archive
+func (c *Config) Split(now time.Time) func(msgs []models.Message, size int64) (keep, archive []models.Message) {
+	return func(msgs []models.Message, size int64) (keep, archive []models.Message) {
+		var rest []models.Message
+		for _, m := range msgs {
+			if c.Action(&m, now) == ActionArchive {
+				archive = append(archive, m)
+			} else {
+				rest = append(rest, m)
+			}
+		}
+
+		switch c.Rotate {
+		case RotateMonth:
+			month := now.Format("2006-01")
+			for _, m := range rest {
+				if time.Unix(m.Date, 0).Format("2006-01") < month {
+					archive = append(archive, m)
+				} else {
+					keep = append(keep, m)
+				}
+			}
+
+		case RotateSize:
+			if size <= c.maxSize {
+				return rest, archive
+			}
+
+			// size of every message is estimated by its share in store,
+			// oldest messages go to archive until store is half of max size
+			var total int64
+			for _, m := range rest {
+				total += weight(&m)
+			}
+
+			left := total
+			for i, m := range rest {
+				if left*size/max(total, 1) <= c.maxSize/2 {
+					keep = append(keep, rest[i:]...)
+					break
+				}
+				archive = append(archive, m)
+				left -= weight(&m)
+			}
+
+		default:
+			keep = rest
+		}
+
+		return keep, archive
+	}
+}
+
+// ParseSize parses sizes like 512KB, 10MB or 1GB, plain number is bytes
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if n, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, mult = strings.TrimSpace(n), u.mult
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return n * mult, nil
+}
+
+/* ======== internal ======== */
+
+func (r *Rule) matches(msg *models.Message) bool {
+	if len(r.From) == 0 && r.Group == "" {
+		return true
+	}
+
+	for _, p := range r.peers {
+		if msg.Involves(p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// weight is rough size of message in store
+func weight(msg *models.Message) int64 {
+	return int64(len(msg.Msg)) + 100
+}
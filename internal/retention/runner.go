@@ -0,0 +1,94 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"ipmsg/pkg/alias"
+	"ipmsg/pkg/search"
+	"ipmsg/pkg/store"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Runner applies retention file to store periodically, file is reread on every run
+type Runner struct {
+	filePath string
+	log      *slog.Logger
+	store    *store.Archived
+	index    *search.Index
+	alias    *alias.Alias
+
+	mu sync.Mutex
+}
+
+// New returns runner, index may be nil
+func New(log *slog.Logger, path string, st *store.Archived, index *search.Index, al *alias.Alias) *Runner {
+	return &Runner{
+		filePath: path,
+		log:      log,
+		store:    st,
+		index:    index,
+		alias:    al,
+	}
+}
+
+// Run rotates store right away and then every interval until ctx is done
+func (r *Runner) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		res, err := r.Rotate(time.Now())
+		if err != nil {
+			r.log.Error("failed rotate message history", "err", err)
+		} else if res.Archived > 0 || res.Deleted > 0 {
+			r.log.Info("rotated message history", "archived", res.Archived, "deleted", res.Deleted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Rotate applies retention file at now
+func (r *Runner) Rotate(now time.Time) (store.Rotation, error) {
+	const op = "retention.Runner.Rotate"
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := Load(r.filePath)
+	if err != nil {
+		return store.Rotation{}, err
+	}
+	if cfg.Rotate == RotateNone && len(cfg.Rules) == 0 {
+		return store.Rotation{}, nil
+	}
+
+	contacts, err := r.alias.Contacts()
+	if err != nil {
+		return store.Rotation{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := cfg.Resolve(contacts); err != nil {
+		return store.Rotation{}, err
+	}
+
+	res, err := r.store.Rotate(cfg.Split(now), cfg.Expired(now))
+	if r.index != nil && len(res.DeletedIDs) > 0 {
+		r.index.Remove(res.DeletedIDs...)
+	}
+	if err != nil {
+		return res, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return res, nil
+}
+
+// Files returns archive files
+func (r *Runner) Files() ([]store.ArchiveFile, error) {
+	return r.store.Archive().Files()
+}
@@ -0,0 +1,114 @@
+package retrier
+
+import (
+	"context"
+	"errors"
+	"ipmsg/internal/events"
+	"ipmsg/pkg/models"
+	"ipmsg/pkg/outbox"
+	"ipmsg/pkg/sender"
+	"ipmsg/pkg/store"
+	"log/slog"
+	"time"
+)
+
+// Retrier periodically resends outbox items which are due
+type Retrier struct {
+	box      *outbox.Outbox
+	history  store.Store
+	log      *slog.Logger
+	interval time.Duration
+	timeout  time.Duration
+	wake     chan struct{}
+	events   *events.Bus
+}
+
+// New creates retrier, history gets result of every delivered message and bus receives
+// delivery results, both may be nil
+func New(log *slog.Logger, box *outbox.Outbox, history store.Store, interval time.Duration, bus *events.Bus) *Retrier {
+	return &Retrier{
+		box:      box,
+		history:  history,
+		events:   bus,
+		log:      log,
+		interval: interval,
+		timeout:  2 * time.Second,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+func (r *Retrier) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.flush()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// PeerSeen is called when host shows up in net, pending messages for it are sent at once
+func (r *Retrier) PeerSeen(host string) {
+	n, err := r.box.Wake(host)
+	if err != nil {
+		r.log.Error("failed wake outbox items", "host", host, "err", err)
+		return
+	}
+	if n == 0 {
+		return
+	}
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Retrier) flush() {
+	items, err := r.box.Due(time.Now())
+	if err != nil {
+		r.log.Error("failed read outbox", "err", err)
+		return
+	}
+
+	for _, due := range items {
+		// item canceled or sent by cli meanwhile is gone or in flight
+		sent := false
+		it, err := r.box.Deliver(due.ID, func(it *outbox.Item) error {
+			err := sender.Send(it.To, &it.Request, r.timeout)
+			r.events.Delivery(it.To, &it.Request, err)
+			sent = err == nil
+			return err
+		})
+		if err != nil && (sent || (!errors.Is(err, outbox.ErrNotFound) && !errors.Is(err, outbox.ErrSending))) {
+			r.log.Error("failed update outbox item", "id", due.ID, "err", err)
+		}
+		if !sent {
+			continue
+		}
+
+		r.log.Info("delivered message from outbox", "id", it.ID, "to", it.To, "attempts", it.Attempts+1)
+		r.delivered(&it)
+	}
+}
+
+// delivered records in history that message of item got to recipient
+func (r *Retrier) delivered(it *outbox.Item) {
+	if r.history == nil || it.MessageID == "" {
+		return
+	}
+
+	err := r.history.UpdateDelivery(it.MessageID, models.Delivery{To: it.Host(), Delivered: true, Status: models.StatusSent})
+	if errors.Is(err, store.ErrUnsupported) {
+		return
+	}
+	if err != nil {
+		r.log.Warn("failed save delivery to history", "id", it.MessageID, "to", it.To, "err", err)
+	}
+}
@@ -4,43 +4,47 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 
-	"ipmsg/internal/beep"
+	"ipmsg/internal/events"
+	"ipmsg/internal/notify"
+	"ipmsg/pkg/identity"
 	"ipmsg/pkg/models"
-	"ipmsg/pkg/alias"
 	"log/slog"
 	"net"
-	"strings"
 
 	"time"
 )
 
 type MsgSaver interface {
-	SaveToFile(filename string, req *models.IPmsgRequest, alSaver *alias.Alias) error 
+	Save(msg *models.Message) error
 }
 
 type IPMsgServer struct {
 	Addr 		 string
 	Saver 		 MsgSaver
-	SaveFilePath string
 	log    		 *slog.Logger
-	alias        *alias.Alias
+
+	// PeerSeen is called with remote host of every accepted message, may be nil
+	PeerSeen     func(host string, req *models.IPmsgRequest)
+	// Events receives every saved message, may be nil
+	Events       *events.Bus
+	// Notifier tells user about saved messages, may be nil
+	Notifier     notify.Notifier
+	// Contacts returns published contacts for contacts request, nil if sharing is off
+	Contacts     func(req *models.IPmsgRequest, observed string) (string, error)
 }
 
 func New(log *slog.Logger, 
 	saver MsgSaver, 
 	host string, 
 	port uint16, 
-	savePath string,
-	alias *alias.Alias,
 	) *IPMsgServer {
 	return &IPMsgServer{
 		Saver: saver,
 		Addr: fmt.Sprintf("%s:%d", host, port),
-		SaveFilePath: savePath,
 		log: log,
-		alias: alias,
 	}
 }
 
@@ -102,43 +106,85 @@ func (ipServer *IPMsgServer) handleConn(conn net.Conn, ctx context.Context) {
         return
     }
 
-    if err := ipServer.Saver.SaveToFile(ipServer.SaveFilePath, req, ipServer.alias); err != nil {
+    observed, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+    if req.Kind != "" {
+        ipServer.answer(conn, req, observed)
+        return
+    }
+
+    msg := models.NewMessage(req, observed)
+    if signer, err := identity.Verify(req); err == nil {
+        msg.Signer = signer
+    } else if errors.Is(err, identity.ErrBadSignature) {
+        ipServer.log.Warn("message with invalid signature", "from", req.From, "observed", observed)
+    }
+
+    if err := ipServer.Saver.Save(msg); err != nil {
         ipServer.writeError(conn, "failed save message: "+err.Error())
         return
     }
 
-    beep.Beep()
+    if ipServer.Events != nil {
+        ipServer.Events.Publish(models.Event{
+            Kind:     models.EventReceived,
+            Observed: msg.Observed,
+            Signer:   msg.Signer,
+            Flags:    msg.Flags,
+            Message:  *req,
+        })
+    }
+
+    if ipServer.Notifier != nil {
+        go ipServer.notify(notify.FromRequest(req, msg.Observed, msg.Signer))
+    }
 
     writeSuc(conn)
+    // sender reads answer until EOF, so it must not wait for peer bookkeeping below
+    conn.Close()
+
+    if ipServer.PeerSeen != nil && observed != "" {
+        ipServer.PeerSeen(observed, req)
+    }
 }
 
 
 func (ipServer *IPMsgServer) parseRequest(req string) (*models.IPmsgRequest, error) {
-	var res models.IPmsgRequest
+	return models.ParseRequest(req)
+}
 
-	parts := strings.SplitN(req, "\nmsg:", 2)
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid request format")
-	}
 
-	header := parts[0]
-	res.Msg = parts[1]
-
-	_, err := fmt.Sscanf(
-		header,
-		"ipmsg\nfrom:%s\nlen:%d\ndate:%d\nalias:%s",
-		&res.From,
-		&res.Len,
-		&res.Date,
-		&res.Alias,
-	)
-	if err != nil {
-		return nil, err
-	}
+// answer replies to requests other than message
+func (ipServer *IPMsgServer) answer(conn net.Conn, req *models.IPmsgRequest, observed string) {
+	switch req.Kind {
+	case models.KindContacts:
+		if ipServer.Contacts == nil {
+			ipServer.writeError(conn, "contact sharing is disabled")
+			return
+		}
+
+		data, err := ipServer.Contacts(req, observed)
+		if err != nil {
+			ipServer.writeError(conn, "failed read contacts: "+err.Error())
+			return
+		}
+
+		r := models.IPResponse{Succes: true, Data: data}
+		conn.Write([]byte(r.DecodeToString()))
 
-	return &res, nil
+	default:
+		ipServer.writeError(conn, "unknown request kind "+req.Kind)
+	}
 }
 
+func (ipServer *IPMsgServer) notify(n notify.Notification) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	if err := ipServer.Notifier.Notify(ctx, n); err != nil {
+		ipServer.log.Warn("failed notify about message", "from", n.Sender(), "err", err)
+	}
+}
 
 func writeSuc(conn net.Conn)  {
 	r := models.IPResponse{Succes: true}
@@ -0,0 +1,230 @@
+"use strict";
+
+const ALL = "*";
+
+const state = {
+	messages: [],    // {peer, from, alias, date, msg, out, status}
+	names: {},       // address -> alias
+	peers: [],       // known hosts
+	current: ALL,
+	unread: {},
+};
+
+const $ = (id) => document.getElementById(id);
+
+async function api(method, path, body) {
+	const opts = { method, headers: {} };
+	if (body !== undefined) {
+		opts.headers["Content-Type"] = "application/json";
+		opts.body = JSON.stringify(body);
+	}
+
+	const resp = await fetch(path, opts);
+	if (!resp.ok) {
+		let msg = resp.statusText;
+		try { msg = (await resp.json()).error || msg; } catch (e) {}
+		throw new Error(msg);
+	}
+	return resp.status === 204 ? null : resp.json();
+}
+
+function hostOf(addr) {
+	const i = addr.lastIndexOf(":");
+	return i > 0 && addr.indexOf(".") > 0 ? addr.slice(0, i) : addr;
+}
+
+function label(host) {
+	return state.names[host] ? `${state.names[host]} (${host})` : host;
+}
+
+function addMessage(m) {
+	state.messages.push(m);
+	if (m.peer !== state.current && state.current !== ALL && !m.out) {
+		state.unread[m.peer] = (state.unread[m.peer] || 0) + 1;
+	}
+	if (!state.peers.includes(m.peer)) {
+		state.peers.push(m.peer);
+	}
+}
+
+/* -------- rendering -------- */
+
+function renderConversations() {
+	const list = $("conversations");
+	list.replaceChildren();
+
+	const peers = [ALL, ...state.peers];
+	for (const peer of peers) {
+		const li = document.createElement("li");
+		li.textContent = peer === ALL ? "All messages" : label(peer);
+		li.className = peer === state.current ? "active" : "";
+		li.onclick = () => select(peer);
+
+		if (state.unread[peer]) {
+			const badge = document.createElement("span");
+			badge.className = "unread";
+			badge.textContent = state.unread[peer];
+			li.append(badge);
+		}
+		list.append(li);
+	}
+
+	const recipient = $("recipient");
+	const selected = recipient.value || state.current;
+	recipient.replaceChildren();
+	for (const peer of peers) {
+		const opt = document.createElement("option");
+		opt.value = peer;
+		opt.textContent = peer === ALL ? "Everyone" : label(peer);
+		recipient.append(opt);
+	}
+	recipient.value = peers.includes(selected) ? selected : ALL;
+}
+
+function renderMessages() {
+	const box = $("messages");
+	box.replaceChildren();
+
+	for (const m of state.messages) {
+		if (state.current !== ALL && m.peer !== state.current) {
+			continue;
+		}
+
+		const div = document.createElement("div");
+		div.className = "msg" + (m.out ? " out" : "") + (m.status === "failed" ? " failed" : "");
+
+		const meta = document.createElement("div");
+		meta.className = "meta";
+		const when = new Date(m.date * 1000).toLocaleString();
+		const who = m.out ? "to " + label(m.peer) : (m.alias ? `${m.alias} (${m.from})` : m.from);
+		meta.textContent = `${when} - ${who}` + (m.status ? ` - ${m.status}` : "");
+
+		const body = document.createElement("div");
+		body.className = "body";
+		body.textContent = m.msg;
+
+		div.append(meta, body);
+		box.append(div);
+	}
+
+	box.scrollTop = box.scrollHeight;
+}
+
+function render() {
+	$("title").textContent = state.current === ALL ? "All messages" : label(state.current);
+	renderConversations();
+	renderMessages();
+}
+
+function select(peer) {
+	state.current = peer;
+	delete state.unread[peer];
+	$("recipient").value = peer;
+	render();
+}
+
+/* -------- data -------- */
+
+async function load() {
+	const [history, peers, aliases] = await Promise.all([
+		api("GET", "history"),
+		api("GET", "peers"),
+		api("GET", "aliases"),
+	]);
+
+	for (const a of aliases) {
+		state.names[a.address] = a.name;
+	}
+	for (const p of peers) {
+		if (!state.peers.includes(p.host)) {
+			state.peers.push(p.host);
+		}
+	}
+	for (const h of history) {
+		if (h.direction === "out") {
+			// sent message is shown in conversation of every recipient, broadcast skips hosts which were not there
+			const deliveries = h.deliveries || [];
+			for (const d of deliveries) {
+				if (deliveries.length > 1 && d.status === "failed") {
+					continue;
+				}
+				const status = d.status === "sent" ? "delivered" : d.status;
+				addMessage({ peer: d.to, from: h.from, date: h.date, msg: h.msg, out: true, status: status });
+			}
+			continue;
+		}
+		addMessage({ peer: h.from, from: h.from, alias: h.alias, date: h.date, msg: h.msg, out: false });
+	}
+	render();
+}
+
+function onEvent(ev) {
+	const m = ev.message;
+
+	switch (ev.kind) {
+	case "received":
+		addMessage({ peer: m.from, from: m.from, alias: m.alias, date: m.date, msg: m.msg, out: false });
+		if (m.alias) {
+			state.names[m.from] = state.names[m.from] || m.alias;
+		}
+		break;
+	case "sent":
+		addMessage({ peer: hostOf(ev.to), from: m.from, date: m.date, msg: m.msg, out: true, status: "sending" });
+		break;
+	case "acked":
+	case "failed":
+		for (let i = state.messages.length - 1; i >= 0; i--) {
+			const o = state.messages[i];
+			if (o.out && o.peer === hostOf(ev.to) && o.date === m.date && o.msg === m.msg) {
+				o.status = ev.kind === "acked" ? "delivered" : "failed";
+				break;
+			}
+		}
+		break;
+	default:
+		return;
+	}
+	render();
+}
+
+function listen() {
+	const source = new EventSource("events");
+	const status = $("status");
+
+	source.onopen = () => status.className = "";
+	source.onerror = () => status.className = "offline";
+
+	for (const kind of ["received", "sent", "acked", "failed"]) {
+		source.addEventListener(kind, (e) => onEvent(JSON.parse(e.data)));
+	}
+}
+
+async function send(e) {
+	e.preventDefault();
+
+	const text = $("text").value;
+	const to = $("recipient").value;
+	if (!text.trim()) {
+		return;
+	}
+
+	try {
+		if (to === ALL) {
+			await api("POST", "broadcast", { msg: text });
+		} else {
+			await api("POST", "send", { to, msg: text });
+		}
+		$("text").value = "";
+	} catch (err) {
+		alert("failed send message: " + err.message);
+	}
+}
+
+$("compose").addEventListener("submit", send);
+$("text").addEventListener("keydown", (e) => {
+	if (e.key === "Enter" && (e.ctrlKey || e.metaKey)) {
+		send(e);
+	}
+});
+
+load().catch((err) => alert("failed load messages: " + err.message)).finally(listen);
@@ -0,0 +1,21 @@
+// package with browser chat ui served by daemon next to local api
+package webui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves ui files, it is registered on api router so ui and api share origin
+func Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err) // static dir is embedded at build time
+	}
+
+	return http.FileServer(http.FS(files))
+}
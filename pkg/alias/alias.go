@@ -1,81 +1,449 @@
+// package for address book of contacts, book is kept in json file:
+//
+//	{"contacts": [{"name": "Alex Smith", "addresses": ["192.168.1.5", "alex-pc.lan"], "port": 2425}]}
+//
+// first address of contact is current one, others are kept so messages from them are still
+// shown with contact name. Groups are kept in contacts too. Legacy alias file with <address> <alias>
+// lines and legacy groups file are migrated on first use
 package alias
-// package for saving aliases in file in format <address> <alias> (example: 192.168.1.1 alex)
 
 import (
-	"bufio"
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"ipmsg/pkg/filelock"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
+const (
+	FileName       = "contacts.json"
+	LegacyFileName = "alias.txt"
+)
+
+var (
+	ErrInvalidFormat  error = errors.New("invalid file format")
+	ErrNotFound       error = errors.New("alias not found")
+	ErrInvalidContact error = errors.New("new contact must have name and address")
+	ErrConflict       error = errors.New("name or address belongs to another contact")
+	ErrInvalidPolicy  error = errors.New("claim policy must be first, prompt or signed")
+)
+
+type Contact struct {
+	Name        string   `json:"name"`
+	Addresses   []string `json:"addresses"` // ips or hostnames, first is current
+	Notes       string   `json:"notes,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	Fingerprint string   `json:"fingerprint,omitempty"` // fingerprint of contact key
+	Port        int      `json:"port,omitempty"`        // preferred port, 0 means default one
+	Moves       []Move   `json:"moves,omitempty"`       // changes of current address, oldest first
+}
+
+// Move is change of contact's current address
+type Move struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	At     time.Time `json:"at"`
+	Signer string    `json:"signer,omitempty"` // fingerprint of key which signed claim
+}
+
+type Book struct {
+	Contacts []Contact `json:"contacts"`
+	Pending  []Pending `json:"pending,omitempty"` // name claims waiting for approval
+}
 
 type Alias struct {
 	filePath string
+	policy   string
 }
 
+// New returns book kept in path, legacy alias.txt beside it is migrated while book is empty
 func New(path string) *Alias {
 	return &Alias{
 		filePath: path,
+		policy:   PolicyFirst,
 	}
-} 
+}
 
-var (
-	ErrInvalidFormat error = errors.New("invalid file format")
-)
+// Address returns current address of contact
+func (c *Contact) Address() string {
+	if len(c.Addresses) == 0 {
+		return ""
+	}
+	return c.Addresses[0]
+}
 
-func (a *Alias) GetNames() (map[string]string, error) {
-	res := map[string]string{} // ip - name
+// Has reports whether name or address is one of contact's
+func (c *Contact) Has(who string) bool {
+	if strings.EqualFold(c.Name, who) {
+		return true
+	}
+	for _, a := range c.Addresses {
+		if strings.EqualFold(a, who) {
+			return true
+		}
+	}
+	return false
+}
 
-	file, err := os.OpenFile(a.filePath, os.O_CREATE | os.O_RDONLY, 0644)
+// Contacts returns all contacts in file order
+func (a *Alias) Contacts() ([]Contact, error) {
+	b, err := a.read()
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanLines)
+	return b.Contacts, nil
+}
 
-	for scanner.Scan() {
-		text := scanner.Text()
+// Get returns contact with name or address, case is ignored
+func (a *Alias) Get(who string) (Contact, error) {
+	b, err := a.read()
+	if err != nil {
+		return Contact{}, err
+	}
 
-		parts := strings.Split(text, " ")
+	i := b.find(who)
+	if i < 0 {
+		return Contact{}, ErrNotFound
+	}
+
+	return b.Contacts[i], nil
+}
+
+// GetNames returns contact name of every address
+func (a *Alias) GetNames() (map[string]string, error) {
+	b, err := a.read()
+	if err != nil {
+		return nil, err
+	}
 
-		if len(parts) != 2 {
-			return nil, ErrInvalidFormat
+	res := map[string]string{}
+	for _, c := range b.Contacts {
+		for _, addr := range c.Addresses {
+			res[addr] = c.Name
 		}
+	}
 
-		res[parts[0]] = parts[1]
-		res[parts[1]] = parts[0]
+	return res, nil
+}
+
+// GetAddresses returns current address of every contact name
+func (a *Alias) GetAddresses() (map[string]string, error) {
+	b, err := a.read()
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string]string{}
+	for _, c := range b.Contacts {
+		if addr := c.Address(); addr != "" {
+			res[c.Name] = addr
+		}
 	}
 
 	return res, nil
 }
 
+// Expand returns who with every address of contacts named in who and contact name of every
+// address in who, so messages saved under any of them are found
+func (a *Alias) Expand(who ...string) ([]string, error) {
+	b, err := a.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return Expand(b.Contacts, who...), nil
+}
+
+// Expand does the same as Alias.Expand over contacts already read
+func Expand(contacts []Contact, who ...string) []string {
+	var res []string
+	for _, w := range who {
+		res = append(res, w)
+		for _, c := range contacts {
+			switch {
+			case strings.EqualFold(c.Name, w):
+				res = append(res, c.Addresses...)
+			case c.Has(w):
+				res = append(res, c.Name)
+			}
+		}
+	}
+	return res
+}
+
+// Owner returns contact with address, names are not matched, so claimed name never gives contact
+func Owner(contacts []Contact, address string) (Contact, bool) {
+	for _, c := range contacts {
+		if containsFold(c.Addresses, address) {
+			return c, true
+		}
+	}
+	return Contact{}, false
+}
+
+// AddName adds contact with single address, nothing is changed if name or address is known
 func (a *Alias) AddName(name string, address string) error {
+	return a.update(func(b *Book) error {
+		if b.find(name) >= 0 || b.find(address) >= 0 {
+			return nil
+		}
+
+		return b.put(Contact{Name: name, Addresses: []string{address}})
+	})
+}
+
+// Put adds contact or replaces contact with the same name
+func (a *Alias) Put(c Contact) error {
+	return a.update(func(b *Book) error {
+		return b.put(c)
+	})
+}
+
+// Update changes contact with name or address in fn, changed contact is validated as in Put
+func (a *Alias) Update(who string, fn func(c *Contact)) (Contact, error) {
+	var res Contact
+	err := a.update(func(b *Book) error {
+		i := b.find(who)
+		if i < 0 {
+			return ErrNotFound
+		}
+
+		c := b.Contacts[i]
+		c.Addresses = append([]string(nil), c.Addresses...)
+		c.Groups = append([]string(nil), c.Groups...)
+		fn(&c)
+
+		var err error
+		res, err = b.set(i, c)
+		return err
+	})
+
+	return res, err
+}
+
+// Rename gives contact with name or address new name
+func (a *Alias) Rename(who, name string) error {
+	_, err := a.Update(who, func(c *Contact) {
+		c.Name = name
+	})
+	return err
+}
+
+// Remove deletes contact with name or address
+func (a *Alias) Remove(name string) error {
+	return a.update(func(b *Book) error {
+		i := b.find(name)
+		if i < 0 {
+			return ErrNotFound
+		}
+
+		b.Contacts = append(b.Contacts[:i], b.Contacts[i+1:]...)
+		return nil
+	})
+}
+
+/* ======== internal ======== */
 
-	aliases, err := a.GetNames()
+// remove returns values without v
+func remove(values []string, v string) []string {
+	res := values[:0:0]
+	for _, x := range values {
+		if !strings.EqualFold(x, v) {
+			res = append(res, x)
+		}
+	}
+	return res
+}
+
+// find returns index of contact with name or address, -1 if there is none
+func (b *Book) find(who string) int {
+	for i := range b.Contacts {
+		if b.Contacts[i].Has(who) {
+			return i
+		}
+	}
+	return -1
+}
+
+// put replaces contact with the same name or appends new one
+func (b *Book) put(c Contact) error {
+	i := len(b.Contacts)
+	for j := range b.Contacts {
+		if strings.EqualFold(b.Contacts[j].Name, strings.TrimSpace(c.Name)) {
+			i = j
+			break
+		}
+	}
+
+	_, err := b.set(i, c)
+	return err
+}
+
+// set validates contact and puts it at index i, i equal to number of contacts appends it,
+// only new contact must have address
+func (b *Book) set(i int, c Contact) (Contact, error) {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Addresses = clean(c.Addresses)
+	c.Groups = clean(c.Groups)
+	if c.Name == "" || (i == len(b.Contacts) && len(c.Addresses) == 0) {
+		return Contact{}, ErrInvalidContact
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return Contact{}, fmt.Errorf("invalid port %d", c.Port)
+	}
+
+	for j := range b.Contacts {
+		if j == i {
+			continue
+		}
+		for _, who := range append([]string{c.Name}, c.Addresses...) {
+			if b.Contacts[j].Has(who) {
+				return Contact{}, fmt.Errorf("%w: %s is %s", ErrConflict, who, b.Contacts[j].Name)
+			}
+		}
+	}
+
+	if i == len(b.Contacts) {
+		b.Contacts = append(b.Contacts, c)
+	} else {
+		b.Contacts[i] = c
+	}
+
+	return c, nil
+}
+
+// clean trims values and drops empty and repeated ones
+func clean(values []string) []string {
+	var res []string
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		dup := false
+		for _, r := range res {
+			if strings.EqualFold(r, v) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func (a *Alias) read() (Book, error) {
+	const op = "alias.read"
+
+	unlock, err := filelock.For(a.filePath).Lock()
 	if err != nil {
+		return Book{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	b, err := a.load()
+	if err != nil {
+		return Book{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return b, nil
+}
+
+func (a *Alias) update(fn func(b *Book) error) error {
+	const op = "alias.update"
+
+	unlock, err := filelock.For(a.filePath).Lock()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	b, err := a.load()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := fn(&b); err != nil {
 		return err
 	}
 
-	if _, ex := aliases[name]; ex {
-		return nil // skip if alias already exists
+	if err := a.save(b); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// load reads book, legacy files are migrated first, file lock must be held
+func (a *Alias) load() (Book, error) {
+	b, err := a.loadBook()
+	if err != nil {
+		return Book{}, err
+	}
+
+	return a.migrateGroups(b)
+}
+
+// loadBook reads book, legacy alias file is migrated first, file lock must be held
+func (a *Alias) loadBook() (Book, error) {
+	data, err := os.ReadFile(a.filePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Book{}, err
+	}
+
+	var b Book
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		// saved book is never empty, so this is new file
+		return a.migrate(filepath.Join(filepath.Dir(a.filePath), LegacyFileName))
+	}
+	if trimmed[0] != '{' {
+		// book path points to legacy file itself
+		return a.migrate(a.filePath)
+	}
+	if err := json.Unmarshal(data, &b); err != nil {
+		return Book{}, fmt.Errorf("%w: %s", ErrInvalidFormat, err)
+	}
+
+	return b, nil
+}
+
+// save writes book to temp file and renames it over book file
+func (a *Alias) save(b Book) error {
+	if b.Contacts == nil {
+		b.Contacts = []Contact{}
 	}
 
-	file, err := os.OpenFile(a.filePath, os.O_CREATE | os.O_WRONLY | os.O_APPEND, 0644)
+	data, err := json.MarshalIndent(b, "", "  ")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	if _, err := file.WriteString(fmt.Sprintf("%s %s\n", address, name)); err != nil {
+	if err := os.MkdirAll(filepath.Dir(a.filePath), 0755); err != nil {
 		return err
 	}
 
-	if _, err := file.WriteString(fmt.Sprintf("%s %s\n", name, address)); err != nil {
+	tmp, err := os.CreateTemp(filepath.Dir(a.filePath), ".contacts-*")
+	if err != nil {
 		return err
 	}
-	
-	return nil
-}
\ No newline at end of file
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), a.filePath)
+}
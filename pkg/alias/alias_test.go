@@ -0,0 +1,54 @@
+package alias
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestNamesAndAddresses(t *testing.T) {
+	a := testAlias(t, PolicyFirst,
+		Contact{Name: "alex", Addresses: []string{"192.168.1.5", "alex-pc.lan"}},
+		Contact{Name: "bob", Addresses: []string{"192.168.1.6"}},
+	)
+
+	names, err := a.GetNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantNames := map[string]string{"192.168.1.5": "alex", "alex-pc.lan": "alex", "192.168.1.6": "bob"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("GetNames() = %v, want %v", names, wantNames)
+	}
+
+	addresses, err := a.GetAddresses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantAddresses := map[string]string{"alex": "192.168.1.5", "bob": "192.168.1.6"}
+	if !reflect.DeepEqual(addresses, wantAddresses) {
+		t.Errorf("GetAddresses() = %v, want %v", addresses, wantAddresses)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	contacts := []Contact{
+		{Name: "alex", Addresses: []string{"192.168.1.5", "alex-pc.lan"}},
+		{Name: "bob", Addresses: []string{"192.168.1.6"}},
+	}
+
+	tests := []struct {
+		who  []string
+		want []string
+	}{
+		{[]string{"Alex"}, []string{"Alex", "192.168.1.5", "alex-pc.lan"}},
+		{[]string{"alex-pc.lan"}, []string{"alex-pc.lan", "alex"}},
+		{[]string{"bob", "192.168.1.9"}, []string{"bob", "192.168.1.6", "192.168.1.9"}},
+	}
+
+	for _, tt := range tests {
+		if got := Expand(contacts, tt.who...); !slices.Equal(got, tt.want) {
+			t.Errorf("Expand(%v) = %v, want %v", tt.who, got, tt.want)
+		}
+	}
+}
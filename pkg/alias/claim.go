@@ -0,0 +1,315 @@
+package alias
+
+import (
+	"ipmsg/pkg/models"
+	"strings"
+	"time"
+)
+
+// policies of name claims sent by peers
+const (
+	PolicyFirst  = "first"  // first claim of unknown name and address is accepted
+	PolicyPrompt = "prompt" // every claim waits for approval
+	PolicySigned = "signed" // only signed claims of unknown names are accepted
+)
+
+// reasons of pending claims
+const (
+	ReasonNew   = "new"   // unknown name, held by policy
+	ReasonTaken = "taken" // address belongs to other contact
+	ReasonMoved = "moved" // known contact from new address without its key
+)
+
+const (
+	// maxMoves is number of address changes kept for every contact
+	maxMoves = 20
+	// maxPending is number of claims waiting for approval, oldest are dropped first
+	maxPending = 100
+	// maxPendingPerAddress is number of waiting claims of one address, so one peer
+	// sending new names all the time pushes out only its own claims
+	maxPendingPerAddress = 5
+	// maxRejected is number of rejected claims kept, they do not count to maxPending,
+	// so flood of new claims never brings rejected ones back
+	maxRejected = 1000
+)
+
+// Pending is name claim waiting for approval, rejected claims are kept so they do not come back
+type Pending struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Address  string    `json:"address"`
+	Signer   string    `json:"signer,omitempty"`
+	Reason   string    `json:"reason"`
+	Owner    string    `json:"owner,omitempty"` // contact which has address or name now
+	At       time.Time `json:"at"`              // last time claim came
+	Rejected bool      `json:"rejected,omitempty"`
+}
+
+// Claimed tells what claim changed, at most one field is set
+type Claimed struct {
+	Added   *Contact `json:"added,omitempty"`
+	Move    *Move    `json:"move,omitempty"`
+	Pending *Pending `json:"pending,omitempty"`
+	Pinned  *Contact `json:"pinned,omitempty"` // contact without key got key of signer
+}
+
+// SetPolicy sets policy of claims
+func (a *Alias) SetPolicy(policy string) error {
+	switch policy {
+	case PolicyFirst, PolicyPrompt, PolicySigned:
+		a.policy = policy
+		return nil
+	}
+	return ErrInvalidPolicy
+}
+
+// Claim handles name sent by message from address, signer is fingerprint of key which signed
+// message, empty if signature can not be trusted. Depending on policy unknown name becomes
+// contact pinned to signer and known contact moves to address if signer is its key, anything
+// else, including names and addresses of other contacts, waits for approval. Known contact
+// without key is pinned to signer of first message from its current address
+func (a *Alias) Claim(name, address, signer string) (Claimed, error) {
+	var res Claimed
+	err := a.update(func(b *Book) error {
+		i, j := b.find(name), b.find(address)
+
+		switch {
+		case i < 0 && j < 0:
+			if a.policy == PolicyPrompt || (a.policy == PolicySigned && signer == "") {
+				res.Pending = b.hold(name, address, signer, ReasonNew, "")
+				return nil
+			}
+
+			c := Contact{Name: name, Addresses: []string{address}, Fingerprint: signer}
+			if err := b.put(c); err != nil {
+				return err
+			}
+			res.Added = &c
+
+		case i < 0:
+			owner := b.Contacts[j]
+			if signer != "" && owner.Fingerprint == signer {
+				return nil // same peer under other name, user named it
+			}
+			res.Pending = b.hold(name, address, signer, ReasonTaken, owner.Name)
+
+		default:
+			c := b.Contacts[i]
+			if strings.EqualFold(c.Address(), address) {
+				if c.Fingerprint == "" && signer != "" {
+					b.Contacts[i].Fingerprint = signer
+					pinned := b.Contacts[i]
+					res.Pinned = &pinned
+				}
+				return nil
+			}
+
+			verified := signer != "" && c.Fingerprint == signer
+			switch {
+			case verified && a.policy != PolicyPrompt:
+				res.Move = b.move(i, address, signer)
+				b.resolve(name, address)
+			case c.Has(address) && (j == i || j < 0):
+				// one of known addresses, but it does not prove it is current one
+			default:
+				owner := c.Name
+				if j >= 0 && j != i {
+					owner = b.Contacts[j].Name
+				}
+				res.Pending = b.hold(name, address, signer, ReasonMoved, owner)
+			}
+		}
+
+		return nil
+	})
+
+	return res, err
+}
+
+// Claims returns claims waiting for approval, oldest first
+func (a *Alias) Claims() ([]Pending, error) {
+	b, err := a.read()
+	if err != nil {
+		return nil, err
+	}
+
+	res := []Pending{}
+	for _, p := range b.Pending {
+		if !p.Rejected {
+			res = append(res, p)
+		}
+	}
+
+	return res, nil
+}
+
+// Approve applies claim with id: address becomes current address of claimed name and other
+// contacts lose it, key which signed claim becomes contact key
+func (a *Alias) Approve(id string) (Claimed, error) {
+	var res Claimed
+	err := a.update(func(b *Book) error {
+		k := b.pending(id)
+		if k < 0 {
+			return ErrNotFound
+		}
+		p := b.Pending[k]
+		b.Pending = append(b.Pending[:k], b.Pending[k+1:]...)
+
+		i := b.find(p.Name)
+		if i < 0 {
+			for j := range b.Contacts {
+				b.Contacts[j].Addresses = remove(b.Contacts[j].Addresses, p.Address)
+			}
+			c := Contact{Name: p.Name, Addresses: []string{p.Address}, Fingerprint: p.Signer}
+			if err := b.put(c); err != nil {
+				return err
+			}
+			res.Added = &c
+			return nil
+		}
+
+		if p.Signer != "" {
+			b.Contacts[i].Fingerprint = p.Signer
+		}
+		if !strings.EqualFold(b.Contacts[i].Address(), p.Address) {
+			res.Move = b.move(i, p.Address, p.Signer)
+		}
+		return nil
+	})
+
+	return res, err
+}
+
+// Reject drops claim with id, the same claim is ignored from now on
+func (a *Alias) Reject(id string) error {
+	return a.update(func(b *Book) error {
+		k := b.pending(id)
+		if k < 0 {
+			return ErrNotFound
+		}
+
+		b.Pending[k].Rejected = true
+		b.trimRejected()
+		return nil
+	})
+}
+
+/* ======== internal ======== */
+
+// move makes address current one of contact i, other contacts lose it,
+// contact left without addresses is kept until user gives it new one
+func (b *Book) move(i int, address, signer string) *Move {
+	for j := range b.Contacts {
+		if j != i {
+			b.Contacts[j].Addresses = remove(b.Contacts[j].Addresses, address)
+		}
+	}
+
+	c := &b.Contacts[i]
+	m := &Move{From: c.Address(), To: address, At: time.Now(), Signer: signer}
+	c.Addresses = append([]string{address}, remove(c.Addresses, address)...)
+	c.Moves = append(c.Moves, *m)
+	if len(c.Moves) > maxMoves {
+		c.Moves = c.Moves[len(c.Moves)-maxMoves:]
+	}
+
+	return m
+}
+
+// hold saves claim for approval, repeated claim only refreshes time, rejected one is ignored.
+// Returned claim is nil if it is not new
+func (b *Book) hold(name, address, signer, reason, owner string) *Pending {
+	for k := range b.Pending {
+		p := &b.Pending[k]
+		if strings.EqualFold(p.Name, name) && strings.EqualFold(p.Address, address) {
+			if !p.Rejected {
+				p.At, p.Signer, p.Reason, p.Owner = time.Now(), signer, reason, owner
+			}
+			return nil
+		}
+	}
+
+	p := Pending{
+		ID:      models.NewID(),
+		Name:    name,
+		Address: address,
+		Signer:  signer,
+		Reason:  reason,
+		Owner:   owner,
+		At:      time.Now(),
+	}
+	b.Pending = append(b.Pending, p)
+	b.trim(address)
+
+	return &p
+}
+
+// trim drops oldest waiting claims of address over maxPendingPerAddress and then oldest
+// waiting claims over maxPending, rejected claims are kept
+func (b *Book) trim(address string) {
+	open, ofAddress := 0, 0
+	for _, p := range b.Pending {
+		if !p.Rejected {
+			open++
+			if strings.EqualFold(p.Address, address) {
+				ofAddress++
+			}
+		}
+	}
+
+	kept := b.Pending[:0]
+	for _, p := range b.Pending {
+		own := strings.EqualFold(p.Address, address)
+		switch {
+		case p.Rejected:
+		case own && ofAddress > maxPendingPerAddress, open > maxPending:
+			open--
+			if own {
+				ofAddress--
+			}
+			continue
+		}
+		kept = append(kept, p)
+	}
+	b.Pending = kept
+}
+
+// trimRejected drops oldest rejected claims over maxRejected
+func (b *Book) trimRejected() {
+	rejected := 0
+	for _, p := range b.Pending {
+		if p.Rejected {
+			rejected++
+		}
+	}
+
+	kept := b.Pending[:0]
+	for _, p := range b.Pending {
+		if p.Rejected && rejected > maxRejected {
+			rejected--
+			continue
+		}
+		kept = append(kept, p)
+	}
+	b.Pending = kept
+}
+
+// resolve drops claims of name and address which are applied already
+func (b *Book) resolve(name, address string) {
+	kept := b.Pending[:0]
+	for _, p := range b.Pending {
+		if p.Rejected || !strings.EqualFold(p.Name, name) || !strings.EqualFold(p.Address, address) {
+			kept = append(kept, p)
+		}
+	}
+	b.Pending = kept
+}
+
+func (b *Book) pending(id string) int {
+	for k := range b.Pending {
+		if b.Pending[k].ID == id && !b.Pending[k].Rejected {
+			return k
+		}
+	}
+	return -1
+}
@@ -0,0 +1,164 @@
+package alias
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestClaimPolicy(t *testing.T) {
+	const (
+		addr    = "192.168.1.5"
+		newAddr = "192.168.1.9"
+		key     = "SHA256:alex"
+		other   = "SHA256:other"
+	)
+
+	alex := Contact{Name: "alex", Addresses: []string{addr}, Fingerprint: key}
+	bob := Contact{Name: "bob", Addresses: []string{newAddr}}
+
+	tests := []struct {
+		name     string
+		policy   string
+		contacts []Contact
+		claim    [3]string // name, address, signer
+		want     string    // added, move, pinned, pending reason or empty for no change
+	}{
+		{"first accepts unknown", PolicyFirst, nil, [3]string{"alex", addr, ""}, "added"},
+		{"prompt holds unknown", PolicyPrompt, nil, [3]string{"alex", addr, key}, ReasonNew},
+		{"signed holds unsigned unknown", PolicySigned, nil, [3]string{"alex", addr, ""}, ReasonNew},
+		{"signed accepts signed unknown", PolicySigned, nil, [3]string{"alex", addr, key}, "added"},
+		{"same contact and address", PolicyFirst, []Contact{alex}, [3]string{"alex", addr, other}, ""},
+		{"address of other contact", PolicyFirst, []Contact{bob}, [3]string{"alex", newAddr, key}, ReasonTaken},
+		{"contact moves with its key", PolicyFirst, []Contact{alex}, [3]string{"alex", newAddr, key}, "move"},
+		{"prompt holds move with key", PolicyPrompt, []Contact{alex}, [3]string{"alex", newAddr, key}, ReasonMoved},
+		{"move without key", PolicyFirst, []Contact{alex}, [3]string{"alex", newAddr, ""}, ReasonMoved},
+		{"move with other key", PolicySigned, []Contact{alex}, [3]string{"alex", newAddr, other}, ReasonMoved},
+		{"move to address of other contact", PolicyFirst, []Contact{alex, bob}, [3]string{"alex", newAddr, ""}, ReasonMoved},
+		{"contact without key is pinned", PolicyFirst, []Contact{{Name: "alex", Addresses: []string{addr}}}, [3]string{"alex", addr, key}, "pinned"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testAlias(t, tt.policy, tt.contacts...)
+
+			res, err := a.Claim(tt.claim[0], tt.claim[1], tt.claim[2])
+			if err != nil {
+				t.Fatalf("Claim() error = %v", err)
+			}
+
+			got := ""
+			switch {
+			case res.Added != nil:
+				got = "added"
+			case res.Move != nil:
+				got = "move"
+			case res.Pinned != nil:
+				got = "pinned"
+			case res.Pending != nil:
+				got = res.Pending.Reason
+			}
+			if got != tt.want {
+				t.Fatalf("Claim() = %s, want %s", got, tt.want)
+			}
+
+			c, err := a.Get(tt.claim[0])
+			switch tt.want {
+			case "added", "move":
+				if err != nil || c.Address() != tt.claim[1] || c.Fingerprint != tt.claim[2] {
+					t.Errorf("contact = %+v, %v, want %s at %s with key %q", c, err, tt.claim[0], tt.claim[1], tt.claim[2])
+				}
+			case "pinned":
+				if err != nil || c.Fingerprint != tt.claim[2] {
+					t.Errorf("contact = %+v, %v, want key %q", c, err, tt.claim[2])
+				}
+			case ReasonNew:
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("held claim added contact %+v", c)
+				}
+			}
+		})
+	}
+}
+
+func TestClaimApproveReject(t *testing.T) {
+	a := testAlias(t, PolicyPrompt, Contact{Name: "alex", Addresses: []string{"192.168.1.5"}, Fingerprint: "SHA256:alex"})
+
+	moved, err := a.Claim("alex", "192.168.1.9", "SHA256:new")
+	if err != nil || moved.Pending == nil {
+		t.Fatalf("Claim() = %+v, %v, want pending", moved, err)
+	}
+	spoof, err := a.Claim("boss", "192.168.1.7", "")
+	if err != nil || spoof.Pending == nil {
+		t.Fatalf("Claim() = %+v, %v, want pending", spoof, err)
+	}
+
+	res, err := a.Approve(moved.Pending.ID)
+	if err != nil || res.Move == nil {
+		t.Fatalf("Approve() = %+v, %v, want move", res, err)
+	}
+	c, _ := a.Get("alex")
+	if c.Address() != "192.168.1.9" || c.Fingerprint != "SHA256:new" || !c.Has("192.168.1.5") {
+		t.Errorf("approved contact = %+v", c)
+	}
+
+	if err := a.Reject(spoof.Pending.ID); err != nil {
+		t.Fatalf("Reject() error = %v", err)
+	}
+	again, err := a.Claim("boss", "192.168.1.7", "")
+	if err != nil || again.Pending != nil {
+		t.Errorf("rejected claim came back: %+v, %v", again, err)
+	}
+
+	claims, err := a.Claims()
+	if err != nil || len(claims) != 0 {
+		t.Errorf("Claims() = %+v, %v, want none", claims, err)
+	}
+	if _, err := a.Approve(spoof.Pending.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Approve() of rejected claim error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestClaimLimits(t *testing.T) {
+	a := testAlias(t, PolicyPrompt)
+
+	if _, err := a.Claim("alex", "192.168.1.5", ""); err != nil {
+		t.Fatal(err)
+	}
+	for i := range maxPendingPerAddress * 2 {
+		if _, err := a.Claim(fmt.Sprintf("spam%d", i), "192.168.1.66", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claims, err := a.Claims()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claims) != maxPendingPerAddress+1 {
+		t.Fatalf("Claims() got %d claims, want %d", len(claims), maxPendingPerAddress+1)
+	}
+	if claims[0].Name != "alex" {
+		t.Errorf("claim of other address was dropped, first is %s", claims[0].Name)
+	}
+	if last := claims[len(claims)-1].Name; last != fmt.Sprintf("spam%d", maxPendingPerAddress*2-1) {
+		t.Errorf("newest claim was dropped, last is %s", last)
+	}
+}
+
+// testAlias returns contacts file with contacts in temp dir
+func testAlias(t *testing.T, policy string, contacts ...Contact) *Alias {
+	t.Helper()
+
+	a := New(filepath.Join(t.TempDir(), FileName))
+	if err := a.SetPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range contacts {
+		if err := a.Put(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return a
+}
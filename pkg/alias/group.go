@@ -0,0 +1,183 @@
+package alias
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// GroupsFileName is legacy file with groups, {"team": ["alex", "192.168.1.9"]}, it is migrated
+// into groups of contacts when it is found beside book
+const GroupsFileName = "groups.json"
+
+var (
+	ErrGroupNotFound error = errors.New("group not found")
+)
+
+// Groups returns names of contacts in every group, group keeps spelling of its first contact
+func (a *Alias) Groups() (map[string][]string, error) {
+	b, err := a.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return Groups(b.Contacts), nil
+}
+
+// Groups does the same as Alias.Groups over contacts already read
+func Groups(contacts []Contact) map[string][]string {
+	res := map[string][]string{}
+	for _, c := range contacts {
+		for _, g := range c.Groups {
+			name := g
+			for known := range res {
+				if strings.EqualFold(known, g) {
+					name = known
+					break
+				}
+			}
+			res[name] = append(res[name], c.Name)
+		}
+	}
+	return res
+}
+
+// Members returns names of contacts in group, case is ignored
+func (a *Alias) Members(group string) ([]string, error) {
+	b, err := a.read()
+	if err != nil {
+		return nil, err
+	}
+
+	members := Members(b.Contacts, group)
+	if len(members) == 0 {
+		return nil, fmt.Errorf("%s: %w", group, ErrGroupNotFound)
+	}
+
+	return members, nil
+}
+
+// Members does the same as Alias.Members over contacts already read, group without members
+// does not exist, so result is empty for unknown group
+func Members(contacts []Contact, group string) []string {
+	var res []string
+	for _, c := range contacts {
+		if containsFold(c.Groups, group) {
+			res = append(res, c.Name)
+		}
+	}
+	return res
+}
+
+// AddToGroup puts contacts with names or addresses into group, unknown ip address becomes
+// contact named by it
+func (a *Alias) AddToGroup(group string, who ...string) error {
+	group = strings.TrimSpace(group)
+	if group == "" {
+		return ErrInvalidContact
+	}
+
+	return a.update(func(b *Book) error {
+		for _, w := range who {
+			if err := b.addToGroup(group, w); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveFromGroup takes contacts with names or addresses out of group, without them group
+// is removed from every contact
+func (a *Alias) RemoveFromGroup(group string, who ...string) error {
+	return a.update(func(b *Book) error {
+		if len(who) == 0 {
+			found := false
+			for i := range b.Contacts {
+				c := &b.Contacts[i]
+				if containsFold(c.Groups, group) {
+					c.Groups = remove(c.Groups, group)
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("%s: %w", group, ErrGroupNotFound)
+			}
+			return nil
+		}
+
+		for _, w := range who {
+			i := b.find(w)
+			if i < 0 {
+				return fmt.Errorf("%s: %w", w, ErrNotFound)
+			}
+			b.Contacts[i].Groups = remove(b.Contacts[i].Groups, group)
+		}
+		return nil
+	})
+}
+
+/* ======== internal ======== */
+
+func (b *Book) addToGroup(group, who string) error {
+	i := b.find(who)
+	if i < 0 {
+		if net.ParseIP(who) == nil {
+			return fmt.Errorf("%s: %w", who, ErrNotFound)
+		}
+		if err := b.put(Contact{Name: who, Addresses: []string{who}}); err != nil {
+			return err
+		}
+		i = len(b.Contacts) - 1
+	}
+
+	c := &b.Contacts[i]
+	if !containsFold(c.Groups, group) {
+		c.Groups = append(c.Groups, group)
+	}
+	return nil
+}
+
+// migrateGroups moves groups of legacy groups file into book and renames file to backup,
+// members which are not contacts are skipped unless they are ip addresses, file lock must be held
+func (a *Alias) migrateGroups(b Book) (Book, error) {
+	path := filepath.Join(filepath.Dir(a.filePath), GroupsFileName)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return Book{}, err
+	}
+
+	list := map[string][]string{}
+	if len(strings.TrimSpace(string(data))) > 0 {
+		if err := json.Unmarshal(data, &list); err != nil {
+			return Book{}, fmt.Errorf("%w: %s: %s", ErrInvalidFormat, GroupsFileName, err)
+		}
+	}
+
+	names := make([]string, 0, len(list))
+	for name := range list {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		for _, who := range list[name] {
+			// names which are not contacts are dropped, they have no address
+			b.addToGroup(name, who)
+		}
+	}
+
+	if err := a.save(b); err != nil {
+		return Book{}, err
+	}
+	return b, os.Rename(path, path+legacyBackup)
+}
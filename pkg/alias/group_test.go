@@ -0,0 +1,78 @@
+package alias
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMigrateGroups(t *testing.T) {
+	dir := t.TempDir()
+	a := New(filepath.Join(dir, FileName))
+	a.Put(Contact{Name: "alex", Addresses: []string{"192.168.1.5"}})
+	a.Put(Contact{Name: "bob", Addresses: []string{"192.168.1.6"}})
+
+	legacy := filepath.Join(dir, GroupsFileName)
+	data := `{"team": ["alex", "192.168.1.9", "nobody"], "Office": ["ALEX"], "office": ["bob"]}`
+	if err := os.WriteFile(legacy, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := a.Groups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"Office": {"alex", "bob"},
+		"team":   {"alex", "192.168.1.9"},
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("Groups() = %v, want %v", list, want)
+	}
+
+	if _, err := os.Stat(legacy); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("legacy groups file is not moved, err = %v", err)
+	}
+	if _, err := os.Stat(legacy + legacyBackup); err != nil {
+		t.Errorf("backup of legacy groups file is missing, err = %v", err)
+	}
+}
+
+func TestGroupMembers(t *testing.T) {
+	a := testAlias(t, PolicyFirst,
+		Contact{Name: "alex", Addresses: []string{"192.168.1.5"}},
+		Contact{Name: "bob", Addresses: []string{"192.168.1.6"}},
+	)
+
+	if err := a.AddToGroup("team", "alex", "192.168.1.6", "192.168.1.9"); err != nil {
+		t.Fatalf("AddToGroup() error = %v", err)
+	}
+	if err := a.AddToGroup("team", "nobody"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AddToGroup() of unknown name error = %v, want %v", err, ErrNotFound)
+	}
+
+	members, err := a.Members("TEAM")
+	if err != nil || !reflect.DeepEqual(members, []string{"alex", "bob", "192.168.1.9"}) {
+		t.Errorf("Members() = %v, %v", members, err)
+	}
+
+	if err := a.RemoveFromGroup("team", "bob"); err != nil {
+		t.Fatalf("RemoveFromGroup() error = %v", err)
+	}
+	members, _ = a.Members("team")
+	if !reflect.DeepEqual(members, []string{"alex", "192.168.1.9"}) {
+		t.Errorf("Members() after remove = %v", members)
+	}
+
+	if err := a.RemoveFromGroup("team"); err != nil {
+		t.Fatalf("RemoveFromGroup() of group error = %v", err)
+	}
+	if _, err := a.Members("team"); !errors.Is(err, ErrGroupNotFound) {
+		t.Errorf("Members() of removed group error = %v, want %v", err, ErrGroupNotFound)
+	}
+	if err := a.RemoveFromGroup("team"); !errors.Is(err, ErrGroupNotFound) {
+		t.Errorf("RemoveFromGroup() of removed group error = %v, want %v", err, ErrGroupNotFound)
+	}
+}
@@ -0,0 +1,75 @@
+package alias
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"strings"
+)
+
+// legacyBackup is suffix of migrated legacy file, it is kept for older clients and rollback
+const legacyBackup = ".v1.bak"
+
+// migrate converts legacy file in path into book and saves it, legacy file is renamed to backup,
+// missing legacy file gives empty book, file lock must be held
+func (a *Alias) migrate(path string) (Book, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Book{}, nil
+	}
+	if err != nil {
+		return Book{}, err
+	}
+
+	b := Book{Contacts: parseLegacy(data)}
+
+	if path == a.filePath {
+		// book replaces legacy file, so copy is kept before it is overwritten
+		if err := os.WriteFile(path+legacyBackup, data, 0644); err != nil {
+			return Book{}, err
+		}
+		return b, a.save(b)
+	}
+
+	if err := a.save(b); err != nil {
+		return Book{}, err
+	}
+	return b, os.Rename(path, path+legacyBackup)
+}
+
+// parseLegacy reads <address> <alias> lines, every pair was written in both directions, so
+// line is taken address first unless only its last field is ip. Names with spaces, which old
+// reader refused, are kept, lines without name are skipped
+func parseLegacy(data []byte) []Contact {
+	b := Book{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		first, last := fields[0], fields[len(fields)-1]
+		addr, name := first, strings.Join(fields[1:], " ")
+		if net.ParseIP(first) == nil && net.ParseIP(last) != nil {
+			addr, name = last, strings.Join(fields[:len(fields)-1], " ")
+		}
+
+		if i := b.find(addr); i >= 0 {
+			// second line of pair, for hostnames it is <alias> <address>
+			continue
+		}
+
+		if i := b.find(name); i >= 0 {
+			b.Contacts[i].Addresses = append(b.Contacts[i].Addresses, addr)
+			continue
+		}
+
+		b.Contacts = append(b.Contacts, Contact{Name: name, Addresses: []string{addr}})
+	}
+
+	return b.Contacts
+}
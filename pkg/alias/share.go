@@ -0,0 +1,224 @@
+package alias
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ExportFormat marks files written by WriteExport
+const ExportFormat = "ipmsg-contacts"
+
+// Export is portable list of contacts, used for files and for lists shared by peers
+type Export struct {
+	Format   string    `json:"format"`
+	Version  int       `json:"version"`
+	Exported time.Time `json:"exported"`
+	Contacts []Contact `json:"contacts"`
+}
+
+// Conflict is value of imported contact which differs from ours and is not applied,
+// for address ours is name of contact which has it, for contact theirs is why it is skipped
+type Conflict struct {
+	Name   string `json:"name"`
+	Field  string `json:"field"`
+	Ours   string `json:"ours"`
+	Theirs string `json:"theirs"`
+}
+
+type MergeReport struct {
+	Added     []string   `json:"added"`
+	Updated   []string   `json:"updated"`
+	Unchanged int        `json:"unchanged"`
+	Conflicts []Conflict `json:"conflicts"`
+}
+
+// NewExport returns export of contacts, address history is left out
+func NewExport(contacts []Contact) Export {
+	res := Export{Format: ExportFormat, Version: 1, Exported: time.Now().UTC(), Contacts: []Contact{}}
+	for _, c := range contacts {
+		c.Moves = nil
+		res.Contacts = append(res.Contacts, c)
+	}
+	return res
+}
+
+// Published returns contacts which are in group (all for empty group) without notes and
+// fingerprints: notes are kept private when list is shared with peers and keys are pinned
+// only by messages contacts sign themselves
+func Published(contacts []Contact, group string) []Contact {
+	var res []Contact
+	for _, c := range contacts {
+		if len(c.Addresses) == 0 {
+			continue
+		}
+		if group != "" && !containsFold(c.Groups, group) {
+			continue
+		}
+		c.Notes = ""
+		c.Fingerprint = ""
+		res = append(res, c)
+	}
+	return res
+}
+
+func WriteExport(w io.Writer, e Export) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(e)
+}
+
+// ReadExport reads export written by WriteExport
+func ReadExport(r io.Reader) (Export, error) {
+	var e Export
+	if err := json.NewDecoder(r).Decode(&e); err != nil {
+		return Export{}, fmt.Errorf("%w: %s", ErrInvalidFormat, err)
+	}
+	if e.Format != ExportFormat {
+		return Export{}, fmt.Errorf("%w: not %s file", ErrInvalidFormat, ExportFormat)
+	}
+	if e.Version != 1 {
+		return Export{}, fmt.Errorf("%w: unsupported version %d", ErrInvalidFormat, e.Version)
+	}
+
+	return e, nil
+}
+
+// Merge adds contacts to book, known contacts get new addresses and groups and empty fields
+// filled. Values which differ from ours, addresses of other contacts among them, are reported
+// as conflicts and kept unless overwrite is set. Fingerprint of known contact is never filled
+// without overwrite, since it decides who may move contact. With dryRun book is not changed
+func (a *Alias) Merge(contacts []Contact, overwrite, dryRun bool) (MergeReport, error) {
+	if dryRun {
+		b, err := a.read()
+		if err != nil {
+			return MergeReport{}, err
+		}
+		return b.merge(contacts, overwrite), nil
+	}
+
+	var res MergeReport
+	err := a.update(func(b *Book) error {
+		res = b.merge(contacts, overwrite)
+		return nil
+	})
+
+	return res, err
+}
+
+/* ======== internal ======== */
+
+func (b *Book) merge(contacts []Contact, overwrite bool) MergeReport {
+	res := MergeReport{Added: []string{}, Updated: []string{}, Conflicts: []Conflict{}}
+
+	for _, in := range contacts {
+		in.Name = strings.TrimSpace(in.Name)
+		if in.Name == "" {
+			continue
+		}
+
+		i := -1
+		for k := range b.Contacts {
+			if strings.EqualFold(b.Contacts[k].Name, in.Name) {
+				i = k
+				break
+			}
+		}
+
+		// addresses of other contacts are taken only with overwrite
+		var addrs []string
+		for _, addr := range clean(in.Addresses) {
+			j := b.find(addr)
+			if j < 0 || j == i {
+				addrs = append(addrs, addr)
+				continue
+			}
+			if !overwrite {
+				res.Conflicts = append(res.Conflicts, Conflict{Name: in.Name, Field: "address", Ours: b.Contacts[j].Name, Theirs: addr})
+				continue
+			}
+			b.Contacts[j].Addresses = remove(b.Contacts[j].Addresses, addr)
+			addrs = append(addrs, addr)
+		}
+
+		if i < 0 {
+			if len(addrs) == 0 {
+				continue
+			}
+			c := Contact{Name: in.Name, Addresses: addrs, Notes: in.Notes, Groups: in.Groups, Fingerprint: in.Fingerprint, Port: in.Port}
+			if _, err := b.set(len(b.Contacts), c); err != nil {
+				res.Conflicts = append(res.Conflicts, Conflict{Name: in.Name, Field: "contact", Theirs: err.Error()})
+				continue
+			}
+			res.Added = append(res.Added, in.Name)
+			continue
+		}
+
+		c := &b.Contacts[i]
+		changed := false
+
+		for _, addr := range addrs {
+			if !containsFold(c.Addresses, addr) {
+				c.Addresses = append(c.Addresses, addr)
+				changed = true
+			}
+		}
+		if overwrite && len(addrs) > 0 && !strings.EqualFold(c.Address(), addrs[0]) {
+			c.Addresses = append([]string{addrs[0]}, remove(c.Addresses, addrs[0])...)
+			changed = true
+		}
+		for _, g := range clean(in.Groups) {
+			if !containsFold(c.Groups, g) {
+				c.Groups = append(c.Groups, g)
+				changed = true
+			}
+		}
+
+		fields := []struct {
+			name         string
+			ours, theirs string
+			fill         bool // empty value is filled without overwrite
+			set          func(string)
+		}{
+			{"notes", c.Notes, in.Notes, true, func(v string) { c.Notes = v }},
+			{"fingerprint", c.Fingerprint, in.Fingerprint, false, func(v string) { c.Fingerprint = v }},
+			{"port", portText(c.Port), portText(in.Port), true, func(string) { c.Port = in.Port }},
+		}
+		for _, f := range fields {
+			switch {
+			case f.theirs == "" || f.theirs == f.ours:
+			case (f.ours == "" && f.fill) || overwrite:
+				f.set(f.theirs)
+				changed = true
+			default:
+				res.Conflicts = append(res.Conflicts, Conflict{Name: c.Name, Field: f.name, Ours: f.ours, Theirs: f.theirs})
+			}
+		}
+
+		if changed {
+			res.Updated = append(res.Updated, c.Name)
+		} else {
+			res.Unchanged++
+		}
+	}
+
+	return res
+}
+
+func portText(port int) string {
+	if port == 0 {
+		return ""
+	}
+	return fmt.Sprint(port)
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,418 @@
+// package for talking to ipmsg daemon local api
+package apiclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"ipmsg/pkg/alias"
+	"ipmsg/pkg/models"
+	"ipmsg/pkg/store"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// DefaultSocket returns path of daemon socket in user home dir
+func DefaultSocket() (string, error) {
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(userHome, "ipmsg", "ipmsg.sock"), nil
+}
+
+// NewUnix returns client talking to daemon over unix socket
+func NewUnix(socket string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+
+	return &Client{
+		base: "http://ipmsg",
+		http: &http.Client{Transport: transport},
+	}
+}
+
+// DefaultToken returns path of token file daemon writes beside its socket
+func DefaultToken() (string, error) {
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(userHome, "ipmsg", "api.token"), nil
+}
+
+// ReadToken reads token of loopback api from file written by daemon
+func ReadToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// NewTCP returns client talking to daemon over loopback address (example: 127.0.0.1:6768),
+// token is read by ReadToken
+func NewTCP(addr, token string) *Client {
+	return &Client{
+		base:  "http://" + addr,
+		token: token,
+		http:  &http.Client{},
+	}
+}
+
+func (c *Client) Send(to, msg string) (*models.Delivery, error) {
+	var res models.Delivery
+	err := c.do(http.MethodPost, "/send", map[string]string{"to": to, "msg": msg}, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func (c *Client) Broadcast(msg string) (*models.BroadcastResult, error) {
+	var res models.BroadcastResult
+	err := c.do(http.MethodPost, "/broadcast", map[string]string{"msg": msg}, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// Sent saves message sent without daemon to its history
+func (c *Client) Sent(msg *models.Message) error {
+	return c.do(http.MethodPost, "/sent", msg, nil)
+}
+
+// UpdateDelivery saves new result of recipient of sent message with id
+func (c *Client) UpdateDelivery(id string, d models.Delivery) error {
+	return c.do(http.MethodPost, "/sent/"+url.PathEscape(id)+"/delivery", d, nil)
+}
+
+// History returns stored messages, since is unix time, zero values mean no filter
+func (c *Client) History(since int64, limit int) ([]models.Message, error) {
+	q := url.Values{}
+	if since > 0 {
+		q.Set("since", strconv.FormatInt(since, 10))
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+
+	var res []models.Message
+	if err := c.do(http.MethodGet, "/history?"+q.Encode(), nil, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// SearchQuery is search request, zero fields are not sent
+type SearchQuery struct {
+	Text    string // words and "quoted phrases"
+	From    string // alias or address
+	Group   string
+	Since   int64 // unix seconds
+	Until   int64
+	Limit   int
+	Context int
+}
+
+// Search returns messages matching query, newest first
+func (c *Client) Search(sq SearchQuery) ([]models.SearchResult, error) {
+	q := url.Values{}
+	q.Set("q", sq.Text)
+	if sq.From != "" {
+		q.Set("from", sq.From)
+	}
+	if sq.Group != "" {
+		q.Set("group", sq.Group)
+	}
+	if sq.Since > 0 {
+		q.Set("since", strconv.FormatInt(sq.Since, 10))
+	}
+	if sq.Until > 0 {
+		q.Set("until", strconv.FormatInt(sq.Until, 10))
+	}
+	if sq.Limit > 0 {
+		q.Set("limit", strconv.Itoa(sq.Limit))
+	}
+	if sq.Context > 0 {
+		q.Set("context", strconv.Itoa(sq.Context))
+	}
+
+	var res []models.SearchResult
+	if err := c.do(http.MethodGet, "/search?"+q.Encode(), nil, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Archive returns archive files of message history
+func (c *Client) Archive() ([]store.ArchiveFile, error) {
+	var res []store.ArchiveFile
+	if err := c.do(http.MethodGet, "/archive", nil, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Rotate applies rotation and retention rules right now
+func (c *Client) Rotate() (*models.RotateResult, error) {
+	var res models.RotateResult
+	if err := c.do(http.MethodPost, "/archive/rotate", struct{}{}, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// Unread returns unread counters by sender, with messages themselves if withMessages is set
+func (c *Client) Unread(withMessages bool) (*models.Unread, error) {
+	path := "/unread"
+	if withMessages {
+		path += "?messages=true"
+	}
+
+	var res models.Unread
+	if err := c.do(http.MethodGet, path, nil, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// MarkRead marks selected unread messages read and returns what is left unread
+func (c *Client) MarkRead(mr models.MarkRead) (*models.Unread, error) {
+	var res models.Unread
+	if err := c.do(http.MethodPost, "/unread/read", mr, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func (c *Client) Peers() ([]models.Peer, error) {
+	var res []models.Peer
+	if err := c.do(http.MethodGet, "/peers", nil, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Client) Aliases() ([]models.AliasEntry, error) {
+	var res []models.AliasEntry
+	if err := c.do(http.MethodGet, "/aliases", nil, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Client) AddAlias(name, address string) error {
+	return c.do(http.MethodPost, "/aliases", models.AliasEntry{Name: name, Address: address}, nil)
+}
+
+func (c *Client) RemoveAlias(name string) error {
+	return c.do(http.MethodDelete, "/aliases/"+url.PathEscape(name), nil, nil)
+}
+
+// Claims returns name claims waiting for approval
+func (c *Client) Claims() ([]alias.Pending, error) {
+	var res []alias.Pending
+	if err := c.do(http.MethodGet, "/claims", nil, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (c *Client) ApproveClaim(id string) (*alias.Claimed, error) {
+	var res alias.Claimed
+	if err := c.do(http.MethodPost, "/claims/"+url.PathEscape(id)+"/approve", nil, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func (c *Client) RejectClaim(id string) error {
+	return c.do(http.MethodPost, "/claims/"+url.PathEscape(id)+"/reject", nil, nil)
+}
+
+// WebLogin returns new one-time login url of web ui, daemon must run with --web
+func (c *Client) WebLogin() (string, error) {
+	var res models.WebLogin
+	if err := c.do(http.MethodPost, "/web/login", nil, &res); err != nil {
+		return "", err
+	}
+	return res.URL, nil
+}
+
+// Events calls fn for every daemon event published after afterID (zero for only new ones)
+// until ctx is done or connection is lost
+func (c *Client) Events(ctx context.Context, afterID uint64, fn func(models.Event)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+"/events", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	c.authorize(req)
+	if afterID != 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(afterID, 10))
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp)
+	}
+
+	var data []byte
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		switch {
+		case len(line) == 0: // end of event
+			if len(data) == 0 {
+				continue
+			}
+
+			var ev models.Event
+			if err := json.Unmarshal(data, &ev); err == nil {
+				fn(ev)
+			}
+			data = data[:0]
+		case bytes.HasPrefix(line, []byte("data:")):
+			data = append(data, bytes.TrimSpace(line[len("data:"):])...)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// Follow calls Events again after every disconnect, resuming from last received event,
+// returns only when ctx is done
+func (c *Client) Follow(ctx context.Context, fn func(models.Event), onErr func(error)) {
+	var lastID uint64
+	delay := time.Second
+
+	for {
+		err := c.Events(ctx, lastID, func(ev models.Event) {
+			lastID = ev.ID
+			delay = time.Second
+			fn(ev)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if onErr != nil {
+			onErr(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, 30*time.Second)
+	}
+}
+
+/* ======== internal ======== */
+
+func (c *Client) do(method, path string, in, out any) error {
+	// daemon accepts only json posts, so posts without input send empty object
+	if in == nil && method == http.MethodPost {
+		in = struct{}{}
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.authorize(req)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return readError(resp)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+}
+
+func readError(resp *http.Response) error {
+	var e struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+		return fmt.Errorf("daemon answered %s", resp.Status)
+	}
+
+	return fmt.Errorf("daemon: %s", e.Error)
+}
@@ -0,0 +1,189 @@
+// package for encryption of message history at rest, key is derived from passphrase
+// with argon2id and every record is sealed with xchacha20-poly1305. Encrypted file starts
+// with header line which keeps key derivation parameters and check value:
+//
+//	{"encrypted":"xchacha20poly1305","kdf":"argon2id","salt":"..","time":3,"memory":65536,"threads":4,"check":".."}
+package crypt
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	Cipher = "xchacha20poly1305"
+	KDF    = "argon2id"
+
+	saltSize   = 16
+	checkValue = "ipmsg"
+)
+
+var (
+	ErrWrongPassphrase error = errors.New("wrong passphrase")
+	ErrDecrypt         error = errors.New("record can not be decrypted")
+	ErrNotEncrypted    error = errors.New("file is not encrypted")
+)
+
+// Params are key derivation parameters, memory is in KiB
+type Params struct {
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+type Header struct {
+	Encrypted string `json:"encrypted"`
+	KDF       string `json:"kdf"`
+	Params
+	Check []byte `json:"check"` // checkValue sealed with key, tells wrong passphrase from damaged file
+}
+
+type Key struct {
+	aead cipher.AEAD
+}
+
+// NewParams returns parameters recommended for argon2id with new random salt
+func NewParams() (Params, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return Params{}, fmt.Errorf("crypt.NewParams: %w", err)
+	}
+
+	return Params{
+		Salt:    salt,
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: uint8(min(runtime.NumCPU(), 4)),
+	}, nil
+}
+
+func DeriveKey(passphrase []byte, p Params) (*Key, error) {
+	const op = "crypt.DeriveKey"
+
+	if len(p.Salt) == 0 || p.Time == 0 || p.Memory == 0 || p.Threads == 0 {
+		return nil, fmt.Errorf("%s: invalid key parameters", op)
+	}
+
+	key := argon2.IDKey(passphrase, p.Salt, p.Time, p.Memory, p.Threads, chacha20poly1305.KeySize)
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Key{aead: aead}, nil
+}
+
+// NewHeader derives key with new parameters, header must be stored with data sealed by key
+func NewHeader(passphrase []byte) (Header, *Key, error) {
+	const op = "crypt.NewHeader"
+
+	p, err := NewParams()
+	if err != nil {
+		return Header{}, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	key, err := DeriveKey(passphrase, p)
+	if err != nil {
+		return Header{}, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	check, err := key.Seal([]byte(checkValue))
+	if err != nil {
+		return Header{}, nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return Header{Encrypted: Cipher, KDF: KDF, Params: p, Check: check}, key, nil
+}
+
+// ParseHeader reads header line, ErrNotEncrypted means line is not header
+func ParseHeader(line []byte) (Header, error) {
+	var h Header
+	if err := json.Unmarshal(line, &h); err != nil || h.Encrypted == "" {
+		return Header{}, ErrNotEncrypted
+	}
+
+	if h.Encrypted != Cipher || h.KDF != KDF {
+		return Header{}, fmt.Errorf("crypt.ParseHeader: unsupported encryption %s/%s", h.Encrypted, h.KDF)
+	}
+
+	return h, nil
+}
+
+// Unlock derives key from passphrase and checks it against header
+func (h *Header) Unlock(passphrase []byte) (*Key, error) {
+	const op = "crypt.Header.Unlock"
+
+	key, err := DeriveKey(passphrase, h.Params)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	check, err := key.Open(h.Check)
+	if err != nil || string(check) != checkValue {
+		return nil, fmt.Errorf("%s: %w", op, ErrWrongPassphrase)
+	}
+
+	return key, nil
+}
+
+// Line returns header as json line without line break
+func (h *Header) Line() ([]byte, error) {
+	return json.Marshal(h)
+}
+
+// Seal encrypts plain, random nonce is put before ciphertext
+func (k *Key) Seal(plain []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(plain)+k.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("crypt.Key.Seal: %w", err)
+	}
+
+	return k.aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// Open decrypts data sealed with Seal
+func (k *Key) Open(data []byte) ([]byte, error) {
+	if len(data) < k.aead.NonceSize()+k.aead.Overhead() {
+		return nil, ErrDecrypt
+	}
+
+	nonce, sealed := data[:k.aead.NonceSize()], data[k.aead.NonceSize():]
+	plain, err := k.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return plain, nil
+}
+
+// SealLine encrypts line of text file, result is base64 without line breaks
+func (k *Key) SealLine(line []byte) ([]byte, error) {
+	sealed, err := k.Seal(line)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(res, sealed)
+	return res, nil
+}
+
+// OpenLine decrypts line sealed with SealLine
+func (k *Key) OpenLine(line []byte) ([]byte, error) {
+	sealed := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(sealed, line)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return k.Open(sealed[:n])
+}
@@ -0,0 +1,95 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+
+	"golang.org/x/term"
+)
+
+// EnvPassphrase is environment variable with passphrase, for daemons started without terminal
+const EnvPassphrase = "IPMSG_PASSPHRASE"
+
+var (
+	ErrNoPassphrase error = errors.New("passphrase is not set, use key file, " + EnvPassphrase + " or run in terminal")
+	ErrMismatch     error = errors.New("passphrases do not match")
+)
+
+// Passphrase returns passphrase from key file, environment or asks for it on terminal,
+// with confirm it is asked twice, that is for new keys
+func Passphrase(keyFile string, confirm bool) ([]byte, error) {
+	const op = "crypt.Passphrase"
+
+	if keyFile != "" {
+		pass, err := readKeyFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		return pass, nil
+	}
+
+	if pass := os.Getenv(EnvPassphrase); pass != "" {
+		return []byte(pass), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoPassphrase)
+	}
+
+	pass, err := prompt(fd, "Passphrase: ")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(pass) == 0 {
+		return nil, fmt.Errorf("%s: %w", op, ErrNoPassphrase)
+	}
+
+	if confirm {
+		again, err := prompt(fd, "Repeat passphrase: ")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if !bytes.Equal(pass, again) {
+			return nil, fmt.Errorf("%s: %w", op, ErrMismatch)
+		}
+	}
+
+	return pass, nil
+}
+
+/* ======== internal ======== */
+
+// readKeyFile returns first line of key file, file must not be readable by other users
+func readKeyFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("key file %s is accessible by other users, run chmod 600 on it", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pass, _, _ := bytes.Cut(data, []byte("\n"))
+	pass = bytes.TrimSuffix(pass, []byte("\r"))
+	if len(pass) == 0 {
+		return nil, fmt.Errorf("key file %s is empty", path)
+	}
+
+	return pass, nil
+}
+
+func prompt(fd int, text string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, text)
+	pass, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return pass, err
+}
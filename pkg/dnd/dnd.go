@@ -0,0 +1,195 @@
+// package for do not disturb mode and quiet hours, shared by cli and server through json file
+package dnd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ipmsg/pkg/models"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Forever is used as Until when dnd is turned on without duration
+var Forever = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+type Settings struct {
+	Until         time.Time   `json:"until"` // manual dnd end, zero if off
+	QuietHours    []QuietHour `json:"quiet_hours,omitempty"`
+	AllowFrom     []string    `json:"allow_from,omitempty"`     // contact names or addresses which always notify
+	AllowPriority []string    `json:"allow_priority,omitempty"` // priorities which always notify
+}
+
+// QuietHour is recurring period, To before From means period goes over midnight
+type QuietHour struct {
+	Days []string `json:"days,omitempty"` // mon, tue, ..., empty for every day
+	From string   `json:"from"`           // 22:00
+	To   string   `json:"to"`             // 07:30
+}
+
+func Default() Settings {
+	return Settings{AllowPriority: []string{models.PriorityHigh}}
+}
+
+// Load reads settings, missing file means defaults
+func Load(path string) (Settings, error) {
+	const op = "dnd.Load"
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(data) == 0) {
+		return Default(), nil
+	}
+	if err != nil {
+		return Settings{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var s Settings
+	if err := json.Unmarshal(data, &s); err != nil {
+		return Settings{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, q := range s.QuietHours {
+		if err := q.Validate(); err != nil {
+			return Settings{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return s, nil
+}
+
+// Save writes settings atomically
+func Save(path string, s Settings) error {
+	const op = "dnd.Save"
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".dnd-*")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Active reports whether notifications are suppressed at t and why
+func (s *Settings) Active(t time.Time) (bool, string) {
+	if t.Before(s.Until) {
+		if s.Until.Equal(Forever) {
+			return true, "do not disturb is on"
+		}
+		return true, "do not disturb until " + s.Until.Local().Format(time.DateTime)
+	}
+
+	for _, q := range s.QuietHours {
+		if q.Contains(t) {
+			return true, fmt.Sprintf("quiet hours %s-%s", q.From, q.To)
+		}
+	}
+
+	return false, ""
+}
+
+// Allowed reports whether message from sender address notifies even during dnd, name is contact
+// name of sender, alias and address claimed in message are not trusted
+func (s *Settings) Allowed(msg *models.IPmsgRequest, sender, name string) bool {
+	prio := msg.Priority
+	if prio == models.PriorityNormal {
+		prio = "normal"
+	}
+
+	if slices.Contains(s.AllowPriority, prio) {
+		return true
+	}
+
+	return slices.Contains(s.AllowFrom, sender) ||
+		(name != "" && slices.Contains(s.AllowFrom, name))
+}
+
+func (q *QuietHour) Validate() error {
+	if _, err := parseClock(q.From); err != nil {
+		return err
+	}
+	if _, err := parseClock(q.To); err != nil {
+		return err
+	}
+
+	for _, d := range q.Days {
+		if _, ok := weekdays[strings.ToLower(d)]; !ok {
+			return fmt.Errorf("invalid day %q, use mon, tue, wed, thu, fri, sat or sun", d)
+		}
+	}
+
+	return nil
+}
+
+// Contains reports whether local time t is inside quiet hours
+func (q *QuietHour) Contains(t time.Time) bool {
+	from, err := parseClock(q.From)
+	if err != nil {
+		return false
+	}
+	to, err := parseClock(q.To)
+	if err != nil {
+		return false
+	}
+
+	t = t.Local()
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+
+	day := t.Weekday()
+	var inside bool
+	if from <= to {
+		inside = now >= from && now < to
+	} else {
+		// over midnight, period after midnight belongs to previous day
+		inside = now >= from || now < to
+		if now < to {
+			day = (day + 6) % 7
+		}
+	}
+
+	return inside && q.onDay(day)
+}
+
+func (q *QuietHour) onDay(day time.Weekday) bool {
+	if len(q.Days) == 0 {
+		return true
+	}
+
+	for _, d := range q.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
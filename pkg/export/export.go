@@ -0,0 +1,250 @@
+// package for writing message history as standalone html page, markdown, json or csv
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"ipmsg/pkg/models"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	FormatHTML     = "html"
+	FormatMarkdown = "md"
+	FormatJSON     = "json"
+	FormatCSV      = "csv"
+
+	timeLayout = "2006-01-02 15:04:05"
+)
+
+var (
+	ErrUnknownFormat error = errors.New("unknown export format, expected html, md, json or csv")
+)
+
+type Options struct {
+	Title string
+	Names map[string]string // address - contact name map, used for senders and recipients without alias
+}
+
+// FormatOf guesses format by file extension, empty if extension is unknown
+func FormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		return FormatHTML
+	case ".md", ".markdown":
+		return FormatMarkdown
+	case ".json":
+		return FormatJSON
+	case ".csv":
+		return FormatCSV
+	}
+	return ""
+}
+
+// Write writes msgs in format, aliases are resolved with opt.Names
+func Write(w io.Writer, format string, msgs []models.Message, opt Options) error {
+	const op = "export.Write"
+
+	msgs = resolve(msgs, opt.Names)
+
+	var err error
+	switch format {
+	case FormatHTML:
+		err = writeHTML(w, msgs, opt)
+	case FormatMarkdown:
+		err = writeMarkdown(w, msgs, opt)
+	case FormatJSON:
+		err = writeJSON(w, msgs)
+	case FormatCSV:
+		err = writeCSV(w, msgs, opt)
+	default:
+		return fmt.Errorf("%s: %q: %w", op, format, ErrUnknownFormat)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+/* ======== internal ======== */
+
+// resolve fills alias of senders from alias file, msgs are not changed
+func resolve(msgs []models.Message, names map[string]string) []models.Message {
+	res := make([]models.Message, len(msgs))
+	for i, m := range msgs {
+		if m.Alias == "" {
+			m.Alias = names[m.From]
+		}
+		res[i] = m
+	}
+	return res
+}
+
+func sender(m *models.Message) string {
+	if m.Alias != "" {
+		return fmt.Sprintf("%s(%s)", m.Alias, m.From)
+	}
+	return m.From
+}
+
+func recipients(m *models.Message, names map[string]string) string {
+	list := make([]string, len(m.To))
+	for i, to := range m.To {
+		if name, ok := names[to]; ok {
+			to = fmt.Sprintf("%s(%s)", name, to)
+		}
+		list[i] = to
+	}
+	return strings.Join(list, ", ")
+}
+
+func date(m *models.Message) string {
+	return time.Unix(m.Date, 0).Format(timeLayout)
+}
+
+var page = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; color: #222; }
+.msg { border-bottom: 1px solid #ddd; padding: .6em 0; }
+.out .from { color: #2a6; }
+.from { font-weight: bold; }
+.date, .to { color: #888; font-size: .9em; margin-left: .5em; }
+.body { white-space: pre-wrap; margin-top: .3em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Messages}}<div class="msg {{.Direction}}">
+<span class="from">{{.From}}</span>{{if .To}}<span class="to">to {{.To}}</span>{{end}}<span class="date">{{.Date}}</span>
+<div class="body">{{.Msg}}</div>
+</div>
+{{end}}</body>
+</html>
+`))
+
+func writeHTML(w io.Writer, msgs []models.Message, opt Options) error {
+	type entry struct {
+		Direction, From, To, Date, Msg string
+	}
+
+	data := struct {
+		Title    string
+		Messages []entry
+	}{Title: opt.Title}
+
+	for i := range msgs {
+		m := &msgs[i]
+		data.Messages = append(data.Messages, entry{
+			Direction: m.Direction,
+			From:      sender(m),
+			To:        recipients(m, opt.Names),
+			Date:      date(m),
+			Msg:       m.Msg,
+		})
+	}
+
+	// template escapes every value
+	return page.Execute(w, data)
+}
+
+func writeMarkdown(w io.Writer, msgs []models.Message, opt Options) error {
+	var b strings.Builder
+
+	if opt.Title != "" {
+		b.WriteString("# " + escapeMarkdown(opt.Title) + "\n\n")
+	}
+
+	for i := range msgs {
+		m := &msgs[i]
+
+		b.WriteString("**" + escapeMarkdown(sender(m)) + "**")
+		if len(m.To) > 0 {
+			b.WriteString(" to " + escapeMarkdown(recipients(m, opt.Names)))
+		}
+		b.WriteString(" _" + date(m) + "_\n\n")
+
+		// every line is quoted, so body can not break out into headers or lists
+		for _, line := range strings.Split(strings.TrimRight(m.Msg, "\r\n"), "\n") {
+			line = strings.TrimRight(line, "\r")
+			if line == "" {
+				b.WriteString(">\n")
+				continue
+			}
+			b.WriteString("> " + escapeMarkdown(line) + "  \n")
+		}
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`#`, `\#`, `|`, `\|`, `~`, `\~`, `<`, `&lt;`, `>`, `&gt;`, `&`, `&amp;`,
+)
+
+func escapeMarkdown(s string) string {
+	s = markdownEscaper.Replace(s)
+
+	// list and ordered list markers at start of line
+	if len(s) > 0 && strings.ContainsRune("-+=", rune(s[0])) {
+		s = `\` + s
+	}
+	if i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' }); i > 0 && s[i] == '.' && (i+1 == len(s) || s[i+1] == ' ') {
+		s = s[:i] + `\` + s[i:]
+	}
+
+	return s
+}
+
+func writeJSON(w io.Writer, msgs []models.Message) error {
+	if msgs == nil {
+		msgs = []models.Message{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(msgs)
+}
+
+func writeCSV(w io.Writer, msgs []models.Message, opt Options) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{"date", "direction", "from", "alias", "to", "message"}); err != nil {
+		return err
+	}
+
+	for i := range msgs {
+		m := &msgs[i]
+		record := []string{date(m), m.Direction, m.From, m.Alias, recipients(m, opt.Names), m.Msg}
+		for j := range record {
+			record[j] = escapeCell(record[j])
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// escapeCell keeps spreadsheets from running message text as formula
+func escapeCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
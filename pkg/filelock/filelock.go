@@ -0,0 +1,54 @@
+// package for locking files between processes, lock is kept in separate hidden file
+// next to locked one, so it stays valid when locked file is replaced by rename
+package filelock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type Lock struct {
+	path string
+}
+
+// For returns lock of file path, lock file is .<name>.lock in the same directory
+func For(path string) *Lock {
+	return &Lock{path: filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".lock")}
+}
+
+// Lock waits for exclusive lock, used by writers
+func (l *Lock) Lock() (unlock func(), err error) {
+	return l.acquire(true)
+}
+
+// RLock waits for shared lock, used by readers
+func (l *Lock) RLock() (unlock func(), err error) {
+	return l.acquire(false)
+}
+
+/* ======== internal ======== */
+
+func (l *Lock) acquire(exclusive bool) (func(), error) {
+	const op = "filelock.acquire"
+
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR, 0644)
+	if errors.Is(err, os.ErrNotExist) && !exclusive {
+		// no directory means no file to read and no writer to wait for
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := lockFile(file, exclusive); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}, nil
+}
@@ -1,7 +1,9 @@
 package fileparser
 
 import (
-	"bufio"
+	"fmt"
+	"io"
+	"ipmsg/pkg/filelock"
 	"ipmsg/pkg/models"
 	"os"
 	"strconv"
@@ -9,81 +11,170 @@ import (
 	"time"
 )
 
+// ParseFile reads messages file under shared lock, so records being written are not seen
 func ParseFile(filename string) ([]models.IPmsgRequest, error) {
+	unlock, err := filelock.For(filename).RLock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	var result []models.IPmsgRequest
-	scanner := bufio.NewScanner(file)
+	return Parse(file)
+}
+
+// Parse reads messages in any known text format
+func Parse(r io.Reader) ([]models.IPmsgRequest, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(data), "\n")
+
+	if lines[0] == versionLine {
+		// Skip version and header (3 lines)
+		return parseV2(lines[min(3, len(lines)):])
+	}
+
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+	}
 
 	// Skip header (2 lines)
-	for i := 0; i < 2 && scanner.Scan(); i++ {
+	return parseV1(lines[min(2, len(lines)):])
+}
+
+/* ======== internal ======== */
+
+type header struct {
+	date       int64
+	from       string
+	alias      string
+	len        int
+	deliveries []models.Delivery // recipients of sent message
+}
+
+func parseHeader(line string, parts []string, layout string) (header, error) {
+	if len(parts) != 3 {
+		return header{}, fmt.Errorf("invalid header %q", line)
 	}
 
-	for {
-		if !scanner.Scan() {
-			break
-		}
+	// TIME
+	t, err := time.ParseInLocation(layout, strings.TrimSpace(parts[0]), time.Local)
+	if err != nil {
+		return header{}, err
+	}
 
-		meta := strings.TrimSpace(scanner.Text())
-		if meta == "" {
-			continue
-		}
+	// LEN
+	l, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+	if err != nil {
+		return header{}, err
+	}
+
+	// FROM / ALIAS, sent message has recipients instead
+	fromRaw := strings.TrimSpace(parts[1])
+	if deliveries, sent := parseRecipients(fromRaw); sent {
+		return header{date: t.Unix(), len: l, deliveries: deliveries}, nil
+	}
+
+	alias, from := parseSender(fromRaw)
+
+	return header{date: t.Unix(), from: from, alias: alias, len: l}, nil
+}
+
+func (h *header) request(body string) models.IPmsgRequest {
+	return models.IPmsgRequest{
+		From:       h.from,
+		Alias:      h.alias,
+		Len:        h.len,
+		Date:       h.date,
+		Msg:        body,
+		Deliveries: h.deliveries,
+	}
+}
+
+func parseV2(lines []string) ([]models.IPmsgRequest, error) {
+	var result []models.IPmsgRequest
 
-		// Split metadata line
-		parts := strings.Split(meta, "|")
-		if len(parts) != 3 {
+	for i := 0; i < len(lines); i++ {
+		if lines[i] == "" {
 			continue
 		}
 
-		// TIME
-		t, err := time.Parse(time.DateTime, strings.TrimSpace(parts[0]))
+		h, err := parseHeader(lines[i], splitHeader(lines[i]), timeLayout)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("line %d: %w", i+4, err)
 		}
 
-		// FROM / ALIAS
-		fromRaw := strings.TrimSpace(parts[1])
-		from := fromRaw
-		alias := ""
+		var body []string
+		for i+1 < len(lines) && strings.HasPrefix(lines[i+1], bodyPrefix) {
+			i++
+			line := strings.TrimPrefix(lines[i], bodyPrefix)
+			body = append(body, strings.TrimPrefix(line, " "))
+		}
+
+		result = append(result, h.request(strings.Join(body, "\n")))
+	}
 
-		if strings.Contains(fromRaw, "(") && strings.HasSuffix(fromRaw, ")") {
-			i := strings.Index(fromRaw, "(")
-			alias = fromRaw[:i]
-			from = strings.TrimSuffix(fromRaw[i+1:], ")")
+	return result, nil
+}
+
+// parseV1 reads legacy format, where body is not escaped, so blank line ends body
+// only when body already has declared length or next text is header of another message
+func parseV1(lines []string) ([]models.IPmsgRequest, error) {
+	var result []models.IPmsgRequest
+
+	legacyHeader := func(line string) (header, bool) {
+		h, err := parseHeader(line, strings.Split(line, "|"), time.DateTime)
+		return h, err == nil
+	}
+
+	for i := 0; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "" {
+			continue
 		}
 
-		// LEN
-		l, err := strconv.Atoi(strings.TrimSpace(parts[2]))
-		if err != nil {
-			return nil, err
+		h, ok := legacyHeader(lines[i])
+		if !ok {
+			continue
 		}
 
 		// Read message body
-		var msgLines []string
-		for scanner.Scan() {
-			line := scanner.Text()
+		var body []string
+		size := -1 // length of joined body
+		for i+1 < len(lines) {
+			line := lines[i+1]
+
 			if strings.TrimSpace(line) == "" {
-				break
+				if size >= h.len {
+					break
+				}
+
+				next := i + 1
+				for next < len(lines) && strings.TrimSpace(lines[next]) == "" {
+					next++
+				}
+				if next == len(lines) {
+					break
+				}
+				if _, isHeader := legacyHeader(lines[next]); isHeader && size+next-i+len(lines[next]) > h.len {
+					break
+				}
 			}
-			msgLines = append(msgLines, line)
-		}
 
-		result = append(result, models.IPmsgRequest{
-			From:  from,
-			Alias: alias,
-			Len:   l,
-			Date:  t.Unix(),
-			Msg:   strings.Join(msgLines, "\n"),
-		})
-	}
+			body = append(body, line)
+			size += len(line) + 1
+			i++
+		}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+		result = append(result, h.request(strings.Join(body, "\n")))
 	}
 
 	return result, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,284 @@
+package fileparser
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"ipmsg/pkg/models"
+	"os"
+	"strings"
+	"time"
+)
+
+// text file formats:
+//
+// v1 (legacy) - header line and raw message body, record ends at blank line
+//
+//	2024-01-02 15:04:05  | alex(192.168.1.5)              |      5
+//	hello
+//
+// v2 - version line on top, zone in time, escaped sender and every body line prefixed with "> ",
+// so blank lines and "|" in messages are kept
+//
+//	# ipmsg text v2
+//	2024-01-02 15:04:05 +0300 | alex(192.168.1.5)              |     12
+//	> hello
+//	>
+//	> a | b | c
+//
+// sent message has recipients in FROM column, delivery result follows hosts which did not get it
+//
+//	2024-01-02 15:05:00 +0300 | -> alex(192.168.1.5), 192.168.1.6 [queued] |      2
+//	> hi
+//
+// names and addresses in FROM column have "\", ",", "(", ")", "[" and "]" escaped with "\" and
+// sender starting with "->" gets "\" before it, so alias sent by peer never reads as recipients
+const (
+	V1      = 1
+	V2      = 2
+	Version = V2
+
+	versionLine = "# ipmsg text v2"
+	bodyPrefix  = ">"
+	timeLayout  = "2006-01-02 15:04:05 -0700"
+	sentPrefix  = "-> "
+)
+
+// FileVersion returns format of file, 0 for missing or empty file
+func FileVersion(filename string) (int, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return DetectVersion(file)
+}
+
+// DetectVersion reads first line of r, 0 means r is empty
+func DetectVersion(r io.Reader) (int, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	line = strings.TrimRight(line, "\r\n")
+	switch {
+	case line == "":
+		return 0, nil
+	case line == versionLine:
+		return V2, nil
+	default:
+		return V1, nil
+	}
+}
+
+// WriteHeader writes file header of version
+func WriteHeader(w io.Writer, version int) error {
+	top := ""
+	timeWidth := 20
+	if version >= V2 {
+		top = versionLine + "\n"
+		timeWidth = len(timeLayout)
+	}
+
+	_, err := fmt.Fprintf(
+		w,
+		"%s%-*s | %-30s | %6s\n%s\n",
+		top,
+		timeWidth,
+		"TIME",
+		"FROM",
+		"LEN",
+		"---------------------------------------------------------------",
+	)
+	return err
+}
+
+// WriteEntry writes one message, from is FROM column made by FormatSender or FormatRecipients
+func WriteEntry(w io.Writer, version int, from string, req *models.IPmsgRequest) error {
+	t := time.Unix(req.Date, 0)
+
+	if version < V2 {
+		_, err := fmt.Fprintf(
+			w,
+			"%-20s | %-30s | %6d\n%s\n\n",
+			t.Format(time.DateTime),
+			from,
+			req.Len,
+			req.Msg,
+		)
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s | %-30s | %6d\n", t.Format(timeLayout), escape(from), req.Len)
+	for _, line := range strings.Split(req.Msg, "\n") {
+		b.WriteString(bodyPrefix)
+		if line != "" {
+			b.WriteString(" " + line)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// FormatSender renders FROM column of received message (example: alex(192.168.1.5))
+func FormatSender(alias, from string) string {
+	res := escapeName(from)
+	if alias != "" {
+		res = fmt.Sprintf("%s(%s)", escapeName(alias), res)
+	}
+	if strings.HasPrefix(res, "->") {
+		res = `\` + res
+	}
+	return res
+}
+
+// FormatRecipients renders FROM column of sent message, names is address - alias map and may be nil
+func FormatRecipients(deliveries []models.Delivery, names map[string]string) string {
+	list := make([]string, len(deliveries))
+	for i, d := range deliveries {
+		list[i] = escapeName(d.To)
+		if name, ok := names[d.To]; ok {
+			list[i] = fmt.Sprintf("%s(%s)", escapeName(name), escapeName(d.To))
+		}
+		if d.Status != "" && d.Status != models.StatusSent {
+			list[i] += " [" + d.Status + "]"
+		}
+	}
+
+	return sentPrefix + strings.Join(list, ", ")
+}
+
+/* ======== internal ======== */
+
+// parseSender reads FROM column written by FormatSender
+func parseSender(field string) (alias, from string) {
+	if i := lastUnescaped(field, "("); i >= 0 && strings.HasSuffix(field, ")") {
+		return unescapeName(field[:i]), unescapeName(field[i+1 : len(field)-1])
+	}
+	return "", unescapeName(field)
+}
+
+// parseRecipients reads FROM column written by FormatRecipients, ok is false for received message
+func parseRecipients(field string) ([]models.Delivery, bool) {
+	list, ok := strings.CutPrefix(field, sentPrefix)
+	if !ok {
+		return nil, false
+	}
+
+	var res []models.Delivery
+	for _, item := range splitUnescaped(list, ", ") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		d := models.Delivery{Status: models.StatusSent}
+		if i := lastUnescaped(item, " ["); i >= 0 && strings.HasSuffix(item, "]") {
+			d.Status = unescapeName(item[i+2 : len(item)-1])
+			item = item[:i]
+		}
+		d.Delivered = d.Status == models.StatusSent
+
+		_, d.To = parseSender(item)
+		res = append(res, d)
+	}
+
+	return res, true
+}
+
+// escapeName escapes characters which separate names in FROM column
+func escapeName(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ",", `\,`, "(", `\(`, ")", `\)`, "[", `\[`, "]", `\]`)
+	return r.Replace(s)
+}
+
+// unescapeName drops "\" before escaped characters, files written before escaping keep their text
+func unescapeName(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// splitUnescaped splits s on sep which is not escaped with "\"
+func splitUnescaped(s, sep string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case strings.HasPrefix(s[i:], sep):
+			parts = append(parts, s[start:i])
+			start = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// lastUnescaped returns index of last sub in s which is not escaped with "\", -1 if there is none
+func lastUnescaped(s, sub string) int {
+	last := -1
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case strings.HasPrefix(s[i:], sub):
+			last = i
+		}
+	}
+	return last
+}
+
+// escape makes header field safe for "|" separated line
+func escape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", `\n`, "\r", `\r`)
+	return r.Replace(s)
+}
+
+// splitHeader splits header line on unescaped "|" and unescapes fields
+func splitHeader(line string) []string {
+	var parts []string
+	var cur strings.Builder
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line):
+			i++
+			switch line[i] {
+			case 'n':
+				cur.WriteByte('\n')
+			case 'r':
+				cur.WriteByte('\r')
+			default:
+				cur.WriteByte(line[i])
+			}
+		case c == '|':
+			parts = append(parts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+
+	return append(parts, cur.String())
+}
@@ -0,0 +1,127 @@
+package fileparser
+
+import (
+	"bytes"
+	"ipmsg/pkg/models"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestV2RoundTrip(t *testing.T) {
+	date := time.Date(2024, 1, 2, 15, 4, 5, 0, time.Local).Unix()
+
+	tests := []struct {
+		name string
+		from string
+		req  models.IPmsgRequest
+	}{
+		{
+			name: "plain",
+			from: FormatSender("alex", "192.168.1.5"),
+			req:  models.IPmsgRequest{From: "192.168.1.5", Alias: "alex", Msg: "hello"},
+		},
+		{
+			name: "blank lines and separators in body",
+			from: FormatSender("", "192.168.1.5"),
+			req:  models.IPmsgRequest{From: "192.168.1.5", Msg: "hello\n\na | b | c\n> quoted\n"},
+		},
+		{
+			name: "alias looks like sent message",
+			from: FormatSender("-> bob", "192.168.1.5"),
+			req:  models.IPmsgRequest{From: "192.168.1.5", Alias: "-> bob", Msg: "hi"},
+		},
+		{
+			name: "alias with separators",
+			from: FormatSender(`a, b [c] (d) | \e`, "192.168.1.5"),
+			req:  models.IPmsgRequest{From: "192.168.1.5", Alias: `a, b [c] (d) | \e`, Msg: "hi"},
+		},
+		{
+			name: "alias with line break",
+			from: FormatSender("a\nb", "192.168.1.5"),
+			req:  models.IPmsgRequest{From: "192.168.1.5", Alias: "a\nb", Msg: "hi"},
+		},
+		{
+			name: "sent",
+			from: FormatRecipients([]models.Delivery{
+				{To: "192.168.1.5", Delivered: true, Status: models.StatusSent},
+				{To: "192.168.1.6", Status: models.StatusQueued},
+			}, map[string]string{"192.168.1.5": "x, y (z)"}),
+			req: models.IPmsgRequest{Msg: "hi", Deliveries: []models.Delivery{
+				{To: "192.168.1.5", Delivered: true, Status: models.StatusSent},
+				{To: "192.168.1.6", Status: models.StatusQueued},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.req
+			want.Date = date
+			want.Len = len(want.Msg)
+
+			var buf bytes.Buffer
+			if err := WriteHeader(&buf, V2); err != nil {
+				t.Fatal(err)
+			}
+			for range 2 {
+				if err := WriteEntry(&buf, V2, tt.from, &want); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if v, err := DetectVersion(bytes.NewReader(buf.Bytes())); err != nil || v != V2 {
+				t.Fatalf("DetectVersion() = %d, %v, want %d", v, err, V2)
+			}
+
+			got, err := Parse(&buf)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			if len(got) != 2 {
+				t.Fatalf("Parse() got %d messages, want 2", len(got))
+			}
+			for _, m := range got {
+				if !reflect.DeepEqual(m, want) {
+					t.Errorf("Parse() = %+v, want %+v", m, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseV1(t *testing.T) {
+	text := strings.Join([]string{
+		"TIME                 | FROM                           |    LEN",
+		"---------------------------------------------------------------",
+		"2024-01-02 15:04:05  | alex(192.168.1.5)              |      5",
+		"hello",
+		"",
+		"2024-01-02 15:05:05  | 192.168.1.6                    |     12",
+		"first",
+		"",
+		"third",
+		"",
+		"",
+	}, "\n")
+
+	got, err := Parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := []struct{ from, alias, msg string }{
+		{"192.168.1.5", "alex", "hello"},
+		{"192.168.1.6", "", "first\n\nthird"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Parse() got %d messages, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].From != w.from || got[i].Alias != w.alias || got[i].Msg != w.msg {
+			t.Errorf("message %d = %q %q %q, want %q %q %q", i, got[i].From, got[i].Alias, got[i].Msg, w.from, w.alias, w.msg)
+		}
+	}
+}
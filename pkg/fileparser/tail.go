@@ -0,0 +1,155 @@
+package fileparser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"ipmsg/pkg/filelock"
+	"ipmsg/pkg/models"
+	"os"
+	"strings"
+	"time"
+)
+
+// Tail reads messages file incrementally, it remembers byte offset of read records
+// and every Next returns only records added since previous call
+type Tail struct {
+	filePath string
+
+	offset  int64
+	version int
+	info    os.FileInfo // file read last time, to notice replaced file
+	last    *models.IPmsgRequest
+}
+
+// NewTail returns reader from start of file
+func NewTail(path string) *Tail {
+	return &Tail{filePath: path}
+}
+
+// Offset returns position after last read record
+func (t *Tail) Offset() int64 {
+	return t.offset
+}
+
+// Next returns records written since previous call, missing file has no records.
+// File which was truncated or replaced (migration, rotation) is read from start again,
+// records up to last one already returned are skipped then
+func (t *Tail) Next() ([]models.IPmsgRequest, error) {
+	const op = "fileparser.Tail.Next"
+
+	unlock, err := filelock.For(t.filePath).RLock()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	file, err := os.Open(t.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	restarted := false
+	if t.info != nil && (!os.SameFile(t.info, info) || info.Size() < t.offset) {
+		t.offset, t.version = 0, 0
+		restarted = true
+	}
+	t.info = info
+
+	if info.Size() == t.offset {
+		return nil, nil
+	}
+
+	if _, err := file.Seek(t.offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	text := string(data)
+
+	var records []models.IPmsgRequest
+	if t.offset == 0 {
+		if t.version, err = DetectVersion(strings.NewReader(text)); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		records, err = Parse(strings.NewReader(text))
+	} else {
+		records, err = parseChunk(t.version, text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	t.offset += int64(len(data))
+
+	if restarted && t.last != nil {
+		for i := len(records) - 1; i >= 0; i-- {
+			if sameRecord(&records[i], t.last) {
+				records = records[i+1:]
+				break
+			}
+		}
+	}
+
+	if len(records) > 0 {
+		last := records[len(records)-1]
+		t.last = &last
+	}
+
+	return records, nil
+}
+
+// Follow calls fn with new records every interval until ctx is done, errors go to onErr and
+// reading goes on, onErr may be nil
+func (t *Tail) Follow(ctx context.Context, interval time.Duration, fn func([]models.IPmsgRequest), onErr func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		records, err := t.Next()
+		if err != nil && onErr != nil {
+			onErr(err)
+		}
+		if len(records) > 0 {
+			fn(records)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+/* ======== internal ======== */
+
+// parseChunk parses records appended after header of file
+func parseChunk(version int, text string) ([]models.IPmsgRequest, error) {
+	lines := strings.Split(text, "\n")
+
+	if version == V2 {
+		return parseV2(lines)
+	}
+
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+	}
+	return parseV1(lines)
+}
+
+func sameRecord(a, b *models.IPmsgRequest) bool {
+	return a.Date == b.Date && a.From == b.From && a.Msg == b.Msg
+}
@@ -0,0 +1,129 @@
+// package for identity key of this machine, messages are signed with ed25519 key, so peer
+// can tell message from known contact after contact's address changes. Key is kept in file
+// as base64 seed, fingerprint is SHA256:<base64 of sha256 of public key>
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"ipmsg/pkg/models"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const FileName = "identity.key"
+
+var (
+	ErrUnsigned     error = errors.New("message is not signed")
+	ErrBadSignature error = errors.New("invalid message signature")
+)
+
+type Identity struct {
+	priv ed25519.PrivateKey
+}
+
+// Load reads identity key from path, missing key is generated and saved
+func Load(path string) (*Identity, error) {
+	const op = "identity.Load"
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		id, err := create(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		return id, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s: invalid key file %s", op, path)
+	}
+
+	return &Identity{priv: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+// Fingerprint returns fingerprint of own key
+func (id *Identity) Fingerprint() string {
+	return Fingerprint(id.priv.Public().(ed25519.PublicKey))
+}
+
+// Sign puts public key and signature into request, request must not be changed after it
+func (id *Identity) Sign(req *models.IPmsgRequest) {
+	req.Key = base64.StdEncoding.EncodeToString(id.priv.Public().(ed25519.PublicKey))
+	req.Sig = base64.StdEncoding.EncodeToString(ed25519.Sign(id.priv, req.Payload()))
+}
+
+// Verify checks signature of request, returns fingerprint of key which signed it
+func Verify(req *models.IPmsgRequest) (string, error) {
+	if req.Key == "" || req.Sig == "" {
+		return "", ErrUnsigned
+	}
+
+	pub, err := base64.StdEncoding.DecodeString(req.Key)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return "", ErrBadSignature
+	}
+	sig, err := base64.StdEncoding.DecodeString(req.Sig)
+	if err != nil {
+		return "", ErrBadSignature
+	}
+
+	if !ed25519.Verify(pub, req.Payload(), sig) {
+		return "", ErrBadSignature
+	}
+
+	return Fingerprint(pub), nil
+}
+
+func Fingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+/* ======== internal ======== */
+
+// create generates key and writes it, key written by other process first wins
+func create(path string) (*Identity, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".identity-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(base64.StdEncoding.EncodeToString(priv.Seed()) + "\n"); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	// link does not replace existing file, unlike rename
+	err = os.Link(tmp.Name(), path)
+	if errors.Is(err, os.ErrExist) {
+		return Load(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{priv: priv}, nil
+}
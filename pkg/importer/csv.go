@@ -0,0 +1,137 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"ipmsg/pkg/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// columns of csv file by header names, file written by ipmsg export is read back as is
+var csvColumns = map[string][]string{
+	"date":      {"date", "time", "timestamp", "datetime"},
+	"direction": {"direction"},
+	"from":      {"from", "sender", "address"},
+	"alias":     {"alias", "name", "nick"},
+	"to":        {"to", "recipient", "recipients"},
+	"msg":       {"message", "msg", "text", "body"},
+}
+
+var csvDateLayouts = []string{
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006/01/02 15:04:05",
+	"2006-01-02 15:04",
+	"02.01.2006 15:04:05",
+}
+
+func parseCSV(text string) ([]models.Message, error) {
+	r := csv.NewReader(strings.NewReader(text))
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	col := map[string]int{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		for field, names := range csvColumns {
+			for _, name := range names {
+				if h == name {
+					if _, ok := col[field]; !ok {
+						col[field] = i
+					}
+				}
+			}
+		}
+	}
+	for _, field := range []string{"date", "msg"} {
+		if _, ok := col[field]; !ok {
+			return nil, fmt.Errorf("csv header has no %s column", field)
+		}
+	}
+	if _, ok := col["from"]; !ok {
+		if _, ok := col["alias"]; !ok {
+			return nil, errors.New("csv header has no from or alias column")
+		}
+	}
+
+	var msgs []models.Message
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		get := func(field string) string {
+			i, ok := col[field]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return unescapeCell(record[i])
+		}
+
+		date, err := parseCSVDate(get("date"))
+		if err != nil {
+			line, _ := r.FieldPos(col["date"])
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		m := models.Message{
+			Direction: models.DirectionIn,
+			From:      get("from"),
+			Alias:     get("alias"),
+			Date:      date,
+			Msg:       get("msg"),
+		}
+		if m.From == "" {
+			m.From = m.Alias
+		}
+		if get("direction") == models.DirectionOut {
+			m.Direction = models.DirectionOut
+			for _, to := range strings.Split(get("to"), ",") {
+				if _, addr := parsePeer(to); addr != "" {
+					m.To = append(m.To, addr)
+				}
+			}
+		}
+
+		msgs = append(msgs, m)
+	}
+
+	return msgs, nil
+}
+
+func parseCSVDate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+
+	for _, layout := range csvDateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t.Unix(), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown date %q", s)
+}
+
+// unescapeCell removes quote put by export before cells looking like formula
+func unescapeCell(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
@@ -0,0 +1,97 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const (
+	EncodingUTF8     = "utf-8"
+	EncodingUTF16LE  = "utf-16le"
+	EncodingUTF16BE  = "utf-16be"
+	EncodingShiftJIS = "shift_jis"
+	EncodingCP1252   = "windows-1252"
+)
+
+// DetectEncoding guesses encoding of log: byte order mark, valid utf-8, shift_jis written
+// by japanese IP Messenger, otherwise windows-1252
+func DetectEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return EncodingUTF8
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return EncodingUTF16BE
+	case utf8.Valid(data):
+		return EncodingUTF8
+	case isShiftJIS(data):
+		return EncodingShiftJIS
+	}
+	return EncodingCP1252
+}
+
+// Decode converts data in enc to string, enc is any name known to browsers like cp1251 or euc-jp
+func Decode(data []byte, enc string) (string, error) {
+	var e encoding.Encoding
+	switch strings.ToLower(enc) {
+	case EncodingUTF8, "utf8":
+		return string(bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF})), nil
+	case EncodingUTF16LE:
+		e = unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+	case EncodingUTF16BE:
+		e = unicode.UTF16(unicode.BigEndian, unicode.UseBOM)
+	case EncodingShiftJIS, "sjis", "cp932":
+		e = japanese.ShiftJIS
+	case EncodingCP1252, "cp1252":
+		e = charmap.Windows1252
+	default:
+		var err error
+		if e, err = htmlindex.Get(enc); err != nil {
+			return "", fmt.Errorf("importer.Decode: unknown encoding %q", enc)
+		}
+	}
+
+	res, err := e.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", fmt.Errorf("importer.Decode: %w", err)
+	}
+
+	return string(res), nil
+}
+
+/* ======== internal ======== */
+
+// isShiftJIS checks that every byte above ascii is part of valid two byte character or half width kana
+func isShiftJIS(data []byte) bool {
+	double := 0
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+		switch {
+		case b < 0x80:
+		case b >= 0xA1 && b <= 0xDF:
+			// half width katakana
+		case (b >= 0x81 && b <= 0x9F) || (b >= 0xE0 && b <= 0xFC):
+			if i+1 >= len(data) {
+				return false
+			}
+			t := data[i+1]
+			if t < 0x40 || t > 0xFC || t == 0x7F {
+				return false
+			}
+			i++
+			double++
+		default:
+			return false
+		}
+	}
+	return double > 0
+}
@@ -0,0 +1,169 @@
+// package for importing history of classic IP Messenger (ipmsg.log), mbox and csv files
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"ipmsg/pkg/models"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatIPMsgLog = "ipmsglog"
+	FormatMbox     = "mbox"
+	FormatCSV      = "csv"
+)
+
+var (
+	ErrUnknownFormat error = errors.New("unknown import format, expected ipmsglog, mbox or csv")
+)
+
+type Options struct {
+	Encoding string   // encoding of file, detected when empty
+	Me       []string // own addresses, mbox messages from them are outgoing
+}
+
+// DetectFormat guesses format by file name and first lines of text
+func DetectFormat(path, text string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV
+	case ".mbox", ".mbx":
+		return FormatMbox
+	}
+
+	head := text[:min(len(text), 4096)]
+	switch {
+	case strings.HasPrefix(head, "From "):
+		return FormatMbox
+	case strings.Contains(head, "\n From: "), strings.Contains(head, "\n To: "):
+		return FormatIPMsgLog
+	}
+
+	return ""
+}
+
+// Parse decodes data and parses messages of format, format is detected when empty
+func Parse(path string, data []byte, format string, opt Options) ([]models.Message, error) {
+	const op = "importer.Parse"
+
+	enc := opt.Encoding
+	if enc == "" {
+		enc = DetectEncoding(data)
+	}
+	text, err := Decode(data, enc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	if format == "" {
+		format = DetectFormat(path, text)
+	}
+
+	var msgs []models.Message
+	switch format {
+	case FormatIPMsgLog:
+		msgs, err = parseIPMsgLog(text)
+	case FormatMbox:
+		msgs, err = parseMbox(text, opt.Me)
+	case FormatCSV:
+		msgs, err = parseCSV(text)
+	default:
+		return nil, fmt.Errorf("%s: %s: %w", op, path, ErrUnknownFormat)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for i := range msgs {
+		m := &msgs[i]
+		m.ID = models.NewID()
+		m.Received = time.Unix(m.Date, 0)
+		m.Len = len(m.Msg)
+		if m.Direction == "" {
+			m.Direction = models.DirectionIn
+		}
+	}
+
+	return msgs, nil
+}
+
+// MapAliases puts senders and recipients on aliases of alias file, names is address - alias map
+// and addresses is alias - current address map, names used in other tool become addresses when
+// alias file knows them
+func MapAliases(msgs []models.Message, names, addresses map[string]string) {
+	for i := range msgs {
+		m := &msgs[i]
+
+		if !isAddress(m.From) {
+			if addr, ok := addresses[m.From]; ok && isAddress(addr) {
+				m.From = addr
+			} else if addr, ok := addresses[m.Alias]; ok && isAddress(addr) {
+				m.From = addr
+			}
+		}
+		if name, ok := names[m.From]; ok && isAddress(m.From) {
+			m.Alias = name
+		}
+
+		for j, to := range m.To {
+			if addr, ok := addresses[to]; ok && !isAddress(to) && isAddress(addr) {
+				m.To[j] = addr
+			}
+		}
+	}
+}
+
+// Unseen returns imported messages missing in history, repeated imported messages are dropped too
+func Unseen(history, imported []models.Message) []models.Message {
+	seen := map[string]bool{}
+	for i := range history {
+		seen[Key(&history[i])] = true
+	}
+
+	var res []models.Message
+	for _, m := range imported {
+		key := Key(&m)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		res = append(res, m)
+	}
+
+	return res
+}
+
+// Merge returns messages of a and b ordered by date, messages of same date keep their order
+func Merge(a, b []models.Message) []models.Message {
+	res := make([]models.Message, 0, len(a)+len(b))
+	res = append(res, a...)
+	res = append(res, b...)
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Date < res[j].Date })
+	return res
+}
+
+// Key identifies message by date, peer and text, whitespace at the end of text is ignored
+func Key(m *models.Message) string {
+	peer := m.From
+	if m.Direction == models.DirectionOut {
+		peer = strings.Join(m.To, ",")
+	}
+
+	text := strings.ReplaceAll(m.Msg, "\r\n", "\n")
+	text = strings.TrimRight(text, " \t\n")
+
+	return strconv.FormatInt(m.Date, 10) + "\x00" + strings.ToLower(peer) + "\x00" + text
+}
+
+/* ======== internal ======== */
+
+func isAddress(s string) bool {
+	return net.ParseIP(s) != nil
+}
@@ -0,0 +1,126 @@
+package importer
+
+import (
+	"fmt"
+	"ipmsg/pkg/models"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// classic IP Messenger log entry:
+//
+//	=====================================
+//	 From: alex (WORKGROUP/ALEX-PC/192.168.1.5)
+//	  at Tue Mar 05 12:34:56 2013 (Opened)
+//	-------------------------------------
+//	message text
+//
+// sent messages have one " To: " line per recipient
+var numericDate = regexp.MustCompile(`(\d{4})/(\d{1,2})/(\d{1,2})\D+(\d{1,2}):(\d{2}):(\d{2})`)
+
+func parseIPMsgLog(text string) ([]models.Message, error) {
+	lines := strings.Split(text, "\n")
+
+	var msgs []models.Message
+	for i := 0; i < len(lines); i++ {
+		if !isEntryStart(lines, i) {
+			continue
+		}
+
+		var m models.Message
+		i++
+
+		// header until dashes
+		for ; i < len(lines) && !isRule(lines[i], '-'); i++ {
+			line := strings.TrimSpace(lines[i])
+			switch {
+			case strings.HasPrefix(line, "From:"):
+				m.Direction = models.DirectionIn
+				m.Alias, m.From = parsePeer(strings.TrimPrefix(line, "From:"))
+			case strings.HasPrefix(line, "To:"):
+				m.Direction = models.DirectionOut
+				_, to := parsePeer(strings.TrimPrefix(line, "To:"))
+				m.To = append(m.To, to)
+			case strings.HasPrefix(line, "at "):
+				date, err := parseLogDate(strings.TrimPrefix(line, "at "))
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", i+1, err)
+				}
+				m.Date = date
+			}
+		}
+
+		// body until next entry
+		var body []string
+		for i+1 < len(lines) && !isEntryStart(lines, i+1) {
+			i++
+			body = append(body, lines[i])
+		}
+
+		if m.Direction == "" || m.Date == 0 {
+			continue
+		}
+
+		m.Msg = strings.TrimRight(strings.Join(body, "\n"), "\n ")
+		msgs = append(msgs, m)
+	}
+
+	return msgs, nil
+}
+
+// isEntryStart finds line of "=" followed by From or To line, so such line inside message is kept
+func isEntryStart(lines []string, i int) bool {
+	if !isRule(lines[i], '=') || i+1 >= len(lines) {
+		return false
+	}
+	next := strings.TrimSpace(lines[i+1])
+	return strings.HasPrefix(next, "From:") || strings.HasPrefix(next, "To:")
+}
+
+func isRule(line string, c byte) bool {
+	line = strings.TrimSpace(line)
+	return len(line) >= 10 && strings.Trim(line, string(c)) == ""
+}
+
+// parsePeer parses "alex (WORKGROUP/ALEX-PC/192.168.1.5)", address is last part of host info
+// which looks like address, name is returned as address when there is no host info
+func parsePeer(s string) (name, addr string) {
+	s = strings.TrimSpace(s)
+
+	open := strings.LastIndex(s, "(")
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return "", s
+	}
+
+	name = strings.TrimSpace(s[:open])
+	parts := strings.Split(s[open+1:len(s)-1], "/")
+	addr = strings.TrimSpace(parts[len(parts)-1])
+	for _, p := range parts {
+		if isAddress(strings.TrimSpace(p)) {
+			addr = strings.TrimSpace(p)
+		}
+	}
+
+	return name, addr
+}
+
+// parseLogDate parses "Tue Mar 05 12:34:56 2013 (Opened)" or "2013/03/05(Tue) 12:34:56" in local time
+func parseLogDate(s string) (int64, error) {
+	fields := strings.Fields(s)
+	if len(fields) >= 5 {
+		t, err := time.ParseInLocation("Mon Jan 2 15:04:05 2006", strings.Join(fields[:5], " "), time.Local)
+		if err == nil {
+			return t.Unix(), nil
+		}
+	}
+
+	if p := numericDate.FindStringSubmatch(s); p != nil {
+		t, err := time.ParseInLocation("2006/1/2 15:4:05", fmt.Sprintf("%s/%s/%s %s:%s:%s", p[1], p[2], p[3], p[4], p[5], p[6]), time.Local)
+		if err == nil {
+			return t.Unix(), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown date %q", s)
+}
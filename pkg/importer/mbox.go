@@ -0,0 +1,149 @@
+package importer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"ipmsg/pkg/models"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// lines like >From or >>From are escaped From lines of message body (mboxrd)
+var escapedFrom = regexp.MustCompile(`(?m)^>(>*From )`)
+
+// parseMbox reads text/plain part of every mail, messages from me are outgoing
+func parseMbox(text string, me []string) ([]models.Message, error) {
+	var msgs []models.Message
+
+	for n, raw := range splitMbox(text) {
+		mm, err := mail.ReadMessage(strings.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("mail %d: %w", n+1, err)
+		}
+
+		date, err := mm.Header.Date()
+		if err != nil {
+			return nil, fmt.Errorf("mail %d: %w", n+1, err)
+		}
+
+		body, err := plainText(mm.Header.Get("Content-Type"), mm.Header.Get("Content-Transfer-Encoding"), mm.Body)
+		if err != nil {
+			return nil, fmt.Errorf("mail %d: %w", n+1, err)
+		}
+
+		m := models.Message{
+			Direction: models.DirectionIn,
+			Date:      date.Unix(),
+			Msg:       strings.TrimRight(escapedFrom.ReplaceAllString(body, "$1"), "\n "),
+		}
+
+		if from, err := mail.ParseAddress(mm.Header.Get("From")); err == nil {
+			m.From, m.Alias = from.Address, from.Name
+		} else {
+			m.From = mm.Header.Get("From")
+		}
+
+		if to, err := mm.Header.AddressList("To"); err == nil {
+			for _, a := range to {
+				m.To = append(m.To, a.Address)
+			}
+		}
+
+		for _, addr := range me {
+			if strings.EqualFold(addr, m.From) {
+				m.Direction = models.DirectionOut
+			}
+		}
+		if m.Direction == models.DirectionIn {
+			// recipient of received mail is us
+			m.To = nil
+		}
+
+		msgs = append(msgs, m)
+	}
+
+	return msgs, nil
+}
+
+// splitMbox splits mailbox by "From " lines, From_ lines themselves are dropped
+func splitMbox(text string) []string {
+	var res []string
+	var cur *strings.Builder
+
+	prevEmpty := true
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if prevEmpty && strings.HasPrefix(line, "From ") {
+			if cur != nil {
+				res = append(res, cur.String())
+			}
+			cur = &strings.Builder{}
+			prevEmpty = false
+			continue
+		}
+
+		prevEmpty = strings.TrimRight(line, "\n") == ""
+		if cur != nil {
+			cur.WriteString(line)
+		}
+	}
+	if cur != nil {
+		res = append(res, cur.String())
+	}
+
+	return res
+}
+
+// plainText returns first text/plain part of body decoded to utf-8
+func plainText(contentType, transfer string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return "", nil
+			}
+			if err != nil {
+				return "", err
+			}
+
+			pt := part.Header.Get("Content-Type")
+			if pt == "" || strings.HasPrefix(pt, "text/plain") || strings.HasPrefix(pt, "multipart/") {
+				// multipart reader decodes quoted-printable itself
+				return plainText(pt, part.Header.Get("Content-Transfer-Encoding"), part)
+			}
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(transfer)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body) // line breaks are skipped by decoder
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+
+	charset := params["charset"]
+	if charset == "" {
+		charset = EncodingUTF8
+	}
+	text, err := Decode(data, charset)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ReplaceAll(text, "\r\n", "\n"), nil
+}
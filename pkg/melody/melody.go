@@ -0,0 +1,169 @@
+// package for notification melodies: text notation, synthesis to PCM and wav files
+//
+// Melody is list of space separated tokens:
+//
+//	E6:100    note E of 6th octave for 100ms (duration is optional, 100ms by default)
+//	F#5:50    sharp and flat notes are written as F#5 and Gb5
+//	880:200   raw frequency in Hz
+//	R:50      rest (silence)
+//	v0.5      volume from 0 to 1 for next notes (0.3 by default)
+package melody
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SampleRate = 44100 // rate used by audio context in beep package
+
+	defaultVolume   = 0.3
+	defaultDuration = 100 * time.Millisecond
+	fade            = 5 * time.Millisecond // fade in and out of every note, removes clicks
+)
+
+// Default is melody played by ipmsg since first versions
+const Default = "E6:100 F6:50 G6:50 F6:50 E6:100"
+
+var (
+	ErrEmpty error = errors.New("melody is empty")
+)
+
+type Note struct {
+	Freq     float64 // zero for rest
+	Duration time.Duration
+	Volume   float64
+}
+
+type Melody []Note
+
+var semitones = map[byte]int{'C': -9, 'D': -7, 'E': -5, 'F': -4, 'G': -2, 'A': 0, 'B': 2}
+
+// Parse parses melody notation
+func Parse(s string) (Melody, error) {
+	const op = "melody.Parse"
+
+	var res Melody
+	volume := defaultVolume
+
+	for _, tok := range strings.Fields(s) {
+		if v, ok := strings.CutPrefix(tok, "v"); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 || f > 1 {
+				return nil, fmt.Errorf("%s: invalid volume %q", op, tok)
+			}
+			volume = f
+			continue
+		}
+
+		pitch, dur, hasDur := strings.Cut(tok, ":")
+
+		note := Note{Duration: defaultDuration, Volume: volume}
+		if hasDur {
+			ms, err := strconv.Atoi(dur)
+			if err != nil || ms <= 0 {
+				return nil, fmt.Errorf("%s: invalid duration %q", op, tok)
+			}
+			note.Duration = time.Duration(ms) * time.Millisecond
+		}
+
+		freq, err := frequency(pitch)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		note.Freq = freq
+
+		res = append(res, note)
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("%s: %w", op, ErrEmpty)
+	}
+
+	return res, nil
+}
+
+// Duration returns length of melody
+func (m Melody) Duration() time.Duration {
+	var d time.Duration
+	for _, n := range m {
+		d += n.Duration
+	}
+	return d
+}
+
+// PCM renders melody to signed 16 bit little endian mono samples
+func (m Melody) PCM(sampleRate int) []byte {
+	total := int(float64(sampleRate) * m.Duration().Seconds())
+	res := make([]byte, 0, total*2)
+
+	fadeSamples := int(float64(sampleRate) * fade.Seconds())
+
+	for _, n := range m {
+		samples := int(float64(sampleRate) * n.Duration.Seconds())
+
+		for i := 0; i < samples; i++ {
+			var v int16
+			if n.Freq > 0 {
+				amp := n.Volume
+				if i < fadeSamples {
+					amp *= float64(i) / float64(fadeSamples)
+				} else if samples-i < fadeSamples {
+					amp *= float64(samples-i) / float64(fadeSamples)
+				}
+
+				v = int16(amp * 32767 * math.Sin(2*math.Pi*n.Freq*float64(i)/float64(sampleRate)))
+			}
+
+			res = append(res, byte(v), byte(v>>8))
+		}
+	}
+
+	return res
+}
+
+// frequency turns note name (A4, C#5, Bb3), R or number into Hz
+func frequency(pitch string) (float64, error) {
+	if pitch == "R" || pitch == "r" {
+		return 0, nil
+	}
+
+	if f, err := strconv.ParseFloat(pitch, 64); err == nil {
+		if f <= 0 || f > 20000 {
+			return 0, fmt.Errorf("frequency %q is out of range", pitch)
+		}
+		return f, nil
+	}
+
+	if len(pitch) < 2 {
+		return 0, fmt.Errorf("invalid note %q", pitch)
+	}
+
+	semitone, ok := semitones[pitch[0]&^0x20] // upper case
+	if !ok {
+		return 0, fmt.Errorf("invalid note %q", pitch)
+	}
+
+	rest := pitch[1:]
+	switch rest[0] {
+	case '#':
+		semitone++
+		rest = rest[1:]
+	case 'b':
+		semitone--
+		rest = rest[1:]
+	}
+
+	octave, err := strconv.Atoi(rest)
+	if err != nil || octave < 0 || octave > 9 {
+		return 0, fmt.Errorf("invalid octave in note %q", pitch)
+	}
+
+	// A4 is 440Hz
+	n := semitone + (octave-4)*12
+	return 440 * math.Pow(2, float64(n)/12), nil
+}
@@ -0,0 +1,174 @@
+package melody
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	ErrUnsupportedWAV error = errors.New("only 16 bit PCM wav files are supported")
+)
+
+// Sound is signed 16 bit little endian PCM
+type Sound struct {
+	SampleRate int
+	Channels   int
+	PCM        []byte
+}
+
+// Load returns mono sound with SampleRate, spec is path to .wav file or melody notation
+func Load(spec string) (*Sound, error) {
+	if strings.HasSuffix(strings.ToLower(spec), ".wav") {
+		f, err := os.Open(spec)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		s, err := ReadWAV(f)
+		if err != nil {
+			return nil, err
+		}
+		return s.Convert(SampleRate), nil
+	}
+
+	m, err := Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sound{SampleRate: SampleRate, Channels: 1, PCM: m.PCM(SampleRate)}, nil
+}
+
+// WriteWAV writes sound as RIFF wav file
+func WriteWAV(w io.Writer, s *Sound) error {
+	const bitsPerSample = 16
+
+	blockAlign := s.Channels * bitsPerSample / 8
+
+	var hdr bytes.Buffer
+	hdr.WriteString("RIFF")
+	binary.Write(&hdr, binary.LittleEndian, uint32(36+len(s.PCM)))
+	hdr.WriteString("WAVE")
+
+	hdr.WriteString("fmt ")
+	binary.Write(&hdr, binary.LittleEndian, uint32(16))
+	binary.Write(&hdr, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&hdr, binary.LittleEndian, uint16(s.Channels))
+	binary.Write(&hdr, binary.LittleEndian, uint32(s.SampleRate))
+	binary.Write(&hdr, binary.LittleEndian, uint32(s.SampleRate*blockAlign))
+	binary.Write(&hdr, binary.LittleEndian, uint16(blockAlign))
+	binary.Write(&hdr, binary.LittleEndian, uint16(bitsPerSample))
+
+	hdr.WriteString("data")
+	binary.Write(&hdr, binary.LittleEndian, uint32(len(s.PCM)))
+
+	if _, err := w.Write(hdr.Bytes()); err != nil {
+		return err
+	}
+
+	_, err := w.Write(s.PCM)
+	return err
+}
+
+// ReadWAV reads 16 bit PCM wav file
+func ReadWAV(r io.Reader) (*Sound, error) {
+	const op = "melody.ReadWAV"
+
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("%s: not a wav file", op)
+	}
+
+	var s Sound
+	hasFmt := false
+
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, fmt.Errorf("%s: no data chunk: %w", op, err)
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch id {
+		case "fmt ":
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil || size < 16 {
+				return nil, fmt.Errorf("%s: invalid fmt chunk", op)
+			}
+
+			format := binary.LittleEndian.Uint16(data[0:2])
+			s.Channels = int(binary.LittleEndian.Uint16(data[2:4]))
+			s.SampleRate = int(binary.LittleEndian.Uint32(data[4:8]))
+			bits := binary.LittleEndian.Uint16(data[14:16])
+
+			if format != 1 || bits != 16 || s.Channels < 1 || s.SampleRate <= 0 {
+				return nil, fmt.Errorf("%s: %w", op, ErrUnsupportedWAV)
+			}
+			hasFmt = true
+
+		case "data":
+			if !hasFmt {
+				return nil, fmt.Errorf("%s: data chunk before fmt chunk", op)
+			}
+
+			s.PCM = make([]byte, size)
+			if _, err := io.ReadFull(r, s.PCM); err != nil {
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+			return &s, nil
+
+		default:
+			if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+		}
+
+		if size%2 == 1 && id == "fmt " {
+			io.CopyN(io.Discard, r, 1)
+		}
+	}
+}
+
+// Convert mixes sound down to mono and resamples it to rate (linear interpolation)
+func (s *Sound) Convert(rate int) *Sound {
+	frames := len(s.PCM) / (2 * s.Channels)
+
+	mono := make([]float64, frames)
+	for i := 0; i < frames; i++ {
+		var sum float64
+		for c := 0; c < s.Channels; c++ {
+			off := (i*s.Channels + c) * 2
+			sum += float64(int16(binary.LittleEndian.Uint16(s.PCM[off:])))
+		}
+		mono[i] = sum / float64(s.Channels)
+	}
+
+	outFrames := frames
+	if rate != s.SampleRate && frames > 0 {
+		outFrames = int(int64(frames) * int64(rate) / int64(s.SampleRate))
+	}
+
+	res := &Sound{SampleRate: rate, Channels: 1, PCM: make([]byte, outFrames*2)}
+	for i := 0; i < outFrames; i++ {
+		pos := float64(i) * float64(s.SampleRate) / float64(rate)
+		j := int(pos)
+		v := mono[min(j, frames-1)]
+		if j+1 < frames {
+			v += (mono[j+1] - v) * (pos - float64(j))
+		}
+
+		binary.LittleEndian.PutUint16(res.PCM[i*2:], uint16(int16(v)))
+	}
+
+	return res
+}
@@ -0,0 +1,98 @@
+package models
+
+import "time"
+
+// types shared by daemon local api and its clients
+
+// Delivery is result of sending message to one host
+type Delivery struct {
+	To        string `json:"to"`
+	Delivered bool   `json:"delivered"`
+	Status    string `json:"status,omitempty"` // sent, queued or failed
+	QueuedID  string `json:"queued_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type BroadcastResult struct {
+	Sent       int        `json:"sent"`
+	Queued     int        `json:"queued"`
+	Deliveries []Delivery `json:"deliveries"`
+}
+
+type AliasEntry struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+type Peer struct {
+	Host     string    `json:"host"`
+	Alias    string    `json:"alias,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+const (
+	EventReceived = "received" // message saved by server
+	EventSent     = "sent"     // message passed to delivery
+	EventAcked    = "acked"    // recipient confirmed message
+	EventFailed   = "failed"   // delivery failed, message may be queued in outbox
+	EventRead     = "read"     // messages were marked read, unread counters changed
+	EventOutgoing = "outgoing" // sent message saved to history with result of every recipient
+)
+
+type Event struct {
+	ID       uint64       `json:"id"`
+	Kind     string       `json:"kind"`
+	Time     time.Time    `json:"time"`
+	To       string       `json:"to,omitempty"`       // recipient of outgoing message
+	Observed string       `json:"observed,omitempty"` // address received message came from, its from is only claimed
+	Signer   string       `json:"signer,omitempty"`   // fingerprint of key which signed received message
+	Flags    []string     `json:"flags,omitempty"`    // flags of received message (example: from_mismatch)
+	Error    string       `json:"error,omitempty"`
+	Message  IPmsgRequest `json:"message"`
+}
+
+// Sender returns address of received message, observed one if it is known
+func (e *Event) Sender() string {
+	if e.Observed != "" {
+		return e.Observed
+	}
+	return e.Message.From
+}
+
+type SearchResult struct {
+	Message    Message   `json:"message"`
+	Snippet    string    `json:"snippet"`
+	Highlights [][2]int  `json:"highlights,omitempty"` // byte ranges of matches in snippet
+	Before     []Message `json:"before,omitempty"`     // context from same sender
+	After      []Message `json:"after,omitempty"`
+}
+
+type RotateResult struct {
+	Archived int `json:"archived"`
+	Deleted  int `json:"deleted"`
+}
+
+type UnreadSender struct {
+	From  string    `json:"from"`
+	Alias string    `json:"alias,omitempty"`
+	Count int       `json:"count"`
+	Last  time.Time `json:"last"` // when newest unread message was received
+}
+
+type Unread struct {
+	Total    int            `json:"total"`
+	Senders  []UnreadSender `json:"senders"`
+	Messages []Message      `json:"messages,omitempty"`
+}
+
+// MarkRead selects unread messages to mark read, empty request marks nothing
+type MarkRead struct {
+	IDs  []string `json:"ids,omitempty"`
+	From string   `json:"from,omitempty"` // alias or address
+	All  bool     `json:"all,omitempty"`
+}
+
+// WebLogin is one-time url which logs browser into web ui
+type WebLogin struct {
+	URL string `json:"url"`
+}
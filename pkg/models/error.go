@@ -1,10 +1,14 @@
 package models
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type IPResponse struct {
 	Succes bool
 	Error *string
+	Data  string // answer to requests other than message, single line
 }
 
 func (ier *IPResponse) DecodeToString() string {
@@ -12,7 +16,38 @@ func (ier *IPResponse) DecodeToString() string {
 
 	if ier.Error != nil {
 		res += fmt.Sprintf("error:%s", *ier.Error)
+	} else if ier.Data != "" {
+		res += fmt.Sprintf("data:%s", ier.Data)
 	}
 
 	return res
-}
\ No newline at end of file
+}
+
+// ParseResponse parses server answer written by IPResponse.DecodeToString
+func ParseResponse(s string) (*IPResponse, error) {
+	var res IPResponse
+
+	lines := strings.SplitN(s, "\n", 3)
+	if len(lines) < 2 || lines[0] != "ipmsg" {
+		return nil, fmt.Errorf("invalid response format")
+	}
+
+	switch lines[1] {
+	case "succes:true":
+		res.Succes = true
+	case "succes:false":
+		res.Succes = false
+	default:
+		return nil, fmt.Errorf("invalid response format")
+	}
+
+	if len(lines) == 3 {
+		if e, ok := strings.CutPrefix(lines[2], "error:"); ok {
+			res.Error = &e
+		} else if d, ok := strings.CutPrefix(lines[2], "data:"); ok {
+			res.Data = d
+		}
+	}
+
+	return &res, nil
+}
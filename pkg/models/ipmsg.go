@@ -1,10 +1,132 @@
 package models
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 
 type IPmsgRequest struct {
-	From  string
-	Len   int 
-	Date  int64
-	Msg   string
-	Alias string
-}
\ No newline at end of file
+	Kind  string `json:"kind,omitempty"` // empty for message
+	From  string `json:"from"`
+	Len   int    `json:"len"`
+	Date  int64  `json:"date"`
+	Msg   string `json:"msg"`
+	Alias string `json:"alias"`
+
+	Priority string `json:"priority,omitempty"` // low, normal (empty) or high
+
+	// ed25519 public key of sender and signature of Payload, both base64, empty if not signed
+	Key string `json:"key,omitempty"`
+	Sig string `json:"sig,omitempty"`
+
+	// results of sent message read from history, they are not part of wire format
+	Deliveries []Delivery `json:"deliveries,omitempty"`
+}
+
+// kinds of requests other than message, they start with ipmsg:<kind> line,
+// so old servers refuse them instead of saving them as empty message
+const (
+	KindContacts = "contacts" // asks for contacts peer publishes, answer has them in data
+)
+
+const (
+	PriorityLow    = "low"
+	PriorityNormal = ""
+	PriorityHigh   = "high"
+)
+
+// Encode returns request in wire format, terminated with \x00.
+// Optional headers go after alias, old servers ignore them
+func (r *IPmsgRequest) Encode() string {
+	var opt strings.Builder
+	if r.Priority != "" {
+		fmt.Fprintf(&opt, "\npriority:%s", r.Priority)
+	}
+	if r.Sig != "" {
+		fmt.Fprintf(&opt, "\nkey:%s\nsig:%s", r.Key, r.Sig)
+	}
+
+	return fmt.Sprintf(
+		"%s\nfrom:%s\nlen:%d\ndate:%d\nalias:%s%s\nmsg:%s\x00",
+		r.magic(),
+		r.From,
+		r.Len,
+		r.Date,
+		r.Alias,
+		opt.String(),
+		r.Msg,
+	)
+}
+
+// Payload returns fields covered by signature, claimed address is among them,
+// so signed message can not be replayed from other address
+func (r *IPmsgRequest) Payload() []byte {
+	return fmt.Appendf(nil, "%s\n%s\n%d\n%d\n%s\n%s\n%s", r.magic(), r.From, r.Len, r.Date, r.Alias, r.Priority, r.Msg)
+}
+
+// ParseRequest parses request written by Encode (without trailing \x00),
+// unknown headers are skipped
+func ParseRequest(req string) (*IPmsgRequest, error) {
+	var res IPmsgRequest
+
+	header, msg, found := strings.Cut(req, "\nmsg:")
+	if !found {
+		return nil, fmt.Errorf("invalid request format")
+	}
+	res.Msg = msg
+
+	lines := strings.Split(header, "\n")
+	if kind, ok := strings.CutPrefix(lines[0], "ipmsg:"); ok && kind != "" {
+		res.Kind = kind
+	} else if lines[0] != "ipmsg" {
+		return nil, fmt.Errorf("invalid request format")
+	}
+
+	var hasFrom, hasLen, hasDate bool
+	for _, line := range lines[1:] {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header line %q", line)
+		}
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch key {
+		case "from":
+			res.From, hasFrom = value, true
+		case "len":
+			res.Len, err = strconv.Atoi(value)
+			hasLen = true
+		case "date":
+			res.Date, err = strconv.ParseInt(value, 10, 64)
+			hasDate = true
+		case "alias":
+			res.Alias = value
+		case "priority":
+			res.Priority = value
+		case "key":
+			res.Key = value
+		case "sig":
+			res.Sig = value
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header: %w", key, err)
+		}
+	}
+
+	if !hasFrom || !hasLen || !hasDate || res.From == "" {
+		return nil, fmt.Errorf("from, len and date headers are required")
+	}
+
+	return &res, nil
+}
+
+// magic is first line of request
+func (r *IPmsgRequest) magic() string {
+	if r.Kind == "" {
+		return "ipmsg"
+	}
+	return "ipmsg:" + r.Kind
+}
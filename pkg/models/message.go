@@ -0,0 +1,193 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+// statuses of sent message and of its delivery to one host
+const (
+	StatusSent    = "sent"
+	StatusQueued  = "queued" // saved to outbox, server resends it
+	StatusFailed  = "failed"
+	StatusPartial = "partial" // some recipients got message, others did not
+)
+
+const (
+	// FlagFromMismatch marks message whose claimed sender differs from connection address
+	FlagFromMismatch = "from_mismatch"
+)
+
+// Message is stored record of received or sent message
+type Message struct {
+	ID         string     `json:"id"`
+	Direction  string     `json:"direction"`
+	From       string     `json:"from"`               // claimed sender address
+	Observed   string     `json:"observed,omitempty"` // address message came from
+	Alias      string     `json:"alias,omitempty"`
+	To         []string   `json:"to,omitempty"`
+	Deliveries []Delivery `json:"deliveries,omitempty"` // result for every recipient of sent message
+	Group      string     `json:"group,omitempty"`      // group message was sent to
+	Date       int64      `json:"date"`                 // sender time
+	Received   time.Time  `json:"received"`
+	Len        int        `json:"len"`
+	Msg        string     `json:"msg"`
+	Priority   string     `json:"priority,omitempty"`
+	Status     string     `json:"status,omitempty"`
+	Flags      []string   `json:"flags,omitempty"`
+	Signer     string     `json:"signer,omitempty"` // fingerprint of key which signed message
+}
+
+// NewMessage builds incoming message from request received from observed address
+func NewMessage(req *IPmsgRequest, observed string) *Message {
+	m := &Message{
+		ID:        NewID(),
+		Direction: DirectionIn,
+		From:      req.From,
+		Observed:  observed,
+		Alias:     req.Alias,
+		Date:      req.Date,
+		Received:  time.Now(),
+		Len:       req.Len,
+		Msg:       req.Msg,
+		Priority:  req.Priority,
+	}
+
+	if observed != "" && observed != req.From {
+		m.Flags = append(m.Flags, FlagFromMismatch)
+	}
+
+	return m
+}
+
+// NewOutgoing builds sent message from request and results of its delivery, id is SentID
+func NewOutgoing(req *IPmsgRequest, deliveries []Delivery) *Message {
+	m := &Message{
+		Direction:  DirectionOut,
+		From:       req.From,
+		Alias:      req.Alias,
+		Date:       req.Date,
+		Received:   time.Now(),
+		Len:        req.Len,
+		Msg:        req.Msg,
+		Priority:   req.Priority,
+		Deliveries: deliveries,
+		Status:     DeliveryStatus(deliveries),
+	}
+
+	for _, d := range deliveries {
+		m.To = append(m.To, d.To)
+	}
+	m.ID = SentID(req.Date, req.Msg, m.To)
+
+	return m
+}
+
+// SentID returns id of sent message, it is derived from content, so it is known before
+// message is saved and text table, which does not keep ids, gives the same one
+func SentID(date int64, msg string, to []string) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%d\x00\x00%s", date, msg)
+	for _, t := range to {
+		fmt.Fprintf(h, "\x00%s", t)
+	}
+	return "t" + hex.EncodeToString(h.Sum(nil))[:11]
+}
+
+// ReceivedID returns id derived from content of received message, text table which does not
+// keep ids gives it to received messages and read state keys messages by it in every store
+func ReceivedID(date int64, from, msg string) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%d\x00%s\x00%s", date, from, msg)
+	return "t" + hex.EncodeToString(h.Sum(nil))[:11]
+}
+
+// DeliveryStatus sums up results of all recipients
+func DeliveryStatus(deliveries []Delivery) string {
+	var sent, queued int
+	for _, d := range deliveries {
+		switch d.Status {
+		case StatusSent:
+			sent++
+		case StatusQueued:
+			queued++
+		}
+	}
+
+	switch {
+	case len(deliveries) == 0:
+		return ""
+	case sent == len(deliveries):
+		return StatusSent
+	case sent > 0:
+		return StatusPartial
+	case queued > 0:
+		return StatusQueued
+	default:
+		return StatusFailed
+	}
+}
+
+// SetDelivery replaces result of recipient d.To and sums up status again,
+// false means message was not sent to d.To
+func (m *Message) SetDelivery(d Delivery) bool {
+	for i := range m.Deliveries {
+		if strings.EqualFold(m.Deliveries[i].To, d.To) {
+			m.Deliveries[i] = d
+			m.Status = DeliveryStatus(m.Deliveries)
+			return true
+		}
+	}
+	return false
+}
+
+// Request returns message as it goes over the wire, sent message keeps its results
+func (m *Message) Request() IPmsgRequest {
+	return IPmsgRequest{
+		From:       m.From,
+		Len:        m.Len,
+		Date:       m.Date,
+		Msg:        m.Msg,
+		Alias:      m.Alias,
+		Priority:   m.Priority,
+		Deliveries: m.Deliveries,
+	}
+}
+
+func (m *Message) HasFlag(flag string) bool {
+	for _, f := range m.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// Involves reports whether peer (address or alias) is sender or recipient of message
+func (m *Message) Involves(peer string) bool {
+	if strings.EqualFold(peer, m.From) || (m.Alias != "" && strings.EqualFold(peer, m.Alias)) {
+		return true
+	}
+	for _, to := range m.To {
+		if strings.EqualFold(peer, to) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewID returns random id for messages and queued items
+func NewID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,132 @@
+// package for finding local address and ipmsg servers in local /24 network
+package netscan
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxHosts = 255
+	workers  = 50
+)
+
+// LocalIP returns first IPv4 address of up, non loopback and non VPN interface
+func LocalIP() (string, error) {
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+
+	for _, face := range interfaces {
+		if face.Flags&net.FlagUp == 0 ||
+			face.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		if IsVPNInterface(face.Name) {
+			continue
+		}
+
+		addrs, err := face.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			var ip net.IP
+
+			switch v := addr.(type) {
+			case *net.IPNet:
+				ip = v.IP
+			case *net.IPAddr:
+				ip = v.IP
+			}
+
+			if ip == nil ||
+				ip.IsLoopback() ||
+				ip.To4() == nil {
+				continue
+			}
+
+			return ip.String(), nil
+		}
+	}
+
+	return "", errors.New("no suitable local IP found")
+}
+
+func IsVPNInterface(name string) bool {
+	return strings.HasPrefix(name, "tun") ||
+		strings.HasPrefix(name, "tap") ||
+		strings.HasPrefix(name, "wg") ||
+		strings.HasPrefix(name, "ppp")
+}
+
+// Scan checks every host of localIP /24 network for open port,
+// found is called for each responding host (may be nil)
+func Scan(localIP string, port uint, timeout time.Duration, found func(ip string)) ([]string, error) {
+	ip := net.ParseIP(localIP)
+	ipv4 := ip.To4()
+	if ipv4 == nil {
+		return nil, fmt.Errorf("invalid IPv4 address: %s", localIP)
+	}
+
+	base := fmt.Sprintf("%d.%d.%d.", ipv4[0], ipv4[1], ipv4[2])
+
+	jobs := make(chan int, maxHosts)
+	results := make(chan string, maxHosts)
+
+	var wg sync.WaitGroup
+
+	worker := func() {
+		defer wg.Done()
+		for i := range jobs {
+			ip := base + strconv.Itoa(i)
+			if Ping(ip, port, timeout) {
+				results <- ip
+			}
+		}
+	}
+
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go worker()
+	}
+
+	for i := 1; i <= maxHosts; i++ {
+		jobs <- i
+	}
+	close(jobs)
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	res := make([]string, 0)
+	for ip := range results {
+		if found != nil {
+			found(ip)
+		}
+		res = append(res, ip)
+	}
+
+	return res, nil
+}
+
+// Ping reports whether tcp port on ip accepts connections
+func Ping(ip string, port uint, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(int(port))), timeout)
+	if err == nil {
+		conn.Close()
+		return true
+	}
+	return false
+}
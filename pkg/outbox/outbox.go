@@ -0,0 +1,385 @@
+package outbox
+
+// package for keeping undelivered messages in json file until recipient comes back
+
+import (
+	"encoding/json"
+	"errors"
+	"ipmsg/pkg/filelock"
+	"ipmsg/pkg/models"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	minBackoff = 30 * time.Second
+	maxBackoff = 1 * time.Hour
+	// sendLease is how long item stays in flight, item of process which died during send
+	// is sent again after it
+	sendLease = 1 * time.Minute
+)
+
+var (
+	ErrNotFound error = errors.New("outbox item not found")
+	ErrSending  error = errors.New("outbox item is being sent right now")
+)
+
+type Item struct {
+	ID        string              `json:"id"`
+	To        string              `json:"to"` // host:port
+	Request   models.IPmsgRequest `json:"request"`
+	CreatedAt time.Time           `json:"created_at"`
+	Attempts  int                 `json:"attempts"`
+	NextTry   time.Time           `json:"next_try"`
+	LastError string              `json:"last_error,omitempty"`
+	MessageID string              `json:"message_id,omitempty"` // id of sent message in history
+	Sending   time.Time           `json:"sending,omitempty"`    // when send started, zero if item is not in flight
+}
+
+// Outbox is locked between processes with file lock, so server and cli can change it at the same time
+type Outbox struct {
+	filePath string
+
+	mu sync.Mutex
+}
+
+func New(path string) *Outbox {
+	return &Outbox{
+		filePath: path,
+	}
+}
+
+// Add puts message for addr into outbox, messageID is id of sent message in history
+func (o *Outbox) Add(addr string, req models.IPmsgRequest, messageID string, sendErr error) (*Item, error) {
+	unlock, err := o.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	items, err := o.read()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	item := Item{
+		ID:        models.NewID(),
+		To:        addr,
+		Request:   req,
+		CreatedAt: now,
+		Attempts:  1,
+		NextTry:   now.Add(minBackoff),
+		MessageID: messageID,
+	}
+	if sendErr != nil {
+		item.LastError = sendErr.Error()
+	}
+
+	items = append(items, item)
+
+	if err := o.write(items); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+func (o *Outbox) List() ([]Item, error) {
+	unlock, err := o.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return o.read()
+}
+
+// Get returns item by id or ErrNotFound
+func (o *Outbox) Get(id string) (*Item, error) {
+	items, err := o.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, it := range items {
+		if it.ID == id {
+			return &it, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// Remove deletes item, used for cancel. Item in flight can not be removed, ErrSending is
+// returned, as message may be at recipient already
+func (o *Outbox) Remove(id string) error {
+	unlock, err := o.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	items, err := o.read()
+	if err != nil {
+		return err
+	}
+
+	for i, it := range items {
+		if it.ID == id {
+			if it.inFlight(time.Now()) {
+				return ErrSending
+			}
+			items = append(items[:i], items[i+1:]...)
+			return o.write(items)
+		}
+	}
+
+	return ErrNotFound
+}
+
+// Deliver calls send for item and removes item when send succeeds or records failed attempt.
+// Item is marked in flight before send and outbox is not locked during send, item in flight
+// can not be canceled and server and cli never send it twice. Returned error is ErrNotFound
+// if item is gone, ErrSending if it is sent by other process or error of outbox,
+// result of send itself is left to send
+func (o *Outbox) Deliver(id string, send func(it *Item) error) (Item, error) {
+	var it Item
+	err := o.update(id, func(item *Item) error {
+		if item.inFlight(time.Now()) {
+			return ErrSending
+		}
+		item.Sending = time.Now()
+		it = *item
+		return nil
+	})
+	if err != nil {
+		return Item{}, err
+	}
+
+	sendErr := send(&it)
+
+	err = o.update(id, func(item *Item) error {
+		item.Sending = time.Time{}
+		if sendErr != nil {
+			failed(item, sendErr)
+		}
+		it = *item
+		return nil
+	})
+	if err != nil || sendErr != nil {
+		return it, err
+	}
+
+	return it, o.remove(id)
+}
+
+// Failed records unsuccessful attempt and schedules next one with backoff
+func (o *Outbox) Failed(id string, sendErr error) error {
+	return o.update(id, func(it *Item) error {
+		failed(it, sendErr)
+		return nil
+	})
+}
+
+// Wake makes all items for host due right now (host without port)
+func (o *Outbox) Wake(host string) (int, error) {
+	unlock, err := o.lock(true)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	items, err := o.read()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	woken := 0
+	for i := range items {
+		if itemHost(items[i].To) == host && items[i].NextTry.After(now) {
+			items[i].NextTry = now
+			woken++
+		}
+	}
+
+	if woken == 0 {
+		return 0, nil
+	}
+
+	return woken, o.write(items)
+}
+
+// Due returns items which next try time has come
+func (o *Outbox) Due(now time.Time) ([]Item, error) {
+	items, err := o.List()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Item, 0)
+	for _, it := range items {
+		if !it.NextTry.After(now) && !it.inFlight(now) {
+			res = append(res, it)
+		}
+	}
+
+	return res, nil
+}
+
+// Host returns recipient without port, as it is kept in deliveries of sent message
+func (it *Item) Host() string {
+	return itemHost(it.To)
+}
+
+// Backoff returns delay before attempt number n
+func Backoff(attempts int) time.Duration {
+	d := minBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+
+	return min(d, maxBackoff)
+}
+
+/* ======== internal ======== */
+
+// lock takes outbox in this process and file lock shared with other processes
+func (o *Outbox) lock(exclusive bool) (func(), error) {
+	o.mu.Lock()
+
+	fl := filelock.For(o.filePath)
+	acquire := fl.RLock
+	if exclusive {
+		acquire = fl.Lock
+	}
+
+	unlock, err := acquire()
+	if err != nil {
+		o.mu.Unlock()
+		return nil, err
+	}
+
+	return func() {
+		unlock()
+		o.mu.Unlock()
+	}, nil
+}
+
+// update changes item under lock, error of fn is returned and nothing is written
+func (o *Outbox) update(id string, fn func(it *Item) error) error {
+	unlock, err := o.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	items, err := o.read()
+	if err != nil {
+		return err
+	}
+
+	for i := range items {
+		if items[i].ID == id {
+			if err := fn(&items[i]); err != nil {
+				return err
+			}
+			return o.write(items)
+		}
+	}
+
+	return ErrNotFound
+}
+
+// remove deletes item whatever its state is
+func (o *Outbox) remove(id string) error {
+	unlock, err := o.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	items, err := o.read()
+	if err != nil {
+		return err
+	}
+
+	for i := range items {
+		if items[i].ID == id {
+			items = append(items[:i], items[i+1:]...)
+			return o.write(items)
+		}
+	}
+
+	return ErrNotFound
+}
+
+func (o *Outbox) read() ([]Item, error) {
+	data, err := os.ReadFile(o.filePath)
+	if os.IsNotExist(err) {
+		return []Item{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return []Item{}, nil
+	}
+
+	var items []Item
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// write replaces file atomically, so cli and server never see half written outbox
+func (o *Outbox) write(items []Item) error {
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(o.filePath), ".outbox-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), o.filePath)
+}
+
+func (it *Item) inFlight(now time.Time) bool {
+	return !it.Sending.IsZero() && now.Sub(it.Sending) < sendLease
+}
+
+func failed(it *Item, sendErr error) {
+	it.Attempts++
+	it.NextTry = time.Now().Add(Backoff(it.Attempts))
+	if sendErr != nil {
+		it.LastError = sendErr.Error()
+	}
+}
+
+func itemHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
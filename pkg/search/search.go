@@ -0,0 +1,413 @@
+// package for full-text search over messages with inverted index kept in memory. Index is
+// built from whole history once and then updated with every appended message, it is never
+// written to disk, so words of encrypted history do not leak and index can not get stale
+package search
+
+import (
+	"fmt"
+	"ipmsg/pkg/models"
+	"ipmsg/pkg/store"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	snippetRadius = 80
+)
+
+// Query selects messages, zero fields match everything
+type Query struct {
+	Text    string   // words and "quoted phrases", all of them must be in message
+	From    []string // addresses or aliases, any of them
+	Group   []string // members of group, any of them
+	Since   int64    // unix seconds, inclusive
+	Until   int64    // unix seconds, exclusive
+	Limit   int
+	Context int // messages from same sender shown before and after result
+}
+
+// Index is inverted index of message words with their positions, messages are
+// added with Append and their status is changed with UpdateStatus and UpdateDelivery,
+// so index can be used as store view to stay up to date
+type Index struct {
+	docs     []models.Message
+	byID     map[string]int
+	deleted  map[int]bool
+	postings map[string][]posting
+
+	mu sync.RWMutex
+}
+
+type posting struct {
+	doc int
+	pos []int
+}
+
+type token struct {
+	word       string
+	start, end int // byte offsets in text
+}
+
+func New() *Index {
+	return &Index{
+		byID:     map[string]int{},
+		deleted:  map[int]bool{},
+		postings: map[string][]posting{},
+	}
+}
+
+// Build indexes every message of store
+func Build(s store.Store) (*Index, error) {
+	ix := New()
+
+	err := s.Iterate(func(msg *models.Message) bool {
+		ix.Append(msg)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("search.Build: %w", err)
+	}
+
+	return ix, nil
+}
+
+// Append adds message to index, message with known id is skipped
+func (ix *Index) Append(msg *models.Message) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	doc := len(ix.docs)
+	if msg.ID != "" {
+		if _, ok := ix.byID[msg.ID]; ok {
+			return nil
+		}
+		ix.byID[msg.ID] = doc
+	}
+
+	ix.docs = append(ix.docs, *msg)
+
+	for i, t := range tokenize(msg.Msg) {
+		list := ix.postings[t.word]
+		if n := len(list); n > 0 && list[n-1].doc == doc {
+			list[n-1].pos = append(list[n-1].pos, i)
+		} else {
+			list = append(list, posting{doc: doc, pos: []int{i}})
+		}
+		ix.postings[t.word] = list
+	}
+
+	return nil
+}
+
+// UpdateStatus changes status of indexed message
+func (ix *Index) UpdateStatus(id, status string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	doc, ok := ix.byID[id]
+	if !ok {
+		return fmt.Errorf("search.UpdateStatus: %s: %w", id, store.ErrNotFound)
+	}
+
+	ix.docs[doc].Status = status
+	return nil
+}
+
+// UpdateDelivery replaces result of one recipient of indexed message and sums up its status again
+func (ix *Index) UpdateDelivery(id string, d models.Delivery) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	doc, ok := ix.byID[id]
+	if !ok {
+		return fmt.Errorf("search.UpdateDelivery: %s: %w", id, store.ErrNotFound)
+	}
+
+	// results returned before share deliveries with indexed message, they must not change
+	msg := ix.docs[doc]
+	msg.Deliveries = slices.Clone(msg.Deliveries)
+	if !msg.SetDelivery(d) {
+		return fmt.Errorf("search.UpdateDelivery: %s to %s: %w", id, d.To, store.ErrNotFound)
+	}
+
+	ix.docs[doc] = msg
+	return nil
+}
+
+// Remove hides messages from results, used when retention deletes them
+func (ix *Index) Remove(ids ...string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	for _, id := range ids {
+		if doc, ok := ix.byID[id]; ok {
+			ix.deleted[doc] = true
+			ix.docs[doc].Msg = ""
+		}
+	}
+}
+
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	return len(ix.docs) - len(ix.deleted)
+}
+
+// Search returns matching messages, newest first
+func (ix *Index) Search(q Query) []models.SearchResult {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	terms := ParseTerms(q.Text)
+
+	// doc - matched spans (first token, token count)
+	matches := map[int][][2]int{}
+	if len(terms) == 0 {
+		for doc := range ix.docs {
+			matches[doc] = nil
+		}
+	}
+
+	for i, term := range terms {
+		found := ix.phrase(term)
+		if i == 0 {
+			matches = found
+			continue
+		}
+
+		for doc, spans := range matches {
+			more, ok := found[doc]
+			if !ok {
+				delete(matches, doc)
+				continue
+			}
+			matches[doc] = append(spans, more...)
+		}
+	}
+
+	docs := make([]int, 0, len(matches))
+	for doc := range matches {
+		if !ix.deleted[doc] && q.match(&ix.docs[doc]) {
+			docs = append(docs, doc)
+		}
+	}
+
+	sort.Slice(docs, func(i, j int) bool {
+		a, b := &ix.docs[docs[i]], &ix.docs[docs[j]]
+		if a.Date != b.Date {
+			return a.Date > b.Date
+		}
+		return docs[i] > docs[j]
+	})
+
+	if q.Limit > 0 && len(docs) > q.Limit {
+		docs = docs[:q.Limit]
+	}
+
+	results := make([]models.SearchResult, 0, len(docs))
+	for _, doc := range docs {
+		msg := ix.docs[doc]
+
+		res := models.SearchResult{Message: msg}
+		res.Snippet, res.Highlights = snippet(msg.Msg, matches[doc])
+		if q.Context > 0 {
+			res.Before, res.After = ix.context(doc, q.Context)
+		}
+
+		results = append(results, res)
+	}
+
+	return results
+}
+
+// ParseTerms splits query to terms, every term is list of words which must go in a row,
+// quoted phrases and words like 192.168.1.5 give terms with several words
+func ParseTerms(text string) [][]string {
+	var terms [][]string
+
+	for i, part := range strings.Split(text, `"`) {
+		if i%2 == 1 {
+			// inside quotes
+			if words := tokenWords(part); len(words) > 0 {
+				terms = append(terms, words)
+			}
+			continue
+		}
+
+		for _, field := range strings.Fields(part) {
+			if words := tokenWords(field); len(words) > 0 {
+				terms = append(terms, words)
+			}
+		}
+	}
+
+	return terms
+}
+
+/* ======== internal ======== */
+
+func (q *Query) match(msg *models.Message) bool {
+	if q.Since != 0 && msg.Date < q.Since {
+		return false
+	}
+	if q.Until != 0 && msg.Date >= q.Until {
+		return false
+	}
+
+	return matchPeer(q.From, msg) && matchPeer(q.Group, msg)
+}
+
+// matchPeer reports whether message is from or to any of who, empty who matches everything
+func matchPeer(who []string, msg *models.Message) bool {
+	if len(who) == 0 {
+		return true
+	}
+
+	for _, w := range who {
+		if msg.Involves(w) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// phrase finds docs where words go in a row, ix.mu must be held
+func (ix *Index) phrase(words []string) map[int][][2]int {
+	res := map[int][][2]int{}
+
+	lists := make([][]posting, len(words))
+	for i, w := range words {
+		lists[i] = ix.postings[w]
+		if len(lists[i]) == 0 {
+			return res
+		}
+	}
+
+	for _, p := range lists[0] {
+		for _, start := range p.pos {
+			ok := true
+			for k := 1; k < len(words) && ok; k++ {
+				ok = hasPosition(lists[k], p.doc, start+k)
+			}
+			if ok {
+				res[p.doc] = append(res[p.doc], [2]int{start, len(words)})
+			}
+		}
+	}
+
+	return res
+}
+
+func hasPosition(list []posting, doc, pos int) bool {
+	i := sort.Search(len(list), func(i int) bool { return list[i].doc >= doc })
+	if i == len(list) || list[i].doc != doc {
+		return false
+	}
+
+	positions := list[i].pos
+	j := sort.SearchInts(positions, pos)
+	return j < len(positions) && positions[j] == pos
+}
+
+// context returns up to n messages of same sender around doc, ix.mu must be held
+func (ix *Index) context(doc, n int) (before, after []models.Message) {
+	from := ix.docs[doc].From
+
+	for i := doc - 1; i >= 0 && len(before) < n; i-- {
+		if ix.docs[i].From == from && !ix.deleted[i] {
+			before = append([]models.Message{ix.docs[i]}, before...)
+		}
+	}
+
+	for i := doc + 1; i < len(ix.docs) && len(after) < n; i++ {
+		if ix.docs[i].From == from && !ix.deleted[i] {
+			after = append(after, ix.docs[i])
+		}
+	}
+
+	return before, after
+}
+
+// snippet cuts text around first match, spans are in token positions
+func snippet(text string, spans [][2]int) (string, [][2]int) {
+	tokens := tokenize(text)
+
+	var ranges [][2]int
+	for _, s := range spans {
+		last := s[0] + s[1] - 1
+		if last < len(tokens) {
+			ranges = append(ranges, [2]int{tokens[s[0]].start, tokens[last].end})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+
+	from, to := 0, len(text)
+	if len(ranges) > 0 {
+		from = max(0, ranges[0][0]-snippetRadius)
+		to = min(len(text), ranges[0][1]+snippetRadius)
+	} else {
+		to = min(len(text), 2*snippetRadius)
+	}
+	for from > 0 && !utf8.RuneStart(text[from]) {
+		from--
+	}
+	for to < len(text) && !utf8.RuneStart(text[to]) {
+		to++
+	}
+
+	prefix, suffix := "", ""
+	if from > 0 {
+		prefix = "..."
+	}
+	if to < len(text) {
+		suffix = "..."
+	}
+
+	// newlines are replaced by spaces of same length, so offsets stay valid
+	body := strings.NewReplacer("\n", " ", "\r", " ", "\t", " ").Replace(text[from:to])
+
+	var highlights [][2]int
+	for _, r := range ranges {
+		if r[0] >= from && r[1] <= to {
+			highlights = append(highlights, [2]int{r[0] - from + len(prefix), r[1] - from + len(prefix)})
+		}
+	}
+
+	return prefix + body + suffix, highlights
+}
+
+func tokenize(text string) []token {
+	var tokens []token
+
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && start < 0 {
+			start = i
+		}
+		if !word && start >= 0 {
+			tokens = append(tokens, token{word: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{word: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+
+	return tokens
+}
+
+func tokenWords(text string) []string {
+	tokens := tokenize(text)
+	words := make([]string, len(tokens))
+	for i, t := range tokens {
+		words[i] = t.word
+	}
+	return words
+}
@@ -0,0 +1,63 @@
+package sender
+
+// package for delivering messages to ipmsg servers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"ipmsg/pkg/models"
+	"net"
+	"time"
+)
+
+var (
+	ErrRejected error = errors.New("message rejected by recipient")
+)
+
+// Send dials addr (host:port), writes request and waits for server answer
+func Send(addr string, req *models.IPmsgRequest, timeout time.Duration) error {
+	_, err := Request(addr, req, timeout)
+	return err
+}
+
+// Request is Send which returns data of answer, used for requests other than message
+func Request(addr string, req *models.IPmsgRequest, timeout time.Duration) (string, error) {
+	const op = "sender.Send"
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout + 5*time.Second))
+
+	if _, err := conn.Write([]byte(req.Encode())); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	resp, err := models.ParseResponse(string(data))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !resp.Succes {
+		if resp.Error != nil {
+			return "", fmt.Errorf("%s: %w: %s", op, ErrRejected, *resp.Error)
+		}
+		return "", fmt.Errorf("%s: %w", op, ErrRejected)
+	}
+
+	return resp.Data, nil
+}
+
+// Addr joins host and port
+func Addr(host string, port uint) string {
+	return net.JoinHostPort(host, fmt.Sprint(port))
+}
@@ -0,0 +1,375 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"ipmsg/pkg/crypt"
+	"ipmsg/pkg/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	archiveExt  = ".jsonl.gz"
+	monthLayout = "2006-01"
+)
+
+// Archive keeps old messages in gzip compressed json lines files, one file per month
+// of message date (archive/2024-01.jsonl.gz), every Add appends new gzip member.
+// Encrypted archive seals every line with key of encrypted store, plain lines written
+// before encryption was turned on are still read
+type Archive struct {
+	dir string
+	key *crypt.Key
+
+	mu sync.Mutex
+}
+
+type ArchiveFile struct {
+	Month string `json:"month"`
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+}
+
+func NewArchive(dir string) *Archive {
+	return &Archive{dir: dir}
+}
+
+func NewEncryptedArchive(dir string, key *crypt.Key) *Archive {
+	return &Archive{dir: dir, key: key}
+}
+
+// Add appends messages to files of their months
+func (a *Archive) Add(msgs []models.Message) error {
+	const op = "store.Archive.Add"
+
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	byMonth := map[string][]models.Message{}
+	for _, m := range msgs {
+		month := monthOf(&m)
+		byMonth[month] = append(byMonth[month], m)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := os.MkdirAll(a.dir, a.dirPerm()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for month, list := range byMonth {
+		if err := a.appendFile(a.path(month), list); err != nil {
+			return fmt.Errorf("%s: %s: %w", op, month, err)
+		}
+	}
+
+	return nil
+}
+
+// Files returns archive files from oldest month
+func (a *Archive) Files() ([]ArchiveFile, error) {
+	entries, err := os.ReadDir(a.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("store.Archive.Files: %w", err)
+	}
+
+	var files []ArchiveFile
+	for _, e := range entries {
+		month, ok := strings.CutSuffix(e.Name(), archiveExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(monthLayout, month); err != nil {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+
+		files = append(files, ArchiveFile{Month: month, Path: a.path(month), Size: info.Size()})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Month < files[j].Month })
+
+	return files, nil
+}
+
+func (a *Archive) Iterate(fn func(msg *models.Message) bool) error {
+	_, err := a.iterateSince(0, fn)
+	return err
+}
+
+// Remove deletes messages from archive files, returns number of deleted messages
+func (a *Archive) Remove(drop func(msg *models.Message) bool) (int, error) {
+	const op = "store.Archive.Remove"
+
+	files, err := a.Files()
+	if err != nil {
+		return 0, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	removed := 0
+	for _, f := range files {
+		msgs, err := readArchive(f.Path, a.key)
+		if err != nil {
+			return removed, fmt.Errorf("%s: %s: %w", op, f.Month, err)
+		}
+
+		kept := msgs[:0]
+		for i := range msgs {
+			if !drop(&msgs[i]) {
+				kept = append(kept, msgs[i])
+			}
+		}
+		if len(kept) == len(msgs) {
+			continue
+		}
+
+		if len(kept) == 0 {
+			err = os.Remove(f.Path)
+		} else {
+			err = a.rewriteFile(f.Path, kept)
+		}
+		if err != nil {
+			return removed, fmt.Errorf("%s: %s: %w", op, f.Month, err)
+		}
+
+		removed += len(msgs) - len(kept)
+	}
+
+	return removed, nil
+}
+
+// Convert rewrites every archive file into archive to, to may have same directory with other key,
+// that is how archive is encrypted or decrypted. Returns number of converted messages
+func (a *Archive) Convert(to *Archive) (int, error) {
+	const op = "store.Archive.Convert"
+
+	files, err := a.Files()
+	if err != nil {
+		return 0, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(files) > 0 {
+		if err := os.MkdirAll(to.dir, to.dirPerm()); err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	converted := 0
+	for _, f := range files {
+		msgs, err := readArchive(f.Path, a.key)
+		if err != nil {
+			return converted, fmt.Errorf("%s: %s: %w", op, f.Month, err)
+		}
+
+		to.mu.Lock()
+		err = to.rewriteFile(to.path(f.Month), msgs)
+		to.mu.Unlock()
+		if err != nil {
+			return converted, fmt.Errorf("%s: %s: %w", op, f.Month, err)
+		}
+
+		converted += len(msgs)
+	}
+
+	return converted, nil
+}
+
+/* ======== internal ======== */
+
+func (a *Archive) path(month string) string {
+	return filepath.Join(a.dir, month+archiveExt)
+}
+
+// iterateSince skips files of months before since, reports whether fn stopped iteration
+func (a *Archive) iterateSince(since int64, fn func(msg *models.Message) bool) (bool, error) {
+	files, err := a.Files()
+	if err != nil {
+		return false, err
+	}
+
+	sinceMonth := ""
+	if since > 0 {
+		sinceMonth = time.Unix(since, 0).Format(monthLayout)
+	}
+
+	for _, f := range files {
+		if f.Month < sinceMonth {
+			continue
+		}
+
+		a.mu.Lock()
+		msgs, err := readArchive(f.Path, a.key)
+		a.mu.Unlock()
+		if err != nil {
+			return false, fmt.Errorf("store.Archive: %s: %w", f.Month, err)
+		}
+
+		for i := range msgs {
+			if !fn(&msgs[i]) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func (a *Archive) appendFile(path string, msgs []models.Message) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, a.perm())
+	if err != nil {
+		return err
+	}
+
+	if err := writeArchive(file, msgs, a.key); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func (a *Archive) rewriteFile(path string, msgs []models.Message) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writeArchive(tmp, msgs, a.key); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// perm returns mode of archive files, encrypted ones are readable only by owner
+func (a *Archive) perm() os.FileMode {
+	if a.key != nil {
+		return 0600
+	}
+	return 0644
+}
+
+func (a *Archive) dirPerm() os.FileMode {
+	if a.key != nil {
+		return 0700
+	}
+	return 0755
+}
+
+func writeArchive(w io.Writer, msgs []models.Message, key *crypt.Key) error {
+	records := make([]any, len(msgs))
+	for i := range msgs {
+		records[i] = &msgs[i]
+	}
+
+	data, err := encodeLines(key, records)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func readArchive(path string, key *crypt.Key) ([]models.Message, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	zr, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var msgs []models.Message
+	reader := bufio.NewReader(zr)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			m, decErr := decodeArchiveLine(line, key)
+			if decErr != nil && err != nil {
+				// last member was cut by crash
+				break
+			}
+			if decErr != nil {
+				return nil, decErr
+			}
+			msgs = append(msgs, m)
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	return msgs, nil
+}
+
+func decodeArchiveLine(line []byte, key *crypt.Key) (models.Message, error) {
+	if line[0] != '{' {
+		if key == nil {
+			return models.Message{}, ErrEncrypted
+		}
+
+		plain, err := key.OpenLine(line)
+		if err != nil {
+			return models.Message{}, err
+		}
+		line = plain
+	}
+
+	var m models.Message
+	err := json.Unmarshal(line, &m)
+	return m, err
+}
+
+func monthOf(msg *models.Message) string {
+	return time.Unix(msg.Date, 0).Format(monthLayout)
+}
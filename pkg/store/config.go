@@ -0,0 +1,87 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"ipmsg/pkg/filelock"
+	"os"
+	"path/filepath"
+)
+
+// ConfigFileName is file in ~/ipmsg where server saves its store config
+const ConfigFileName = "store.json"
+
+// Config describes message store server writes to, server saves it on start, so cli
+// reads and writes the same history when server is not running
+type Config struct {
+	Kind     string `json:"kind"`                // text, jsonl, conversations or encrypted
+	Path     string `json:"path"`                // file or directory of store
+	TextView string `json:"text_view,omitempty"` // text table rendered beside jsonl and conversations stores
+	Archive  string `json:"archive"`
+	KeyFile  string `json:"key_file,omitempty"` // passphrase file of encrypted store, passphrase itself is never saved
+}
+
+// LoadConfig reads store config, error wraps os.ErrNotExist when server has not saved it yet
+func LoadConfig(path string) (*Config, error) {
+	const op = "store.LoadConfig"
+
+	unlock, err := filelock.For(path).RLock()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &cfg, nil
+}
+
+// SaveConfig writes store config atomically, paths are saved absolute as cli runs in other directories
+func SaveConfig(path string, cfg Config) error {
+	const op = "store.SaveConfig"
+
+	for _, p := range []*string{&cfg.Path, &cfg.TextView, &cfg.Archive, &cfg.KeyFile} {
+		if *p == "" {
+			continue
+		}
+		abs, err := filepath.Abs(*p)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		*p = abs
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	unlock, err := filelock.For(path).Lock()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	err = replaceFile(path, func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
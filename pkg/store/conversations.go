@@ -0,0 +1,449 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"ipmsg/pkg/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	indexFile       = "index.jsonl"
+	conversationExt = ".jsonl"
+	// BroadcastKey is conversation of messages sent to several peers outside of group
+	BroadcastKey = "broadcast"
+)
+
+// Conversations keeps one json lines log per peer and per group in dir, plus combined
+// index.jsonl with order of all messages, so history of one peer is read from one file:
+//
+//	conversations/192.168.1.5.jsonl
+//	conversations/group-team.jsonl
+//	conversations/index.jsonl
+type Conversations struct {
+	dir string
+
+	loaded bool
+	order  []indexEntry
+	convOf map[string]string // message id - conversation file name
+	convs  map[string]string // conversation file name - last known alias
+
+	logs   map[string]*JSONL
+	logsMu sync.Mutex
+
+	mu sync.Mutex
+}
+
+type indexEntry struct {
+	ID    string `json:"id"`
+	Conv  string `json:"conv"`
+	Date  int64  `json:"date"`
+	Alias string `json:"alias,omitempty"`
+}
+
+func NewConversations(dir string) *Conversations {
+	return &Conversations{dir: dir}
+}
+
+// ConversationKey returns conversation of message: peer address, group-<name> or broadcast
+func ConversationKey(msg *models.Message) string {
+	if msg.Group != "" {
+		return "group-" + msg.Group
+	}
+
+	if msg.Direction == models.DirectionOut {
+		if len(msg.To) == 1 {
+			return msg.To[0]
+		}
+		return BroadcastKey
+	}
+
+	return msg.From
+}
+
+func (s *Conversations) Append(msg *models.Message) error {
+	const op = "store.Conversations.Append"
+
+	if msg.ID == "" {
+		msg.ID = models.NewID()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	key := ConversationKey(msg)
+	if err := s.log(fileName(key)).Append(msg); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	entry := indexEntry{ID: msg.ID, Conv: key, Date: msg.Date, Alias: msg.Alias}
+	if err := appendIndex(filepath.Join(s.dir, indexFile), entry); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	s.remember(entry)
+
+	return nil
+}
+
+// AppendBatch appends msgs one by one, every message goes to its own conversation
+func (s *Conversations) AppendBatch(msgs []*models.Message) error {
+	for _, msg := range msgs {
+		if err := s.Append(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync flushes index and conversation logs to disk
+func (s *Conversations) Sync() error {
+	s.logsMu.Lock()
+	logs := make([]*JSONL, 0, len(s.logs))
+	for _, l := range s.logs {
+		logs = append(logs, l)
+	}
+	s.logsMu.Unlock()
+
+	for _, l := range logs {
+		if err := l.Sync(); err != nil {
+			return err
+		}
+	}
+
+	return syncFile(filepath.Join(s.dir, indexFile))
+}
+
+// Iterate goes in order of combined index, messages missing in index go last
+func (s *Conversations) Iterate(fn func(msg *models.Message) bool) error {
+	const op = "store.Conversations.Iterate"
+
+	s.mu.Lock()
+	if err := s.load(); err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	order := append([]indexEntry(nil), s.order...)
+	names := s.names()
+	s.mu.Unlock()
+
+	byID := map[string]models.Message{}
+	var unindexed []models.Message
+	for _, name := range names {
+		err := s.log(name).Iterate(func(msg *models.Message) bool {
+			byID[msg.ID] = *msg
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	for _, e := range order {
+		msg, ok := byID[e.ID]
+		if !ok {
+			continue
+		}
+		delete(byID, e.ID)
+
+		if !fn(&msg) {
+			return nil
+		}
+	}
+
+	for _, msg := range byID {
+		unindexed = append(unindexed, msg)
+	}
+	sort.Slice(unindexed, func(i, j int) bool { return unindexed[i].Received.Before(unindexed[j].Received) })
+
+	for i := range unindexed {
+		if !fn(&unindexed[i]) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// Query reads only conversations of q.From if it is set
+func (s *Conversations) Query(q Query) ([]models.Message, error) {
+	const op = "store.Conversations.Query"
+
+	if q.From == "" {
+		return Filter(s, q)
+	}
+
+	s.mu.Lock()
+	if err := s.load(); err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	var names []string
+	for _, name := range s.names() {
+		if name == fileName(q.From) || strings.EqualFold(s.convs[name], q.From) {
+			names = append(names, name)
+		}
+	}
+	s.mu.Unlock()
+
+	result := []models.Message{}
+	for _, name := range names {
+		err := s.log(name).Iterate(func(msg *models.Message) bool {
+			if q.Match(msg) {
+				result = append(result, *msg)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Received.Before(result[j].Received) })
+
+	if q.Limit > 0 && q.Limit < len(result) {
+		result = result[len(result)-q.Limit:]
+	}
+
+	return result, nil
+}
+
+func (s *Conversations) UpdateStatus(id, status string) error {
+	const op = "store.Conversations.UpdateStatus"
+
+	s.mu.Lock()
+	if err := s.load(); err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	name, ok := s.convOf[id]
+	s.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%s: %s: %w", op, id, ErrNotFound)
+	}
+
+	return s.log(name).UpdateStatus(id, status)
+}
+
+func (s *Conversations) UpdateDelivery(id string, d models.Delivery) error {
+	const op = "store.Conversations.UpdateDelivery"
+
+	s.mu.Lock()
+	if err := s.load(); err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	name, ok := s.convOf[id]
+	s.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%s: %s: %w", op, id, ErrNotFound)
+	}
+
+	return s.log(name).UpdateDelivery(id, d)
+}
+
+// Replace rewrites every conversation and index with msgs
+func (s *Conversations) Replace(msgs []models.Message) error {
+	const op = "store.Conversations.Replace"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	byName := map[string][]models.Message{}
+	var order []indexEntry
+	for _, m := range msgs {
+		key := ConversationKey(&m)
+		byName[fileName(key)] = append(byName[fileName(key)], m)
+		order = append(order, indexEntry{ID: m.ID, Conv: key, Date: m.Date, Alias: m.Alias})
+	}
+
+	for _, name := range s.names() {
+		if _, ok := byName[name]; !ok {
+			if err := os.Remove(s.log(name).filePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+	}
+
+	for name, list := range byName {
+		if err := s.log(name).Replace(list); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	err := replaceFile(filepath.Join(s.dir, indexFile), func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for i := range order {
+			if err := enc.Encode(&order[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.loaded = false
+	return s.load()
+}
+
+// Size returns size of conversation logs without index
+func (s *Conversations) Size() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, name := range s.names() {
+		size, err := fileSize(s.log(name).filePath)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+
+	return total, nil
+}
+
+/* ======== internal ======== */
+
+// load reads index and finds conversation files, s.mu must be held
+func (s *Conversations) load() error {
+	if s.loaded {
+		return nil
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+
+	s.order = nil
+	s.convOf = map[string]string{}
+	s.convs = map[string]string{}
+
+	file, err := os.Open(filepath.Join(s.dir, indexFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var e indexEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				// line cut by crash, message is still in its conversation
+				continue
+			}
+			s.remember(e)
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), conversationExt)
+		if !ok || e.IsDir() || e.Name() == indexFile {
+			continue
+		}
+		if _, ok := s.convs[name]; !ok {
+			s.convs[name] = ""
+		}
+	}
+
+	s.loaded = true
+	return nil
+}
+
+// remember adds index entry to memory, s.mu must be held
+func (s *Conversations) remember(e indexEntry) {
+	name := fileName(e.Conv)
+
+	s.order = append(s.order, e)
+	s.convOf[e.ID] = name
+	if e.Alias != "" || s.convs[name] == "" {
+		s.convs[name] = e.Alias
+	}
+}
+
+// names returns sorted conversation file names, s.mu must be held
+func (s *Conversations) names() []string {
+	names := make([]string, 0, len(s.convs))
+	for name := range s.convs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// log returns store of conversation file, it is called with and without s.mu
+func (s *Conversations) log(name string) *JSONL {
+	s.logsMu.Lock()
+	defer s.logsMu.Unlock()
+
+	if s.logs == nil {
+		s.logs = map[string]*JSONL{}
+	}
+	l, ok := s.logs[name]
+	if !ok {
+		l = NewJSONL(filepath.Join(s.dir, name+conversationExt))
+		s.logs[name] = l
+	}
+	return l
+}
+
+func appendIndex(path string, e indexEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// fileName makes file name from conversation key without extension, ipv6 colons become "_"
+func fileName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+}
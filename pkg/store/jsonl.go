@@ -0,0 +1,488 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"ipmsg/pkg/crypt"
+	"ipmsg/pkg/filelock"
+	"ipmsg/pkg/models"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	opStatus = "status"
+)
+
+// JSONL keeps messages in append-only json lines file, every line is message
+// or status update record:
+//
+//	{"id":"3f2a..","direction":"in","from":"192.168.1.5",...}
+//	{"op":"status","id":"3f2a..","status":"acked","time":"..."}
+//	{"op":"status","id":"3f2a..","status":"sent","delivery":{"to":"192.168.1.6",...},"time":"..."}
+//
+// encrypted store starts with crypt header line and every next line is sealed record
+type JSONL struct {
+	filePath string
+	key      *crypt.Key
+	header   []byte
+
+	mu sync.Mutex
+}
+
+type statusRecord struct {
+	Op       string           `json:"op"`
+	ID       string           `json:"id"`
+	Status   string           `json:"status"`
+	Delivery *models.Delivery `json:"delivery,omitempty"` // new result of one recipient
+	Time     time.Time        `json:"time"`
+}
+
+func NewJSONL(path string) *JSONL {
+	return &JSONL{filePath: path}
+}
+
+// OpenEncryptedJSONL returns store sealed with key derived from passphrase, key parameters are
+// read from header of existing file, missing file is created with new ones and only owner can read it
+func OpenEncryptedJSONL(path string, passphrase []byte) (*JSONL, error) {
+	const op = "store.OpenEncryptedJSONL"
+
+	s := &JSONL{filePath: path}
+
+	unlock, err := filelock.For(path).Lock()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	h, err := ReadHeader(path)
+	switch {
+	case err == nil:
+		if s.key, err = h.Unlock(passphrase); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if h, s.key, err = crypt.NewHeader(passphrase); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if s.header, err = h.Line(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// header is written at once, so next start derives same key
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if info.Size() == 0 {
+		if _, err := file.Write(append(s.header, '\n')); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return s, nil
+}
+
+// ReadHeader returns crypt header of encrypted store, os.ErrNotExist for missing or empty file
+// and crypt.ErrNotEncrypted for plain one
+func ReadHeader(path string) (crypt.Header, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return crypt.Header{}, err
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return crypt.Header{}, err
+	}
+
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return crypt.Header{}, os.ErrNotExist
+	}
+
+	return crypt.ParseHeader(line)
+}
+
+// Key returns key of encrypted store, nil for plain one
+func (s *JSONL) Key() *crypt.Key {
+	return s.key
+}
+
+func (s *JSONL) Append(msg *models.Message) error {
+	const op = "store.JSONL.Append"
+
+	if msg.ID == "" {
+		msg.ID = models.NewID()
+	}
+
+	if err := s.write(msg); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AppendBatch writes msgs with one write
+func (s *JSONL) AppendBatch(msgs []*models.Message) error {
+	const op = "store.JSONL.AppendBatch"
+
+	list := make([]any, len(msgs))
+	for i, msg := range msgs {
+		if msg.ID == "" {
+			msg.ID = models.NewID()
+		}
+		list[i] = msg
+	}
+
+	if err := s.write(list...); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Sync flushes file to disk
+func (s *JSONL) Sync() error {
+	return syncFile(s.filePath)
+}
+
+func (s *JSONL) Iterate(fn func(msg *models.Message) bool) error {
+	const op = "store.JSONL.Iterate"
+
+	messages, err := s.load()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for i := range messages {
+		if !fn(&messages[i]) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (s *JSONL) Query(q Query) ([]models.Message, error) {
+	return Filter(s, q)
+}
+
+// UpdateStatus appends status record, message itself is not rewritten
+func (s *JSONL) UpdateStatus(id, status string) error {
+	const op = "store.JSONL.UpdateStatus"
+
+	found := false
+	err := s.Iterate(func(msg *models.Message) bool {
+		found = msg.ID == id
+		return !found
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if !found {
+		return fmt.Errorf("%s: %s: %w", op, id, ErrNotFound)
+	}
+
+	rec := statusRecord{Op: opStatus, ID: id, Status: status, Time: time.Now()}
+	if err := s.write(rec); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UpdateDelivery appends status record with result of recipient, message itself is not rewritten
+func (s *JSONL) UpdateDelivery(id string, d models.Delivery) error {
+	const op = "store.JSONL.UpdateDelivery"
+
+	var msg *models.Message
+	err := s.Iterate(func(m *models.Message) bool {
+		if m.ID == id {
+			msg = m
+		}
+		return msg == nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if msg == nil || !msg.SetDelivery(d) {
+		return fmt.Errorf("%s: %s to %s: %w", op, id, d.To, ErrNotFound)
+	}
+
+	rec := statusRecord{Op: opStatus, ID: id, Status: msg.Status, Delivery: &d, Time: time.Now()}
+	if err := s.write(rec); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Replace rewrites file with msgs, status updates are folded into messages
+func (s *JSONL) Replace(msgs []models.Message) error {
+	const op = "store.JSONL.Replace"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := filelock.For(s.filePath).Lock()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	err = replaceFile(s.filePath, func(w io.Writer) error {
+		records := make([]any, len(msgs))
+		for i := range msgs {
+			records[i] = &msgs[i]
+		}
+
+		data, err := encodeLines(s.key, records)
+		if err != nil {
+			return err
+		}
+		if s.key != nil {
+			data = append(append(append([]byte{}, s.header...), '\n'), data...)
+		}
+
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *JSONL) Size() (int64, error) {
+	return fileSize(s.filePath)
+}
+
+/* ======== internal ======== */
+
+// write appends records under file lock, every record is line
+func (s *JSONL) write(records ...any) error {
+	data, err := encodeLines(s.key, records)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := filelock.For(s.filePath).Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	perm := os.FileMode(0644)
+	if s.key != nil {
+		perm = 0600
+	}
+
+	file, err := os.OpenFile(s.filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, perm)
+	if err != nil {
+		return err
+	}
+
+	if s.key != nil {
+		// file was removed after open, it gets header again
+		if info, err := file.Stat(); err == nil && info.Size() == 0 {
+			data = append(append(append([]byte{}, s.header...), '\n'), data...)
+		}
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// load reads all messages with status updates applied
+func (s *JSONL) load() ([]models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := filelock.For(s.filePath).RLock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	file, err := os.Open(s.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var messages []models.Message
+	index := map[string]int{}
+
+	reader := bufio.NewReader(file)
+	for lineNum := 1; ; lineNum++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			sealed := line[0] != '{'
+			if sealed {
+				if s.key == nil {
+					return nil, fmt.Errorf("line %d: %w", lineNum, ErrEncrypted)
+				}
+
+				plain, openErr := s.key.OpenLine(line)
+				if openErr != nil && err == io.EOF {
+					// last line was cut by crash, skip it
+					break
+				}
+				if openErr != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNum, openErr)
+				}
+				line = plain
+			} else if !bytes.HasSuffix(line, []byte("}")) && err == io.EOF {
+				// last line was cut by crash, skip it
+				break
+			}
+
+			var head struct {
+				Op        string `json:"op"`
+				Encrypted string `json:"encrypted"`
+			}
+			if err := json.Unmarshal(line, &head); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+
+			switch {
+			case head.Encrypted != "" && !sealed:
+				// header of encrypted store
+				if s.key == nil {
+					return nil, fmt.Errorf("line %d: %w", lineNum, ErrEncrypted)
+				}
+			case s.key != nil && !sealed:
+				return nil, fmt.Errorf("line %d: record is not encrypted", lineNum)
+			case head.Op == "":
+				var msg models.Message
+				if err := json.Unmarshal(line, &msg); err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNum, err)
+				}
+				index[msg.ID] = len(messages)
+				messages = append(messages, msg)
+			case head.Op == opStatus:
+				var rec statusRecord
+				if err := json.Unmarshal(line, &rec); err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNum, err)
+				}
+				if i, ok := index[rec.ID]; ok {
+					if rec.Delivery != nil {
+						messages[i].SetDelivery(*rec.Delivery)
+					}
+					messages[i].Status = rec.Status
+				}
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	return messages, nil
+}
+
+// encodeLines returns records as json lines, sealed with key if it is set
+func encodeLines(key *crypt.Key, records []any) ([]byte, error) {
+	var data []byte
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			if line, err = key.SealLine(line); err != nil {
+				return nil, err
+			}
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	return data, nil
+}
+
+// replaceFile writes new content to temp file and renames it over path
+func replaceFile(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".ipmsg-store-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// syncFile flushes file to disk, missing file has nothing to flush
+func syncFile(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
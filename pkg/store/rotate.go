@@ -0,0 +1,177 @@
+package store
+
+import (
+	"fmt"
+	"ipmsg/pkg/models"
+	"sync"
+)
+
+// Replacer is store which can atomically replace all its messages, used by rotation
+type Replacer interface {
+	Replace(msgs []models.Message) error
+}
+
+// Sizer is store which knows its size in bytes
+type Sizer interface {
+	Size() (int64, error)
+}
+
+// Archived is store with old messages moved to archive, reading goes through
+// archive first and then through primary store, so readers see all history
+type Archived struct {
+	primary Store
+	archive *Archive
+
+	mu sync.RWMutex // rotation holds it for writing
+}
+
+// Rotation is result of Rotate
+type Rotation struct {
+	Archived int
+	Deleted  int
+	// DeletedIDs are ids of deleted messages, for removing them from indexes
+	DeletedIDs []string
+}
+
+func WithArchive(primary Store, archive *Archive) *Archived {
+	return &Archived{primary: primary, archive: archive}
+}
+
+func (s *Archived) Append(msg *models.Message) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.primary.Append(msg)
+}
+
+func (s *Archived) Iterate(fn func(msg *models.Message) bool) error {
+	return s.iterate(0, fn)
+}
+
+// Query goes through archive months since q.Since and uses own query of primary store
+func (s *Archived) Query(q Query) ([]models.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []models.Message{}
+	_, err := s.archive.iterateSince(q.Since, func(msg *models.Message) bool {
+		if q.Match(msg) {
+			result = append(result, *msg)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	live, err := s.primary.Query(Query{From: q.From, Direction: q.Direction, Since: q.Since, Until: q.Until})
+	if err != nil {
+		return nil, err
+	}
+	result = append(result, live...)
+
+	if q.Limit > 0 && q.Limit < len(result) {
+		result = result[len(result)-q.Limit:]
+	}
+
+	return result, nil
+}
+
+// UpdateStatus updates message in primary store, archived messages are not changed
+func (s *Archived) UpdateStatus(id, status string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.primary.UpdateStatus(id, status)
+}
+
+// UpdateDelivery updates message in primary store, archived messages are not changed
+func (s *Archived) UpdateDelivery(id string, d models.Delivery) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.primary.UpdateDelivery(id, d)
+}
+
+func (s *Archived) Archive() *Archive {
+	return s.archive
+}
+
+// Rotate deletes expired messages from primary store and archive, then moves
+// messages chosen by split from primary store to archive, size is primary store size
+func (s *Archived) Rotate(
+	split func(msgs []models.Message, size int64) (keep, archive []models.Message),
+	expired func(msg *models.Message) bool,
+) (Rotation, error) {
+	const op = "store.Archived.Rotate"
+
+	replacer, ok := s.primary.(Replacer)
+	if !ok {
+		return Rotation{}, fmt.Errorf("%s: %w", op, ErrUnsupported)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res Rotation
+	var live []models.Message
+	err := s.primary.Iterate(func(msg *models.Message) bool {
+		if expired(msg) {
+			res.DeletedIDs = append(res.DeletedIDs, msg.ID)
+		} else {
+			live = append(live, *msg)
+		}
+		return true
+	})
+	if err != nil {
+		return res, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var size int64
+	if sizer, ok := s.primary.(Sizer); ok {
+		if size, err = sizer.Size(); err != nil {
+			return res, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	keep, old := split(live, size)
+
+	if len(old) > 0 || len(res.DeletedIDs) > 0 {
+		// archive first, crash between steps leaves copy in both places instead of losing messages
+		if err := s.archive.Add(old); err != nil {
+			return res, fmt.Errorf("%s: %w", op, err)
+		}
+		if err := replacer.Replace(keep); err != nil {
+			return res, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	res.Archived = len(old)
+
+	_, err = s.archive.Remove(func(msg *models.Message) bool {
+		if expired(msg) {
+			res.DeletedIDs = append(res.DeletedIDs, msg.ID)
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return res, fmt.Errorf("%s: %w", op, err)
+	}
+	res.Deleted = len(res.DeletedIDs)
+
+	return res, nil
+}
+
+/* ======== internal ======== */
+
+func (s *Archived) iterate(since int64, fn func(msg *models.Message) bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stopped, err := s.archive.iterateSince(since, fn)
+	if err != nil || stopped {
+		return err
+	}
+
+	return s.primary.Iterate(fn)
+}
@@ -0,0 +1,226 @@
+// package for storing messages
+package store
+
+import (
+	"errors"
+	"fmt"
+	"ipmsg/pkg/models"
+)
+
+var (
+	ErrNotFound    error = errors.New("message not found")
+	ErrUnsupported error = errors.New("operation is not supported by store")
+	ErrEncrypted   error = errors.New("store is encrypted")
+)
+
+// Appender receives every new message, store views (text file, search index) implement it
+type Appender interface {
+	Append(msg *models.Message) error
+}
+
+type Store interface {
+	Appender
+	// Iterate calls fn for messages from oldest to newest until fn returns false
+	Iterate(fn func(msg *models.Message) bool) error
+	Query(q Query) ([]models.Message, error)
+	UpdateStatus(id, status string) error
+	// UpdateDelivery replaces result of one recipient of sent message, status is summed up again
+	UpdateDelivery(id string, d models.Delivery) error
+}
+
+// DeliveryUpdater is view which keeps results of recipients of sent messages
+type DeliveryUpdater interface {
+	UpdateDelivery(id string, d models.Delivery) error
+}
+
+// StatusUpdater is view which keeps status of messages (search index)
+type StatusUpdater interface {
+	UpdateStatus(id, status string) error
+}
+
+// Query selects messages, zero fields match everything
+type Query struct {
+	From      string // address or alias of peer
+	Direction string
+	Since     int64 // unix seconds, inclusive
+	Until     int64 // unix seconds, exclusive
+	Limit     int   // newest n messages
+}
+
+func (q *Query) Match(msg *models.Message) bool {
+	if q.From != "" && !msg.Involves(q.From) {
+		return false
+	}
+	if q.Direction != "" && q.Direction != msg.Direction {
+		return false
+	}
+	if q.Since != 0 && msg.Date < q.Since {
+		return false
+	}
+	if q.Until != 0 && msg.Date >= q.Until {
+		return false
+	}
+	return true
+}
+
+// Filter runs query over Iterate, for stores without own index
+func Filter(s Store, q Query) ([]models.Message, error) {
+	result := []models.Message{}
+
+	err := s.Iterate(func(msg *models.Message) bool {
+		if q.Match(msg) {
+			result = append(result, *msg)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if q.Limit > 0 && q.Limit < len(result) {
+		result = result[len(result)-q.Limit:]
+	}
+
+	return result, nil
+}
+
+// View is store which also renders every appended message into views,
+// reading goes only to primary store
+type View struct {
+	Store
+	// OnError is called when view fails, message is already in primary store then
+	OnError func(err error)
+
+	views []Appender
+}
+
+func WithViews(primary Store, views ...Appender) *View {
+	return &View{Store: primary, views: views}
+}
+
+func (v *View) Append(msg *models.Message) error {
+	if err := v.Store.Append(msg); err != nil {
+		return err
+	}
+
+	for _, view := range v.views {
+		if err := view.Append(msg); err != nil && v.OnError != nil {
+			v.OnError(fmt.Errorf("store.View.Append: %w", err))
+		}
+	}
+
+	return nil
+}
+
+// UpdateStatus updates primary store and views which keep status of messages
+func (v *View) UpdateStatus(id, status string) error {
+	if err := v.Store.UpdateStatus(id, status); err != nil {
+		return err
+	}
+
+	for _, view := range v.views {
+		if u, ok := view.(StatusUpdater); ok {
+			if err := u.UpdateStatus(id, status); err != nil && v.OnError != nil {
+				v.OnError(fmt.Errorf("store.View.UpdateStatus: %w", err))
+			}
+		}
+	}
+
+	return nil
+}
+
+// UpdateDelivery updates primary store and views which keep results of recipients (text table)
+func (v *View) UpdateDelivery(id string, d models.Delivery) error {
+	if err := v.Store.UpdateDelivery(id, d); err != nil {
+		return err
+	}
+
+	for _, view := range v.views {
+		if u, ok := view.(DeliveryUpdater); ok {
+			if err := u.UpdateDelivery(id, d); err != nil && v.OnError != nil {
+				v.OnError(fmt.Errorf("store.View.UpdateDelivery: %w", err))
+			}
+		}
+	}
+
+	return nil
+}
+
+// AppendBatch writes msgs to primary store and views at once when they support it
+func (v *View) AppendBatch(msgs []*models.Message) error {
+	if err := appendBatch(v.Store, msgs); err != nil {
+		return err
+	}
+
+	for _, view := range v.views {
+		if err := appendBatch(view, msgs); err != nil && v.OnError != nil {
+			v.OnError(fmt.Errorf("store.View.AppendBatch: %w", err))
+		}
+	}
+
+	return nil
+}
+
+// Sync flushes primary store and views which support it
+func (v *View) Sync() error {
+	if batcher, ok := v.Store.(Batcher); ok {
+		if err := batcher.Sync(); err != nil {
+			return err
+		}
+	}
+
+	for _, view := range v.views {
+		if batcher, ok := view.(Batcher); ok {
+			if err := batcher.Sync(); err != nil && v.OnError != nil {
+				v.OnError(fmt.Errorf("store.View.Sync: %w", err))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Replace replaces messages of primary store and of views which support it
+func (v *View) Replace(msgs []models.Message) error {
+	replacer, ok := v.Store.(Replacer)
+	if !ok {
+		return fmt.Errorf("store.View.Replace: %w", ErrUnsupported)
+	}
+
+	if err := replacer.Replace(msgs); err != nil {
+		return err
+	}
+
+	for _, view := range v.views {
+		if r, ok := view.(Replacer); ok {
+			if err := r.Replace(msgs); err != nil && v.OnError != nil {
+				v.OnError(fmt.Errorf("store.View.Replace: %w", err))
+			}
+		}
+	}
+
+	return nil
+}
+
+func (v *View) Size() (int64, error) {
+	if sizer, ok := v.Store.(Sizer); ok {
+		return sizer.Size()
+	}
+	return 0, nil
+}
+
+/* ======== internal ======== */
+
+// appendBatch appends msgs in one batch or one by one
+func appendBatch(a Appender, msgs []*models.Message) error {
+	if batcher, ok := a.(Batcher); ok {
+		return batcher.AppendBatch(msgs)
+	}
+
+	for _, msg := range msgs {
+		if err := a.Append(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"ipmsg/pkg/filelock"
+	"ipmsg/pkg/fileparser"
+	"ipmsg/pkg/models"
+	"os"
+	"sync"
+	"time"
+)
+
+// Text keeps messages in human readable table (ipmsg.txt, see fileparser for format), it does not keep
+// ids, flags or statuses, so ids are derived from message content. Sent messages keep recipients and
+// their results, but not own address
+type Text struct {
+	filePath string
+	// Names returns address - alias map used to render sender, may be nil
+	Names func() (map[string]string, error)
+
+	mu sync.Mutex
+}
+
+func NewText(path string, names func() (map[string]string, error)) *Text {
+	return &Text{filePath: path, Names: names}
+}
+
+func (s *Text) Append(msg *models.Message) error {
+	return s.AppendBatch([]*models.Message{msg})
+}
+
+// AppendBatch writes msgs with one write under file lock, so readers never see half of record
+func (s *Text) AppendBatch(msgs []*models.Message) error {
+	const op = "store.Text.AppendBatch"
+
+	names := map[string]string{}
+	if s.Names != nil {
+		var err error
+		if names, err = s.Names(); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := filelock.For(s.filePath).Lock()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	file, err := os.OpenFile(s.filePath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer file.Close()
+
+	// old files keep their format until they are migrated
+	version, err := fileparser.DetectVersion(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	var buf bytes.Buffer
+	if version == 0 {
+		version = fileparser.Version
+		if err := fileparser.WriteHeader(&buf, version); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	for _, msg := range msgs {
+		req := msg.Request()
+		if err := fileparser.WriteEntry(&buf, version, textFrom(names, msg), &req); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Sync flushes file to disk
+func (s *Text) Sync() error {
+	return syncFile(s.filePath)
+}
+
+func (s *Text) Iterate(fn func(msg *models.Message) bool) error {
+	const op = "store.Text.Iterate"
+
+	s.mu.Lock()
+	requests, err := fileparser.ParseFile(s.filePath) // takes file lock for reading
+	s.mu.Unlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, req := range requests {
+		msg := textMessage(&req)
+		if !fn(&msg) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (s *Text) Query(q Query) ([]models.Message, error) {
+	return Filter(s, q)
+}
+
+func (s *Text) UpdateStatus(id, status string) error {
+	return fmt.Errorf("store.Text.UpdateStatus: %w", ErrUnsupported)
+}
+
+// Replace rewrites file with msgs in current format
+func (s *Text) Replace(msgs []models.Message) error {
+	const op = "store.Text.Replace"
+
+	names := map[string]string{}
+	if s.Names != nil {
+		var err error
+		if names, err = s.Names(); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := filelock.For(s.filePath).Lock()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	if err := s.replace(names, msgs); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UpdateDelivery rewrites file with new result of recipient, table keeps recipients and their
+// results, so sent messages can be updated, but only in current format
+func (s *Text) UpdateDelivery(id string, d models.Delivery) error {
+	const op = "store.Text.UpdateDelivery"
+
+	names := map[string]string{}
+	if s.Names != nil {
+		var err error
+		if names, err = s.Names(); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// file is read and rewritten under one lock, so messages appended meanwhile are not lost
+	unlock, err := filelock.For(s.filePath).Lock()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	version, err := fileparser.FileVersion(s.filePath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if version != fileparser.Version {
+		return fmt.Errorf("%s: old file format: %w", op, ErrUnsupported)
+	}
+
+	file, err := os.Open(s.filePath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	requests, err := fileparser.Parse(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	found := false
+	msgs := make([]models.Message, len(requests))
+	for i := range requests {
+		msgs[i] = textMessage(&requests[i])
+		if msgs[i].ID == id {
+			found = msgs[i].SetDelivery(d)
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s: %s to %s: %w", op, id, d.To, ErrNotFound)
+	}
+
+	if err := s.replace(names, msgs); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *Text) Size() (int64, error) {
+	return fileSize(s.filePath)
+}
+
+/* ======== internal ======== */
+
+// replace writes msgs over file in current format, file lock must be held
+func (s *Text) replace(names map[string]string, msgs []models.Message) error {
+	return replaceFile(s.filePath, func(w io.Writer) error {
+		if err := fileparser.WriteHeader(w, fileparser.Version); err != nil {
+			return err
+		}
+
+		for i := range msgs {
+			req := msgs[i].Request()
+			if err := fileparser.WriteEntry(w, fileparser.Version, textFrom(names, &msgs[i]), &req); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// textMessage builds message from table record, record with recipients is sent message
+func textMessage(req *models.IPmsgRequest) models.Message {
+	msg := models.Message{
+		ID:        textID(req),
+		Direction: models.DirectionIn,
+		From:      req.From,
+		Alias:     req.Alias,
+		Date:      req.Date,
+		Received:  time.Unix(req.Date, 0),
+		Len:       req.Len,
+		Msg:       req.Msg,
+	}
+	if len(req.Deliveries) > 0 {
+		msg.Direction = models.DirectionOut
+		msg.Deliveries = req.Deliveries
+		msg.Status = models.DeliveryStatus(req.Deliveries)
+		for _, d := range req.Deliveries {
+			msg.To = append(msg.To, d.To)
+		}
+	}
+
+	return msg
+}
+
+func textID(req *models.IPmsgRequest) string {
+	if len(req.Deliveries) > 0 {
+		to := make([]string, len(req.Deliveries))
+		for i, d := range req.Deliveries {
+			to[i] = d.To
+		}
+		return models.SentID(req.Date, req.Msg, to)
+	}
+
+	return models.ReceivedID(req.Date, req.From, req.Msg)
+}
+
+// textFrom renders sender like alex(192.168.1.5), alias file wins over alias of message.
+// Sent message is rendered with its recipients
+func textFrom(names map[string]string, msg *models.Message) string {
+	if msg.Direction == models.DirectionOut {
+		deliveries := msg.Deliveries
+		if len(deliveries) == 0 {
+			// imported sent messages have only recipients
+			for _, to := range msg.To {
+				deliveries = append(deliveries, models.Delivery{To: to, Delivered: true, Status: models.StatusSent})
+			}
+		}
+		return fileparser.FormatRecipients(deliveries, names)
+	}
+
+	alias := msg.Alias
+	if name, exists := names[msg.From]; exists {
+		alias = name
+	}
+	return fileparser.FormatSender(alias, msg.From)
+}
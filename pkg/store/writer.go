@@ -0,0 +1,237 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"ipmsg/pkg/models"
+	"sync"
+	"time"
+)
+
+const (
+	SyncAlways   = "always"   // fsync after every batch
+	SyncInterval = "interval" // fsync at most once per interval
+	SyncNever    = "never"    // leave flushing to system
+)
+
+var (
+	ErrClosed error = errors.New("store is closed")
+)
+
+// Batcher is store which writes several messages at once and can flush them to disk
+type Batcher interface {
+	AppendBatch(msgs []*models.Message) error
+	Sync() error
+}
+
+type WriterOptions struct {
+	Sync         string        // SyncAlways, SyncInterval or SyncNever
+	SyncInterval time.Duration // for SyncInterval
+	QueueSize    int
+	MaxBatch     int
+}
+
+func DefaultWriterOptions() WriterOptions {
+	return WriterOptions{
+		Sync:         SyncAlways,
+		SyncInterval: time.Second,
+		QueueSize:    256,
+		MaxBatch:     64,
+	}
+}
+
+// Writer passes every write of store through one goroutine, messages which come
+// while previous batch is written go to disk together, Append returns when
+// message is written and synced according to policy
+type Writer struct {
+	Store
+
+	opt   WriterOptions
+	queue chan *job
+	done  chan struct{}
+
+	closeOnce sync.Once
+	mu        sync.RWMutex // closing holds it for writing
+	closed    bool
+}
+
+type job struct {
+	msg *models.Message
+	fn  func() error // run alone when msg is nil
+	res chan error
+}
+
+// NewWriter starts writer goroutine, Close stops it
+func NewWriter(st Store, opt WriterOptions) *Writer {
+	def := DefaultWriterOptions()
+	if opt.Sync == "" {
+		opt.Sync = def.Sync
+	}
+	if opt.SyncInterval <= 0 {
+		opt.SyncInterval = def.SyncInterval
+	}
+	if opt.QueueSize <= 0 {
+		opt.QueueSize = def.QueueSize
+	}
+	if opt.MaxBatch <= 0 {
+		opt.MaxBatch = def.MaxBatch
+	}
+
+	w := &Writer{
+		Store: st,
+		opt:   opt,
+		queue: make(chan *job, opt.QueueSize),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+
+	return w
+}
+
+func (w *Writer) Append(msg *models.Message) error {
+	return w.do(&job{msg: msg})
+}
+
+// UpdateStatus is queued after messages appended before it
+func (w *Writer) UpdateStatus(id, status string) error {
+	return w.do(&job{fn: func() error { return w.Store.UpdateStatus(id, status) }})
+}
+
+// UpdateDelivery is queued after messages appended before it
+func (w *Writer) UpdateDelivery(id string, d models.Delivery) error {
+	return w.do(&job{fn: func() error { return w.Store.UpdateDelivery(id, d) }})
+}
+
+// Replace waits for queued messages and replaces store
+func (w *Writer) Replace(msgs []models.Message) error {
+	replacer, ok := w.Store.(Replacer)
+	if !ok {
+		return fmt.Errorf("store.Writer.Replace: %w", ErrUnsupported)
+	}
+
+	return w.do(&job{fn: func() error { return replacer.Replace(msgs) }})
+}
+
+func (w *Writer) Size() (int64, error) {
+	sizer, ok := w.Store.(Sizer)
+	if !ok {
+		return 0, fmt.Errorf("store.Writer.Size: %w", ErrUnsupported)
+	}
+	return sizer.Size()
+}
+
+// Close writes queued messages, syncs store and stops writer
+func (w *Writer) Close() error {
+	w.closeOnce.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		close(w.queue)
+		w.mu.Unlock()
+	})
+	<-w.done
+
+	if batcher, ok := w.Store.(Batcher); ok {
+		return batcher.Sync()
+	}
+	return nil
+}
+
+/* ======== internal ======== */
+
+func (w *Writer) do(j *job) error {
+	j.res = make(chan error, 1)
+
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return ErrClosed
+	}
+	w.queue <- j
+	w.mu.RUnlock()
+
+	return <-j.res
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+
+	batcher, _ := w.Store.(Batcher)
+
+	ticker := time.NewTicker(w.opt.SyncInterval)
+	defer ticker.Stop()
+	dirty := false
+
+	var next *job
+	for {
+		if next == nil {
+			select {
+			case j, ok := <-w.queue:
+				if !ok {
+					if dirty {
+						batcher.Sync()
+					}
+					return
+				}
+				next = j
+			case <-ticker.C:
+				if dirty {
+					batcher.Sync()
+					dirty = false
+				}
+				continue
+			}
+		}
+
+		if next.msg == nil {
+			next.res <- next.fn()
+			next = nil
+			continue
+		}
+
+		// take messages already waiting in queue, stop at first other job
+		batch := []*job{next}
+		next = nil
+	collect:
+		for len(batch) < w.opt.MaxBatch {
+			select {
+			case j, ok := <-w.queue:
+				if !ok {
+					break collect
+				}
+				if j.msg == nil {
+					next = j
+					break collect
+				}
+				batch = append(batch, j)
+			default:
+				break collect
+			}
+		}
+
+		if batcher == nil {
+			for _, j := range batch {
+				j.res <- w.Store.Append(j.msg)
+			}
+			continue
+		}
+
+		msgs := make([]*models.Message, len(batch))
+		for i, j := range batch {
+			msgs[i] = j.msg
+		}
+
+		err := batcher.AppendBatch(msgs)
+		if err == nil {
+			switch w.opt.Sync {
+			case SyncAlways:
+				err = batcher.Sync()
+			case SyncInterval:
+				dirty = true
+			}
+		}
+
+		for _, j := range batch {
+			j.res <- err
+		}
+	}
+}
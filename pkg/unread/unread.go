@@ -0,0 +1,226 @@
+// package for read state of received messages, state is kept in json file beside
+// message store, so it works with every store including plain text one. Server records
+// every message when it arrives, message is unread until it is marked read:
+//
+//	{"unread": {"t3f2a..": {"date": 1704207845, "received": 1704207990}}}
+//
+// messages are keyed by their content (see Key), so key is the same in every store and
+// does not depend on clock of sender. Date is sender time, history is read from the oldest one
+package unread
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ipmsg/pkg/filelock"
+	"ipmsg/pkg/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type State struct {
+	Unread map[string]Arrival `json:"unread,omitempty"` // key of message - its arrival
+}
+
+type Arrival struct {
+	Date     int64 `json:"date"`     // sender time, unix seconds
+	Received int64 `json:"received"` // local time message arrived
+}
+
+type Tracker struct {
+	filePath string
+}
+
+func New(path string) *Tracker {
+	return &Tracker{filePath: path}
+}
+
+// Key returns key of received message, it is id text store gives to message
+func Key(m *models.Message) string {
+	return models.ReceivedID(m.Date, m.From, m.Msg)
+}
+
+// Since returns sender time of oldest unread message, ok is false if everything is read
+func (st *State) Since() (since int64, ok bool) {
+	for _, a := range st.Unread {
+		if !ok || a.Date < since {
+			since, ok = a.Date, true
+		}
+	}
+	return since, ok
+}
+
+// Load returns read state, missing file means everything is read
+func (t *Tracker) Load() (State, error) {
+	const op = "unread.Load"
+
+	unlock, err := filelock.For(t.filePath).RLock()
+	if err != nil {
+		return State{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	st, err := t.load()
+	if err != nil {
+		return State{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return st, nil
+}
+
+// Arrived records received msgs as unread, sent ones are skipped
+func (t *Tracker) Arrived(msgs ...models.Message) error {
+	return t.update(func(st *State) {
+		if st.Unread == nil {
+			st.Unread = map[string]Arrival{}
+		}
+		for _, m := range msgs {
+			if m.Direction == models.DirectionIn {
+				st.Unread[Key(&m)] = Arrival{Date: m.Date, Received: m.Received.Unix()}
+			}
+		}
+	})
+}
+
+// Unread returns received messages which are not read, in order of msgs
+func (t *Tracker) Unread(msgs []models.Message) ([]models.Message, error) {
+	st, err := t.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	res := []models.Message{}
+	for _, m := range msgs {
+		if m.Direction != models.DirectionIn {
+			continue
+		}
+		if _, unread := st.Unread[Key(&m)]; unread {
+			res = append(res, m)
+		}
+	}
+
+	return res, nil
+}
+
+// MarkRead marks msgs read
+func (t *Tracker) MarkRead(msgs ...models.Message) error {
+	return t.update(func(st *State) {
+		for _, m := range msgs {
+			delete(st.Unread, Key(&m))
+		}
+	})
+}
+
+// MarkAllRead marks everything which arrived up to until read
+func (t *Tracker) MarkAllRead(until time.Time) error {
+	return t.update(func(st *State) {
+		for key, a := range st.Unread {
+			if a.Received <= until.Unix() {
+				delete(st.Unread, key)
+			}
+		}
+	})
+}
+
+// BySender groups msgs by sender address, senders with newest messages go first
+func BySender(msgs []models.Message) []models.UnreadSender {
+	index := map[string]int{}
+	var res []models.UnreadSender
+
+	for _, m := range msgs {
+		key := strings.ToLower(m.From)
+		i, ok := index[key]
+		if !ok {
+			i = len(res)
+			index[key] = i
+			res = append(res, models.UnreadSender{From: m.From})
+		}
+
+		s := &res[i]
+		s.Count++
+		if m.Alias != "" {
+			s.Alias = m.Alias
+		}
+		if m.Received.After(s.Last) {
+			s.Last = m.Received
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Last.After(res[j].Last) })
+
+	return res
+}
+
+/* ======== internal ======== */
+
+// load reads state, file lock must be held
+func (t *Tracker) load() (State, error) {
+	data, err := os.ReadFile(t.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return State{}, nil
+	}
+	if err != nil {
+		return State{}, err
+	}
+
+	var st State
+	if len(bytes.TrimSpace(data)) == 0 {
+		return st, nil
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return State{}, err
+	}
+
+	return st, nil
+}
+
+func (t *Tracker) update(fn func(st *State)) error {
+	const op = "unread.update"
+
+	unlock, err := filelock.For(t.filePath).Lock()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer unlock()
+
+	st, err := t.load()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	fn(&st)
+
+	if err := t.save(st); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// save writes state to temp file and renames it over state file
+func (t *Tracker) save(st State) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(t.filePath), ".unread-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), t.filePath)
+}